@@ -7,8 +7,17 @@ import (
 	"path"
 )
 
-func EmitLLVM(filePath, outputPath string, doOptimize bool) error {
-	llPath, err := GenLl(filePath, doOptimize)
+type Options struct {
+	Optimize   bool
+	ARC        bool
+	MemCheck   bool
+	Target     string
+	Defines    map[string]string
+	StrictInit bool
+}
+
+func EmitLLVM(filePath, outputPath string, opts Options) error {
+	llPath, err := GenLl(filePath, opts)
 	if err != nil {
 		return err
 	}
@@ -27,8 +36,8 @@ func EmitLLVM(filePath, outputPath string, doOptimize bool) error {
 	return err
 }
 
-func Build(filePath, outputPath string, doOptimize bool) error {
-	llPath, err := GenLl(filePath, doOptimize)
+func Build(filePath, outputPath string, opts Options) error {
+	llPath, err := GenLl(filePath, opts)
 	if err != nil {
 		return err
 	}
@@ -39,15 +48,21 @@ func Build(filePath, outputPath string, doOptimize bool) error {
 	}
 
 	// 実行可能ファイルにコンパイルする
-	err = buildLlFile(llPath, outputPath, doOptimize)
+	err = buildLlFile(llPath, outputPath, opts)
 	return err
 }
 
-func GenLl(filePath string, doOptimize bool) (string, error) {
+func GenLl(filePath string, opts Options) (string, error) {
 	// .slファイルをコンパイルする
 	c := compiler.New()
+	c.ARC = opts.ARC
+	c.MemCheck = opts.MemCheck
+	c.Optimized = opts.Optimize
+	c.Target = opts.Target
+	c.Defines = opts.Defines
+	c.StrictInit = opts.StrictInit
 	c.Compile(filePath).ShowExit(false)
-	if doOptimize {
+	if opts.Optimize {
 		c.Optimize()
 	}
 
@@ -74,7 +89,7 @@ func GenLl(filePath string, doOptimize bool) (string, error) {
 		}
 
 		llPath = path.Join(tmpDir, getFileNameWithoutExt(includedFile)+".c.ll")
-		err = buildIncludedFile(includedFile, llPath, doOptimize)
+		err = buildIncludedFile(includedFile, llPath, opts)
 		if err != nil {
 			return "", err
 		}
@@ -5,7 +5,7 @@ import (
 	"os/exec"
 )
 
-func buildLlFile(path, outputPath string, doOptimize bool) error {
+func buildLlFile(path, outputPath string, opts Options) error {
 	clangArgs := []string{
 		"-Wno-override-module",
 		"-lm",
@@ -13,20 +13,24 @@ func buildLlFile(path, outputPath string, doOptimize bool) error {
 		"-o", outputPath,
 	}
 
-	if doOptimize {
+	if opts.Optimize {
 		clangArgs = append(clangArgs, "-O3")
 		clangArgs = append(clangArgs, "-flto")
 	} else {
 		clangArgs = append(clangArgs, "-O0")
 	}
 
+	if opts.Target != "" {
+		clangArgs = append(clangArgs, "-target", opts.Target)
+	}
+
 	cmd := exec.Command("clang", clangArgs...)
 	cmd.Stderr = os.Stderr
 	err := cmd.Run()
 	return err
 }
 
-func buildIncludedFile(includedFile, outputPath string, doOptimize bool) error {
+func buildIncludedFile(includedFile, outputPath string, opts Options) error {
 	args := []string{
 		"-S",
 		"-emit-llvm",
@@ -34,12 +38,16 @@ func buildIncludedFile(includedFile, outputPath string, doOptimize bool) error {
 		includedFile,
 	}
 
-	if doOptimize {
+	if opts.Optimize {
 		args = append(args, "-O3")
 	} else {
 		args = append(args, "-O0")
 	}
 
+	if opts.Target != "" {
+		args = append(args, "-target", opts.Target)
+	}
+
 	cmd := exec.Command("clang", args...)
 	cmd.Stderr = os.Stderr
 	err := cmd.Run()
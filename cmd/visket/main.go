@@ -7,10 +7,34 @@ import (
 	"github.com/arata-nvm/visket/compiler/errors"
 	"log"
 	"os"
+	"strings"
 )
 
 const VERSION = "0.0.1"
 
+// defines is the value of '-D name=value' flags, which can be repeated.
+type defines map[string]string
+
+func (d defines) String() string {
+	var s []string
+	for name, value := range d {
+		s = append(s, name+"="+value)
+	}
+	return strings.Join(s, ",")
+}
+
+func (d defines) Set(s string) error {
+	name, value := s, "true"
+	if i := strings.Index(s, "="); i != -1 {
+		name, value = s[:i], s[i+1:]
+	}
+	if name == "" {
+		return fmt.Errorf("missing name in '%s'", s)
+	}
+	d[name] = value
+	return nil
+}
+
 func main() {
 	var (
 		//isDebug  = flag.Bool("v", false, "Emit debug information")
@@ -18,7 +42,13 @@ func main() {
 		output    = flag.String("o", "", "Write output to <filename>")
 		emitLLVM  = flag.Bool("emit-llvm", false, "Generate output in LLVM formats")
 		useColors = flag.Bool("color", false, "Use colors")
+		arc       = flag.Bool("arc", false, "Enable automatic reference counting of heap objects")
+		memCheck  = flag.Bool("memcheck", false, "Report heap objects leaked at exit")
+		target    = flag.String("target", "", "Generate code for the target triple")
+		strict    = flag.Bool("strict-init", false, "Report reads of possibly unassigned variables as errors")
+		defs      = defines{}
 	)
+	flag.Var(defs, "D", "Define <name>=<value> for when conditions")
 	flag.Parse()
 
 	filename := flag.Arg(0)
@@ -32,13 +62,22 @@ func main() {
 
 	fmt.Printf("Compiling %s\n", filename)
 
+	opts := build.Options{
+		Optimize:   *optimize,
+		ARC:        *arc,
+		MemCheck:   *memCheck,
+		Target:     *target,
+		Defines:    defs,
+		StrictInit: *strict,
+	}
+
 	if *emitLLVM {
-		err := build.EmitLLVM(filename, *output, *optimize)
+		err := build.EmitLLVM(filename, *output, opts)
 		if err != nil {
 			log.Fatal(err)
 		}
 	} else {
-		err := build.Build(filename, *output, *optimize)
+		err := build.Build(filename, *output, opts)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -14,9 +14,12 @@ type Expression interface {
 }
 
 type Program struct {
-	Functions []*FunctionStatement
-	Structs   []*StructStatement
-	Globals   []*VarStatement
-	Modules   []*ModuleStatement
-	Includes  []*IncludeStatement
+	Functions  []*FunctionStatement
+	Structs    []*StructStatement
+	Interfaces []*InterfaceStatement
+	Types      []*TypeStatement
+	Globals    []*VarStatement
+	Modules    []*ModuleStatement
+	Includes   []*IncludeStatement
+	Asserts    []*StaticAssertStatement
 }
@@ -7,6 +7,9 @@ import (
 type Identifier struct {
 	Pos  token.Position
 	Name string
+	// IsGlobal is set for a free name in the body of a macro, which refers to
+	// the top level where the macro is declared instead of the call site
+	IsGlobal bool
 }
 
 func (i *Identifier) expressionNode() {}
@@ -39,6 +42,12 @@ type CharLiteral struct {
 
 func (cl *CharLiteral) expressionNode() {}
 
+type NilLiteral struct {
+	Pos token.Position
+}
+
+func (nl *NilLiteral) expressionNode() {}
+
 type PrefixExpression struct {
 	OpPos token.Position
 	Op    string
@@ -67,13 +76,95 @@ func (rs *AssignExpression) expressionNode() {}
 
 type CallExpression struct {
 	Function *Identifier
-	LParen   token.Position
-	Args     []Expression
-	RParen   token.Position
+	// Callee is set instead of Function when an expression other than a name is called
+	Callee Expression
+	LParen token.Position
+	Args   []Expression
+	RParen token.Position
+
+	// IsMethod is set for calls like 'x.f()', where x is the first argument
+	IsMethod bool
 }
 
 func (ce *CallExpression) expressionNode() {}
 
+// NamedArgument is an argument passed by the name of the parameter like 'x: 1'.
+type NamedArgument struct {
+	Name  *Identifier
+	Value Expression
+}
+
+func (na *NamedArgument) expressionNode() {}
+
+// ComptimeExpression is evaluated at compile time, like 'comptime fib(20)'.
+type ComptimeExpression struct {
+	Comptime token.Position
+	Value    Expression
+}
+
+func (ce *ComptimeExpression) expressionNode() {}
+
+// IfExpression is an if statement used as a value like
+// 'if x < 0 { -1 } else { 1 }'. Each branch yields its last expression.
+type IfExpression struct {
+	Stmt *IfStatement
+}
+
+func (ie *IfExpression) expressionNode() {}
+
+// BlockExpression is a block used as a value like '{ val t = f(); t * 2 }'.
+type BlockExpression struct {
+	Block *BlockStatement
+}
+
+func (be *BlockExpression) expressionNode() {}
+
+// SizeofExpression is the size of a type in bytes like 'sizeof(int)'.
+type SizeofExpression struct {
+	Sizeof token.Position
+	Type   *Type
+}
+
+func (se *SizeofExpression) expressionNode() {}
+
+// AlignofExpression is the alignment of a type in bytes like 'alignof(int)'.
+type AlignofExpression struct {
+	Alignof token.Position
+	Type    *Type
+}
+
+func (ae *AlignofExpression) expressionNode() {}
+
+// OffsetofExpression is the offset of a member of a struct in bytes like
+// 'offsetof(Point, y)'.
+type OffsetofExpression struct {
+	Offsetof token.Position
+	Type     *Type
+	Member   *Identifier
+}
+
+func (oe *OffsetofExpression) expressionNode() {}
+
+// SpreadArgument passes the elements of an array or a slice to a variadic
+// parameter like 'xs...'.
+type SpreadArgument struct {
+	Value    Expression
+	Ellipsis token.Position
+}
+
+func (sa *SpreadArgument) expressionNode() {}
+
+// FunctionLiteral is an anonymous function like 'fun [k](x: int): int { ... }'.
+// The variables in Copies are captured by copy, and the others by reference.
+type FunctionLiteral struct {
+	Func   token.Position
+	Copies []*Identifier
+	Sig    *FunctionSignature
+	Body   *BlockStatement
+}
+
+func (fl *FunctionLiteral) expressionNode() {}
+
 type IndexExpression struct {
 	Left   Expression
 	LBrack token.Position
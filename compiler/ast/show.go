@@ -12,6 +12,12 @@ func Show(node Node) string {
 		for _, stmt := range node.Structs {
 			b.WriteString(Show(stmt))
 		}
+		for _, stmt := range node.Interfaces {
+			b.WriteString(Show(stmt))
+		}
+		for _, stmt := range node.Types {
+			b.WriteString(Show(stmt))
+		}
 		for _, stmt := range node.Globals {
 			b.WriteString(Show(stmt))
 		}
@@ -24,6 +30,9 @@ func Show(node Node) string {
 		for _, stmt := range node.Includes {
 			b.WriteString(Show(stmt))
 		}
+		for _, stmt := range node.Asserts {
+			b.WriteString(Show(stmt))
+		}
 		return b.String()
 	case *Identifier:
 		return node.Name
@@ -35,6 +44,8 @@ func Show(node Node) string {
 		return fmt.Sprintf("\"%s\"", node.Value)
 	case *CharLiteral:
 		return fmt.Sprintf("'%c'", node.Value)
+	case *NilLiteral:
+		return "nil"
 	case *PrefixExpression:
 		return fmt.Sprintf("(%s%s)", node.Op, Show(node.Right))
 	case *InfixExpression:
@@ -49,11 +60,50 @@ func Show(node Node) string {
 			}
 			b.WriteString(Show(arg))
 		}
+		if node.Callee != nil {
+			return fmt.Sprintf("(func-call %s(%s))", Show(node.Callee), b.String())
+		}
 		return fmt.Sprintf("(func-call %s(%s))", Show(node.Function), b.String())
+	case *NamedArgument:
+		return fmt.Sprintf("%s: %s", Show(node.Name), Show(node.Value))
+	case *ComptimeExpression:
+		return fmt.Sprintf("(comptime %s)", Show(node.Value))
+	case *IfExpression:
+		return Show(node.Stmt)
+	case *BlockExpression:
+		return fmt.Sprintf("{%s}", Show(node.Block))
+	case *SizeofExpression:
+		return fmt.Sprintf("(sizeof %s)", Show(node.Type))
+	case *AlignofExpression:
+		return fmt.Sprintf("(alignof %s)", Show(node.Type))
+	case *OffsetofExpression:
+		return fmt.Sprintf("(offsetof %s %s)", Show(node.Type), node.Member.Name)
+	case *SpreadArgument:
+		return fmt.Sprintf("%s...", Show(node.Value))
+	case *FunctionLiteral:
+		var copies bytes.Buffer
+		if len(node.Copies) != 0 {
+			copies.WriteString("[")
+			for i, c := range node.Copies {
+				if i != 0 {
+					copies.WriteString(", ")
+				}
+				copies.WriteString(Show(c))
+			}
+			copies.WriteString("]")
+		}
+		var params bytes.Buffer
+		for i, p := range node.Sig.Params {
+			if i != 0 {
+				params.WriteString(", ")
+			}
+			params.WriteString(Show(p))
+		}
+		return fmt.Sprintf("(fun %s(%s): %s (%s))", copies.String(), params.String(), Show(node.Sig.RetType), Show(node.Body))
 	case *IndexExpression:
 		return fmt.Sprintf("(%s[%s])", Show(node.Left), Show(node.Index))
 	case *NewExpression:
-		return fmt.Sprintf("(new %s)", node.Type.Name)
+		return fmt.Sprintf("(new %s)", Show(node.Type))
 	case *LoadMemberExpression:
 		return fmt.Sprintf("(%s.%s)", Show(node.Left), node.MemberIdent.Name)
 	case *ModuleStatement:
@@ -82,16 +132,34 @@ func Show(node Node) string {
 			}
 			b.WriteString(Show(p))
 		}
-		return fmt.Sprintf("(def-func %s(%s): %s (%s))", Show(node.Ident), b.String(), Show(node.Sig.RetType), Show(node.Body))
+		name := Show(node.Ident)
+		if node.Receiver != nil {
+			name = fmt.Sprintf("%s.%s", Show(node.Receiver), name)
+		}
+		def := "def-func"
+		if node.IsConst {
+			def = "def-const-func"
+		}
+		return fmt.Sprintf("(%s %s%s%s(%s): %s (%s))", def, showAttributes(node.Attributes), name, showTypeParams(node.TypeParams), b.String(), Show(node.Sig.RetType), Show(node.Body))
 	case *Param:
 		ref := ""
-		if node.IsReference {
+		if node.IsReadOnly {
+			ref = "in "
+		} else if node.IsReference {
 			ref = "ref "
 		}
-		return fmt.Sprintf("%s%s: %s", ref, Show(node.Ident), Show(node.Type))
+		typ := Show(node.Type)
+		if node.IsVariadic {
+			typ = "..." + typ
+		}
+		if node.Default != nil {
+			return fmt.Sprintf("%s%s: %s = %s", ref, Show(node.Ident), typ, Show(node.Default))
+		}
+		return fmt.Sprintf("%s%s: %s", ref, Show(node.Ident), typ)
 	case *VarStatement:
 		var b bytes.Buffer
 		b.WriteString("(var ")
+		b.WriteString(showAttributes(node.Attributes))
 		b.WriteString(Show(node.Ident))
 		if node.Type != nil {
 			b.WriteString(": ")
@@ -108,6 +176,12 @@ func Show(node Node) string {
 			return "(return)"
 		}
 		return fmt.Sprintf("(return %s)", Show(node.Value))
+	case *DeleteStatement:
+		return fmt.Sprintf("(delete %s)", Show(node.Value))
+	case *StaticAssertStatement:
+		return fmt.Sprintf("(static_assert %s %s)", Show(node.Condition), Show(node.Message))
+	case *DeferStatement:
+		return fmt.Sprintf("(defer %s)", Show(node.Call))
 	case *IfStatement:
 		var b bytes.Buffer
 		b.WriteString("(if ")
@@ -149,7 +223,28 @@ func Show(node Node) string {
 		b.WriteString(" in ")
 		b.WriteString(Show(node.From))
 		b.WriteString("..")
+		if node.Exclusive {
+			b.WriteString("<")
+		}
 		b.WriteString(Show(node.To))
+		if node.Step != nil {
+			b.WriteString(" step ")
+			b.WriteString(Show(node.Step))
+		}
+		b.WriteString("(")
+		b.WriteString(Show(node.Body))
+		b.WriteString("))")
+		return b.String()
+	case *ForInStatement:
+		var b bytes.Buffer
+		b.WriteString("(for ")
+		if node.IndexName != nil {
+			b.WriteString(Show(node.IndexName))
+			b.WriteString(", ")
+		}
+		b.WriteString(Show(node.VarName))
+		b.WriteString(" in ")
+		b.WriteString(Show(node.Value))
 		b.WriteString("(")
 		b.WriteString(Show(node.Body))
 		b.WriteString("))")
@@ -158,6 +253,7 @@ func Show(node Node) string {
 		var b bytes.Buffer
 		b.WriteString("(struct ")
 		b.WriteString(Show(node.Ident))
+		b.WriteString(showTypeParams(node.TypeParams))
 		b.WriteString("(")
 		for i, m := range node.Members {
 			if i != 0 {
@@ -165,19 +261,102 @@ func Show(node Node) string {
 			}
 			b.WriteString(fmt.Sprintf("%s: %s", Show(m.Ident), Show(m.Type)))
 		}
+		b.WriteString(")")
+		for _, f := range node.Functions {
+			b.WriteString(Show(f))
+		}
+		b.WriteString(")")
+		return b.String()
+	case *TypeStatement:
+		if node.IsAlias {
+			return fmt.Sprintf("(type %s = %s)", Show(node.Ident), Show(node.Target))
+		}
+		return fmt.Sprintf("(type %s %s)", Show(node.Ident), Show(node.Target))
+	case *InterfaceStatement:
+		var b bytes.Buffer
+		b.WriteString("(interface ")
+		b.WriteString(Show(node.Ident))
+		b.WriteString("(")
+		for i, m := range node.Methods {
+			if i != 0 {
+				b.WriteString(", ")
+			}
+			var params bytes.Buffer
+			for j, p := range m.Sig.Params {
+				if j != 0 {
+					params.WriteString(", ")
+				}
+				params.WriteString(Show(p))
+			}
+			b.WriteString(fmt.Sprintf("%s(%s): %s", Show(m.Ident), params.String(), Show(m.Sig.RetType)))
+		}
 		b.WriteString("))")
 		return b.String()
 	case *Type:
-		var buf bytes.Buffer
+		if node.Typeof != nil {
+			return fmt.Sprintf("typeof(%s)", Show(node.Typeof))
+		}
 
 		if node.IsArray {
-			buf.WriteString(fmt.Sprintf("[%d]", node.Len))
+			return fmt.Sprintf("[%s]%s", Show(node.Len), Show(node.Elem))
+		}
+
+		if node.IsPointer {
+			return fmt.Sprintf("*%s", Show(node.Elem))
+		}
+
+		if node.IsFunc {
+			var b bytes.Buffer
+			for i, p := range node.Params {
+				if i != 0 {
+					b.WriteString(", ")
+				}
+				b.WriteString(Show(p))
+			}
+			return fmt.Sprintf("fun(%s): %s", b.String(), Show(node.RetType))
 		}
 
-		buf.WriteString(node.Name)
-		return buf.String()
+		if len(node.Args) != 0 {
+			var b bytes.Buffer
+			for i, arg := range node.Args {
+				if i != 0 {
+					b.WriteString(", ")
+				}
+				b.WriteString(Show(arg))
+			}
+			return fmt.Sprintf("%s<%s>", node.Name, b.String())
+		}
+
+		return node.Name
 	case *IncludeStatement:
 		return fmt.Sprintf("(include \"%s\")", node.File.Name)
 	}
 	return fmt.Sprintf("unknown: %s", node)
 }
+
+func showAttributes(attrs []*Attribute) string {
+	var b bytes.Buffer
+	for _, attr := range attrs {
+		b.WriteString("@" + attr.Name)
+		if attr.Value != nil {
+			b.WriteString(fmt.Sprintf("(%s)", Show(attr.Value)))
+		}
+		b.WriteString(" ")
+	}
+	return b.String()
+}
+
+func showTypeParams(params []*Identifier) string {
+	if len(params) == 0 {
+		return ""
+	}
+
+	var b bytes.Buffer
+	for i, p := range params {
+		if i != 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(Show(p))
+	}
+	return fmt.Sprintf("<%s>", b.String())
+}
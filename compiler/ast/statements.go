@@ -29,14 +29,40 @@ type ExpressionStatement struct {
 func (es *ExpressionStatement) statementNode() {}
 
 type FunctionStatement struct {
-	Func  token.Position
-	Ident *Identifier
-	Sig   *FunctionSignature
-	Body  *BlockStatement
+	Func token.Position
+	// struct the method is declared for, like 'Person' in 'fun Person.rename'
+	Receiver   *Identifier
+	Ident      *Identifier
+	TypeParams []*Identifier
+	Sig        *FunctionSignature
+	Body       *BlockStatement
+
+	// IsConst is set for 'const fun', which can be evaluated at compile time
+	IsConst bool
+
+	Attributes []*Attribute
 }
 
 func (fs *FunctionStatement) statementNode() {}
 
+// Attribute is an attribute of a declaration like '@inline' or
+// '@link_name("foo")'.
+type Attribute struct {
+	At    token.Position
+	Name  string
+	Value *StringLiteral
+}
+
+// FindAttribute returns the attribute named name in attrs.
+func FindAttribute(attrs []*Attribute, name string) (*Attribute, bool) {
+	for _, attr := range attrs {
+		if attr.Name == name {
+			return attr, true
+		}
+	}
+	return nil, false
+}
+
 type FunctionSignature struct {
 	Params  []*Param
 	RetType *Type
@@ -46,6 +72,14 @@ type Param struct {
 	Ident       *Identifier
 	Type        *Type
 	IsReference bool
+	// IsReadOnly is set for 'in x: T', which is passed by reference like 'ref'
+	// but cannot be modified
+	IsReadOnly bool
+	// IsVariadic is set for the last parameter like 'xs: ...int', which
+	// receives the rest of the arguments as a slice
+	IsVariadic bool
+	// Default is the value used when the argument is omitted
+	Default Expression
 }
 
 type VarStatement struct {
@@ -56,6 +90,8 @@ type VarStatement struct {
 	Value  Expression
 
 	IsConstant bool
+
+	Attributes []*Attribute
 }
 
 func (vs *VarStatement) statementNode() {}
@@ -63,9 +99,25 @@ func (vs *VarStatement) statementNode() {}
 type Type struct {
 	NamePos token.Position
 	Name    string
+	// type arguments of generic structs
+	Args []*Type
 
 	IsArray bool
-	Len     uint64
+	// length of arrays, which is a constant expression
+	Len Expression
+
+	IsPointer bool
+
+	// element type of arrays and pointers
+	Elem *Type
+
+	// function types like 'fun(int, int): int'
+	IsFunc  bool
+	Params  []*Type
+	RetType *Type
+
+	// types of expressions like 'typeof(x)'
+	Typeof Expression
 }
 
 type ReturnStatement struct {
@@ -75,6 +127,30 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode() {}
 
+type DeleteStatement struct {
+	Delete token.Position
+	Value  Expression
+}
+
+func (ds *DeleteStatement) statementNode() {}
+
+// StaticAssertStatement reports an error at compile time unless the condition
+// holds, like 'static_assert(sizeof(int) == 4, "int must be 32 bits")'.
+type StaticAssertStatement struct {
+	StaticAssert token.Position
+	Condition    Expression
+	Message      *StringLiteral
+}
+
+func (ss *StaticAssertStatement) statementNode() {}
+
+type DeferStatement struct {
+	Defer token.Position
+	Call  *CallExpression
+}
+
+func (ds *DeferStatement) statementNode() {}
+
 type IfStatement struct {
 	If          token.Position
 	Condition   Expression
@@ -103,28 +179,71 @@ type ForStatement struct {
 func (fs *ForStatement) statementNode() {}
 
 type ForRangeStatement struct {
-	For     token.Position
-	VarName *Identifier
-	In      token.Position
-	From    Expression
-	To      Expression
-	Body    *BlockStatement
+	For       token.Position
+	VarName   *Identifier
+	In        token.Position
+	From      Expression
+	To        Expression
+	Exclusive bool
+	// Step is nil if the range is stepped by 1
+	Step Expression
+	Body *BlockStatement
 }
 
 func (fs *ForRangeStatement) statementNode() {}
 
+// ForInStatement iterates over the elements of an array, a slice or a string,
+// or over the values returned by next() of Value.
+type ForInStatement struct {
+	For token.Position
+	// IndexName is nil if the index is not used
+	IndexName *Identifier
+	VarName   *Identifier
+	In        token.Position
+	Value     Expression
+	Body      *BlockStatement
+}
+
+func (fs *ForInStatement) statementNode() {}
+
 type StructStatement struct {
-	Struct  token.Position
-	Ident   *Identifier
-	LBrace  token.Position
-	Members []*MemberDecl
-	RBrace  token.Position
+	Struct     token.Position
+	Ident      *Identifier
+	TypeParams []*Identifier
+	LBrace     token.Position
+	Members    []*MemberDecl
+	RBrace     token.Position
+
+	// functions declared in the struct body, such as drop
+	Functions []*FunctionStatement
 
 	IsIncomplete bool
 }
 
 func (ss *StructStatement) statementNode() {}
 
+type InterfaceStatement struct {
+	Interface token.Position
+	Ident     *Identifier
+	LBrace    token.Position
+	// method signatures without the receiver
+	Methods []*FunctionStatement
+	RBrace  token.Position
+}
+
+func (is *InterfaceStatement) statementNode() {}
+
+// TypeStatement declares an alias like 'type Meters = float64', which is
+// interchangeable with the target, or a distinct type like 'type UserId int'.
+type TypeStatement struct {
+	TypePos token.Position
+	Ident   *Identifier
+	IsAlias bool
+	Target  *Type
+}
+
+func (ts *TypeStatement) statementNode() {}
+
 type MemberDecl struct {
 	Ident *Identifier
 	Type  *Type
@@ -0,0 +1,269 @@
+package codegen
+
+import (
+	"github.com/arata-nvm/visket/compiler/codegen/internal"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// isManaged reports whether values of typ hold references to heap objects
+// that are counted by ARC.
+func (c *CodeGen) isManaged(typ types.Type) bool {
+	if !c.options.ARC {
+		return false
+	}
+
+	// function values hold the environment of closures
+	if _, ok := funcSig(typ); ok {
+		return true
+	}
+
+	// interface values hold the data
+	if _, ok := c.findInterfaceType(typ); ok {
+		return true
+	}
+
+	// slices borrow their elements
+	if _, ok := c.sliceElem(typ); ok {
+		return false
+	}
+
+	switch typ := typ.(type) {
+	case *types.PointerType:
+		switch elm := typ.ElemType.(type) {
+		case *types.IntType:
+			// i8* is a raw pointer from C
+			return elm.BitSize != 8
+		case *types.FuncType:
+			return false
+		case *types.StructType:
+			return !elm.Opaque
+		}
+		return true
+	case *types.ArrayType:
+		return c.isManaged(typ.ElemType)
+	case *types.StructType:
+		for _, f := range typ.Fields {
+			if c.isManaged(f) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// genRefCount calls fn (runtime.retain or runtime.release) for every
+// reference stored at addr.
+func (c *CodeGen) genRefCount(block *ir.Block, fn *ir.Func, addr value.Value) {
+	typ := internal.PtrElmType(addr)
+
+	if _, ok := typ.(*types.PointerType); ok {
+		ptr := block.NewLoad(typ, addr)
+		block.NewCall(fn, block.NewBitCast(ptr, types.I8Ptr))
+		return
+	}
+
+	if _, ok := funcSig(typ); ok {
+		envAddr := block.NewGetElementPtr(typ, addr, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, 1))
+		block.NewCall(fn, block.NewLoad(types.I8Ptr, envAddr))
+		return
+	}
+
+	if _, ok := c.findInterfaceType(typ); ok {
+		dataAddr := block.NewGetElementPtr(typ, addr, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, 0))
+		block.NewCall(fn, block.NewLoad(types.I8Ptr, dataAddr))
+		return
+	}
+
+	block.NewCall(c.refCountHelper(fn, typ), addr)
+}
+
+// refCountHelper returns a function that applies fn to the references held by
+// an aggregate of typ.
+func (c *CodeGen) refCountHelper(fn *ir.Func, typ types.Type) *ir.Func {
+	name := fn.Name() + "." + typeName(typ)
+	if helper, ok := c.runtime.helpers[name]; ok {
+		return helper
+	}
+
+	param := ir.NewParam("ptr", types.NewPointer(typ))
+	helper := c.module.NewFunc(name, types.Void, param)
+	c.runtime.helpers[name] = helper
+
+	block := helper.NewBlock("entry")
+	zero := constant.NewInt(types.I32, 0)
+
+	switch typ := typ.(type) {
+	case *types.StructType:
+		for i, f := range typ.Fields {
+			if !c.isManaged(f) {
+				continue
+			}
+			field := block.NewGetElementPtr(typ, param, zero, constant.NewInt(types.I32, int64(i)))
+			c.genRefCount(block, fn, field)
+		}
+	case *types.ArrayType:
+		for i := uint64(0); i < typ.Len; i++ {
+			elm := block.NewGetElementPtr(typ, param, zero, constant.NewInt(types.I32, int64(i)))
+			c.genRefCount(block, fn, elm)
+		}
+	}
+
+	block.NewRet(nil)
+	return helper
+}
+
+// dropFunc returns the function that drops a heap object of typ and releases
+// the references held by it when it is freed.
+func (c *CodeGen) dropFunc(typ types.Type) constant.Constant {
+	if !c.isManaged(typ) && !c.isDroppable(typ) {
+		return constant.NewNull(dropFuncType)
+	}
+
+	name := "runtime.drop." + typeName(typ)
+	if drop, ok := c.runtime.helpers[name]; ok {
+		return drop
+	}
+
+	param := ir.NewParam("ptr", types.I8Ptr)
+	drop := c.module.NewFunc(name, types.Void, param)
+	c.runtime.helpers[name] = drop
+
+	block := drop.NewBlock("entry")
+	ptr := block.NewBitCast(param, types.NewPointer(typ))
+	if c.isDroppable(typ) {
+		c.genDrop(block, ptr)
+	}
+	if c.isManaged(typ) {
+		c.genRefCount(block, c.runtime.release, ptr)
+	}
+	block.NewRet(nil)
+
+	return drop
+}
+
+func typeName(typ types.Type) string {
+	if typ.Name() != "" {
+		return typ.Name()
+	}
+	return typ.LLString()
+}
+
+// genInit stores val into a fresh location, which then owns a reference.
+func (c *CodeGen) genInit(dest value.Value, val value.Value) {
+	c.contextBlock.NewStore(val, dest)
+
+	if c.isManaged(val.Type()) {
+		c.genRefCount(c.contextBlock, c.runtime.retain, dest)
+	}
+}
+
+// genAssign overwrites the value at dest with val. The new value is retained
+// before the old one is released, so that assigning a value to itself is safe.
+func (c *CodeGen) genAssign(dest value.Value, val value.Value) {
+	typ := val.Type()
+	if !c.isManaged(typ) {
+		c.contextBlock.NewStore(val, dest)
+		return
+	}
+
+	old := c.contextEntryBlock.NewAlloca(typ)
+	c.contextBlock.NewStore(c.contextBlock.NewLoad(typ, dest), old)
+
+	c.genInit(dest, val)
+	c.genRefCount(c.contextBlock, c.runtime.release, old)
+}
+
+// genTemporary keeps a reference owned by the expression val until the end of
+// the current statement. A droppable value is dropped there unless it is moved.
+func (c *CodeGen) genTemporary(val value.Value) Value {
+	typ := val.Type()
+	if !c.isManaged(typ) && !c.isDroppable(typ) {
+		return Value{Value: val}
+	}
+
+	tmp := c.contextEntryBlock.NewAlloca(typ)
+	c.contextEntryBlock.NewStore(constant.NewZeroInitializer(typ), tmp)
+	c.contextBlock.NewStore(val, tmp)
+
+	var flag value.Value
+	if c.isDroppable(typ) {
+		flag = c.newDropFlag()
+	}
+	c.contextTemporaries = append(c.contextTemporaries, dropVar{slot: tmp, flag: flag})
+
+	return Value{Value: val, DropFlag: flag}
+}
+
+func (c *CodeGen) releaseTemporaries() {
+	for _, tmp := range c.contextTemporaries {
+		typ := internal.PtrElmType(tmp.slot)
+		if tmp.flag != nil {
+			c.genDropVar(tmp)
+		}
+		if c.isManaged(typ) {
+			c.genRefCount(c.contextBlock, c.runtime.release, tmp.slot)
+			c.contextBlock.NewStore(constant.NewZeroInitializer(typ), tmp.slot)
+		}
+	}
+	c.contextTemporaries = nil
+}
+
+// own makes the current scope responsible for releasing the variable v.
+func (c *CodeGen) own(v value.Value) {
+	if c.isManaged(internal.PtrElmType(v)) {
+		c.context.owned = append(c.context.owned, v)
+	}
+}
+
+// releaseOwned drops and releases the variables of the scope ctx.
+func (c *CodeGen) releaseOwned(ctx *Context) {
+	for i := len(ctx.drops) - 1; i >= 0; i-- {
+		c.genDropVar(ctx.drops[i])
+	}
+
+	for i := len(ctx.owned) - 1; i >= 0; i-- {
+		c.genRefCount(c.contextBlock, c.runtime.release, ctx.owned[i])
+	}
+}
+
+// genReturn runs the deferred calls, releases everything owned by the function
+// and returns val.
+func (c *CodeGen) genReturn(val value.Value) {
+	c.releaseTemporaries()
+	c.genDeferredCalls(0)
+
+	for ctx := c.context; ctx != nil; ctx = ctx.parent {
+		c.releaseOwned(ctx)
+		if ctx == c.contextFunctionScope {
+			break
+		}
+	}
+
+	if c.contextFunction == c.mainFunc {
+		c.genMainExit()
+		val = constant.NewInt(types.I32, 0)
+	}
+
+	c.contextBlock.NewRet(val)
+
+	// the code after return is unreachable
+	c.contextBlock = c.contextFunction.NewBlock(internal.NextLabel("dead"))
+	c.contextBlock.NewUnreachable()
+}
+
+// genMainExit releases the global variables and reports the leaked objects.
+func (c *CodeGen) genMainExit() {
+	root := c.context
+	for root.parent != nil {
+		root = root.parent
+	}
+	c.releaseOwned(root)
+
+	if c.options.MemCheck {
+		c.contextBlock.NewCall(c.runtime.memcheck)
+	}
+}
@@ -0,0 +1,139 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/errors"
+)
+
+// argValue returns the value of the argument arg, which may be named or spread.
+func argValue(arg ast.Expression) ast.Expression {
+	switch arg := arg.(type) {
+	case *ast.NamedArgument:
+		return arg.Value
+	case *ast.SpreadArgument:
+		return arg.Value
+	}
+	return arg
+}
+
+// genArgs evaluates the arguments of expr in the order they are written.
+func (c *CodeGen) genArgs(expr *ast.CallExpression) []Value {
+	var args []Value
+	for _, arg := range expr.Args {
+		args = append(args, c.genPlace(argValue(arg)))
+	}
+	return args
+}
+
+// arrangeArgs matches the arguments of expr to params by position and by name.
+// It returns the index of the argument passed to each parameter, or -1 if the
+// default value is used. Otherwise it returns why the arguments do not match.
+func arrangeArgs(expr *ast.CallExpression, params []*ast.Param) ([]int, string) {
+	name := calleeName(expr)
+
+	order := make([]int, len(params))
+	for i := range order {
+		order[i] = -1
+	}
+
+	isNamed := false
+	for i, arg := range expr.Args {
+		if _, ok := arg.(*ast.SpreadArgument); ok {
+			return nil, fmt.Sprintf("cannot spread arguments in call to non-variadic function '%s'", name)
+		}
+
+		named, ok := arg.(*ast.NamedArgument)
+		if !ok {
+			if isNamed {
+				return nil, fmt.Sprintf("positional argument after named arguments in call to '%s'", name)
+			}
+			if i >= len(params) {
+				return nil, fmt.Sprintf("too many arguments in call to '%s'", name)
+			}
+			order[i] = i
+			continue
+		}
+
+		isNamed = true
+		id := -1
+		for j, p := range params {
+			if p.Ident.Name == named.Name.Name {
+				id = j
+			}
+		}
+
+		if id == -1 {
+			return nil, fmt.Sprintf("unknown parameter '%s' in call to '%s'", named.Name.Name, name)
+		}
+		if order[id] != -1 {
+			return nil, fmt.Sprintf("duplicate argument '%s' in call to '%s'", named.Name.Name, name)
+		}
+		order[id] = i
+	}
+
+	for i, p := range params {
+		if order[i] != -1 || p.Default != nil {
+			continue
+		}
+		if !isNamed {
+			return nil, fmt.Sprintf("not enough arguments in call to '%s'", name)
+		}
+		return nil, fmt.Sprintf("missing argument '%s' in call to '%s'", p.Ident.Name, name)
+	}
+
+	return order, ""
+}
+
+// arrangeCall reorders the arguments args of expr into the parameters of the
+// function declared by stmt, and fills the omitted ones with default values.
+func (c *CodeGen) arrangeCall(expr *ast.CallExpression, stmt *ast.FunctionStatement, args []Value) (*ast.CallExpression, []Value) {
+	if stmt == nil {
+		c.checkPositional(expr)
+		return expr, args
+	}
+
+	order, reason := arrangeArgs(expr, stmt.Sig.Params)
+	if reason != "" {
+		errors.ErrorExit(fmt.Sprintf("%s | %s", expr.LParen, reason))
+	}
+
+	call := *expr
+	call.Args = make([]ast.Expression, len(order))
+	arranged := make([]Value, len(order))
+	for i, id := range order {
+		if id != -1 {
+			call.Args[i] = argValue(expr.Args[id])
+			arranged[i] = args[id]
+			continue
+		}
+
+		call.Args[i] = stmt.Sig.Params[i].Default
+		arranged[i] = c.genDefault(call.Args[i])
+	}
+
+	return &call, arranged
+}
+
+// genDefault evaluates a default value, which can refer to global variables only.
+func (c *CodeGen) genDefault(expr ast.Expression) Value {
+	ctx := c.context
+	c.context = ctx.root()
+	v := c.genExpression(expr)
+	c.context = ctx
+
+	return v
+}
+
+// checkPositional reports an error if expr calls a function without parameter
+// names, like a function value, with named or spread arguments.
+func (c *CodeGen) checkPositional(expr *ast.CallExpression) {
+	for _, arg := range expr.Args {
+		switch arg.(type) {
+		case *ast.NamedArgument:
+			errors.ErrorExit(fmt.Sprintf("%s | cannot use named arguments in call to '%s'", expr.LParen, calleeName(expr)))
+		case *ast.SpreadArgument:
+			errors.ErrorExit(fmt.Sprintf("%s | cannot spread arguments in call to '%s'", expr.LParen, calleeName(expr)))
+		}
+	}
+}
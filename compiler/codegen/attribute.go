@@ -0,0 +1,168 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/codegen/internal"
+	"github.com/arata-nvm/visket/compiler/errors"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// hasFixedSymbol reports whether the symbol name of stmt must not be mangled.
+func hasFixedSymbol(attrs []*ast.Attribute) bool {
+	_, export := ast.FindAttribute(attrs, "export")
+	_, linkName := ast.FindAttribute(attrs, "link_name")
+	return export || linkName
+}
+
+// checkFuncAttributes reports the attributes that cannot be applied to stmt.
+func (c *CodeGen) checkFuncAttributes(funcName string, stmt *ast.FunctionStatement) {
+	if len(stmt.Attributes) == 0 {
+		return
+	}
+
+	if funcName == "main" {
+		errors.ErrorExit(fmt.Sprintf("%s | function 'main' cannot have attributes", stmt.Attributes[0].At))
+	}
+
+	if len(stmt.TypeParams) != 0 && hasFixedSymbol(stmt.Attributes) {
+		errors.ErrorExit(fmt.Sprintf("%s | generic function '%s' cannot have a fixed symbol name", stmt.Func, funcName))
+	}
+
+	if attr, ok := ast.FindAttribute(stmt.Attributes, "noreturn"); ok && stmt.Sig.RetType.Name != "void" {
+		errors.ErrorExit(fmt.Sprintf("%s | function '%s' with '@noreturn' cannot have a return type", attr.At, funcName))
+	}
+}
+
+// applyFuncAttributes maps the attributes of stmt to the LLVM function f.
+func (c *CodeGen) applyFuncAttributes(f *ir.Func, stmt *ast.FunctionStatement) {
+	for _, attr := range stmt.Attributes {
+		switch attr.Name {
+		case "inline":
+			f.FuncAttrs = append(f.FuncAttrs, enum.FuncAttrAlwaysInline)
+		case "noinline":
+			f.FuncAttrs = append(f.FuncAttrs, enum.FuncAttrNoInline)
+		case "cold":
+			f.FuncAttrs = append(f.FuncAttrs, enum.FuncAttrCold)
+		case "noreturn":
+			f.FuncAttrs = append(f.FuncAttrs, enum.FuncAttrNoReturn)
+		case "export":
+			c.export(f)
+		case "link_name":
+			c.linkNames = append(c.linkNames, attr)
+			f.SetName(attr.Value.Value)
+		}
+	}
+}
+
+// applyGlobalAttributes maps the attributes of stmt to the global variable g.
+func (c *CodeGen) applyGlobalAttributes(g *ir.Global, stmt *ast.VarStatement) {
+	for _, attr := range stmt.Attributes {
+		switch attr.Name {
+		case "export":
+			c.export(g)
+		case "link_name":
+			c.linkNames = append(c.linkNames, attr)
+			g.SetName(attr.Value.Value)
+		}
+	}
+}
+
+// export keeps the symbol v even if it is not used, so that the code linked
+// with the program can refer to it.
+func (c *CodeGen) export(v constant.Constant) {
+	c.exported = append(c.exported, constant.NewBitCast(v, types.I8Ptr))
+}
+
+// genExported lists the exported symbols in 'llvm.used'.
+func (c *CodeGen) genExported() {
+	if len(c.exported) == 0 {
+		return
+	}
+
+	arr := constant.NewArray(types.NewArray(uint64(len(c.exported)), types.I8Ptr), c.exported...)
+	used := c.module.NewGlobalDef("llvm.used", arr)
+	used.Linkage = enum.LinkageAppending
+	used.Section = "llvm.metadata"
+}
+
+// checkSymbols reports the symbol names given by '@link_name' that are also
+// used by other declarations. It runs after all the declarations are
+// registered, so that the ones following the attribute are also found.
+func (c *CodeGen) checkSymbols() {
+	for _, attr := range c.linkNames {
+		name := attr.Value.Value
+		count := 0
+		for _, f := range c.module.Funcs {
+			if f.Name() == name {
+				count++
+			}
+		}
+		for _, g := range c.module.Globals {
+			if g.Name() == name {
+				count++
+			}
+		}
+		if count > 1 {
+			errors.ErrorExit(fmt.Sprintf("%s | already declared symbol '%s'", attr.At, name))
+		}
+	}
+}
+
+// isNoReturn reports whether fn is a function with '@noreturn'.
+func isNoReturn(fn value.Value) bool {
+	f, ok := fn.(*ir.Func)
+	if !ok {
+		return false
+	}
+	for _, attr := range f.FuncAttrs {
+		if attr == enum.FuncAttrNoReturn {
+			return true
+		}
+	}
+	return false
+}
+
+// genNoReturn ends the current block after a call to a '@noreturn' function.
+func (c *CodeGen) genNoReturn() {
+	c.contextBlock.NewUnreachable()
+
+	// the code after the call is unreachable
+	c.contextBlock = c.contextFunction.NewBlock(internal.NextLabel("dead"))
+	c.contextBlock.NewUnreachable()
+	if c.contextInit != nil {
+		c.contextInit.unreachable = true
+	}
+}
+
+// checkNoReturn reports if the end of the body of the '@noreturn' function f
+// is reachable, which would return to the caller.
+func (c *CodeGen) checkNoReturn(f *ir.Func, body *ast.BlockStatement) {
+	if !isNoReturn(f) {
+		return
+	}
+
+	reached := map[*ir.Block]bool{f.Blocks[0]: true}
+	queue := []*ir.Block{f.Blocks[0]}
+	for len(queue) > 0 {
+		b := queue[0]
+		queue = queue[1:]
+		if b.Term == nil {
+			continue
+		}
+		for _, succ := range b.Term.Succs() {
+			if !reached[succ] {
+				reached[succ] = true
+				queue = append(queue, succ)
+			}
+		}
+	}
+
+	if reached[c.contextBlock] {
+		errors.ErrorExit(fmt.Sprintf("%s | end of '@noreturn' function is reachable", body.RBrace))
+	}
+}
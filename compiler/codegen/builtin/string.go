@@ -17,18 +17,24 @@ func newStringType() types.Type {
 	return t
 }
 
-func NewString(val string, block *ir.Block, module *ir.Module) value.Value {
+// NewCString returns a pointer to a null-terminated copy of val.
+func NewCString(val string, module *ir.Module) constant.Constant {
 	varName := internal.NextString()
 	constStr := module.NewGlobalDef(varName, constant.NewCharArrayFromString(val+"\x00"))
 	constStr.Linkage = enum.LinkagePrivate
 	constStr.UnnamedAddr = enum.UnnamedAddrUnnamedAddr
 
+	zero := constant.NewInt(types.I32, 0)
+	return constant.NewGetElementPtr(internal.PtrElmType(constStr), constStr, zero, zero)
+}
+
+func NewString(val string, block *ir.Block, module *ir.Module) value.Value {
 	zero := constant.NewInt(types.I32, 0)
 	one := constant.NewInt(types.I32, 1)
 
 	str := block.NewAlloca(STRING)
 	strVal := block.NewGetElementPtr(STRING, str, zero, zero)
-	block.NewStore(block.NewGetElementPtr(internal.PtrElmType(constStr), constStr, zero, zero), strVal)
+	block.NewStore(NewCString(val, module), strVal)
 	strLen := block.NewGetElementPtr(STRING, str, zero, one)
 	block.NewStore(constant.NewInt(types.I32, int64(len(val))), strLen)
 
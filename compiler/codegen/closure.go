@@ -0,0 +1,422 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/codegen/internal"
+	"github.com/arata-nvm/visket/compiler/errors"
+	"github.com/arata-nvm/visket/compiler/token"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Closure is the environment of a function literal or a nested function. The
+// environment struct grows as the body refers to variables of the enclosing
+// functions. Variables captured by reference are stored as i8* so that ARC
+// does not count them.
+type Closure struct {
+	Env      *types.StructType
+	Captures []*Capture
+
+	// env is the environment in the closure body
+	env   value.Value
+	entry *ir.Block
+	// copies holds the variables captured by copy, named in the copy list
+	copies map[string]*ast.Identifier
+}
+
+type Capture struct {
+	Name  string
+	ByRef bool
+	Id    int
+	// Value is the captured variable in the closure body
+	Value Value
+}
+
+// Escape limits where a closure can be stored: it cannot outlive Scope, which
+// declares the variable Name captured by reference. A function value passed as
+// the parameter Name may be such a closure, so it cannot outlive the call. A
+// closure holding the copy of Name on the stack cannot outlive Scope either,
+// where the closure is created.
+type Escape struct {
+	Scope   *Context
+	Name    string
+	IsParam bool
+	IsCopy  bool
+}
+
+// deeperEscape returns the stricter of the limits a and b.
+func deeperEscape(a, b *Escape) *Escape {
+	if a == nil {
+		return b
+	}
+	if b != nil && b.Scope.within(a.Scope) {
+		return b
+	}
+	return a
+}
+
+// findVariable finds the variable name. A local variable of an enclosing
+// function is captured by the closures between the current scope and it.
+func (c *CodeGen) findVariable(name string) (Value, bool) {
+	var closures []*Closure
+	for ctx := c.context; ctx != nil; ctx = ctx.parent {
+		v, ok := ctx.variables[name]
+		if ok {
+			if ctx.parent == nil {
+				return v, true
+			}
+			for i := len(closures) - 1; i >= 0; i-- {
+				v = c.capture(closures[i], name, v)
+			}
+			return v, true
+		}
+
+		if ctx.closure != nil {
+			closures = append(closures, ctx.closure)
+		}
+	}
+
+	return Value{}, false
+}
+
+// findName finds the variable named by ident. A free name in the body of a
+// macro is looked up at the top level, skipping the variables at the call site.
+func (c *CodeGen) findName(ident *ast.Identifier) (Value, bool) {
+	if ident.IsGlobal {
+		return c.context.root().findVariable(ident.Name)
+	}
+	return c.findVariable(ident.Name)
+}
+
+// variableType returns the type of the variable v.
+func variableType(v Value) types.Type {
+	typ := internal.PtrElmType(v.Value)
+	if v.IsReference {
+		typ = typ.(*types.PointerType).ElemType
+	}
+	return typ
+}
+
+// capture adds the variable v of the enclosing function to the environment of
+// cl, and returns the variable in the closure body.
+func (c *CodeGen) capture(cl *Closure, name string, v Value) Value {
+	for _, cp := range cl.Captures {
+		if cp.Name == name {
+			return cp.Value
+		}
+	}
+
+	typ := variableType(v)
+	cp := &Capture{
+		Name:  name,
+		ByRef: cl.copies[name] == nil,
+		Id:    len(cl.Env.Fields),
+	}
+
+	fieldTyp := typ
+	if cp.ByRef {
+		fieldTyp = types.I8Ptr
+	}
+	cl.Env.Fields = append(cl.Env.Fields, fieldTyp)
+
+	zero := constant.NewInt(types.I32, 0)
+	field := cl.entry.NewGetElementPtr(cl.Env, cl.env, zero, constant.NewInt(types.I32, int64(cp.Id)))
+	if cp.ByRef {
+		ptr := cl.entry.NewBitCast(cl.entry.NewLoad(types.I8Ptr, field), types.NewPointer(typ))
+		cp.Value = Value{
+			Value:      ptr,
+			IsVariable: true,
+			IsConstant: v.IsConstant,
+			Binding:    v.Binding,
+			Escape:     v.Escape,
+		}
+	} else {
+		cp.Value = Value{
+			Value:      field,
+			IsVariable: true,
+			IsConstant: true,
+			Escape:     v.Escape,
+		}
+	}
+
+	cl.Captures = append(cl.Captures, cp)
+	return cp.Value
+}
+
+// variableScope returns the scope a closure capturing the variable name by
+// reference cannot outlive. A variable of an enclosing function limits the
+// closure to the closure body it is captured by.
+func (c *CodeGen) variableScope(name string) *Context {
+	for ctx := c.context; ctx != nil; ctx = ctx.parent {
+		if _, ok := ctx.variables[name]; ok {
+			if ctx.parent == nil {
+				return nil
+			}
+			return ctx
+		}
+		if ctx.closure != nil {
+			return ctx
+		}
+	}
+
+	return nil
+}
+
+func (c *CodeGen) genFunctionLiteral(expr *ast.FunctionLiteral) Value {
+	name := internal.NextLabel(c.contextFunction.Name() + ".lambda")
+	return c.genClosure(name, "", expr.Copies, expr.Sig, expr.Body, expr.Func, expr == c.contextReturned)
+}
+
+// genNestedFunction declares a function inside a block as a constant
+// variable holding a closure, which captures the variables by reference.
+func (c *CodeGen) genNestedFunction(stmt *ast.FunctionStatement) {
+	if stmt.Receiver != nil {
+		errors.ErrorExit(fmt.Sprintf("%s | cannot declare method '%s.%s' in a function", stmt.Func, stmt.Receiver.Name, stmt.Ident.Name))
+	}
+	if len(stmt.TypeParams) != 0 {
+		errors.ErrorExit(fmt.Sprintf("%s | nested function '%s' cannot have type parameters", stmt.Func, stmt.Ident.Name))
+	}
+	if _, ok := c.context.findVariableCurrent(stmt.Ident.Name); ok {
+		errors.ErrorExit(fmt.Sprintf("%s | already declared variable '%s'", stmt.Func, stmt.Ident.Name))
+	}
+
+	name := internal.NextLabel(c.contextFunction.Name() + "." + stmt.Ident.Name)
+	v := c.genClosure(name, stmt.Ident.Name, nil, stmt.Sig, stmt.Body, stmt.Func, false)
+	val := v.Load(c.contextBlock)
+
+	named := c.contextEntryBlock.NewAlloca(val.Type())
+	named.SetName(stmt.Ident.Name)
+	c.genInit(named, val)
+	c.own(named)
+	c.context.addVariable(stmt.Ident.Name, Value{
+		Value:      named,
+		IsVariable: true,
+		IsConstant: true,
+		Escape:     v.Escape,
+	})
+}
+
+// functionState is the state of the function being generated, which is saved
+// while the body of a closure is generated.
+type functionState struct {
+	context       *Context
+	function      *ir.Func
+	entryBlock    *ir.Block
+	block         *ir.Block
+	condAfter     []*ir.Block
+	functionScope *Context
+	temporaries   []dropVar
+	defers        []*deferCall
+	init          *initState
+}
+
+func (c *CodeGen) saveFunctionState() functionState {
+	return functionState{
+		context:       c.context,
+		function:      c.contextFunction,
+		entryBlock:    c.contextEntryBlock,
+		block:         c.contextBlock,
+		condAfter:     c.contextCondAfter,
+		functionScope: c.contextFunctionScope,
+		temporaries:   c.contextTemporaries,
+		defers:        c.contextDefers,
+		init:          c.contextInit,
+	}
+}
+
+func (c *CodeGen) restoreFunctionState(s functionState) {
+	c.context = s.context
+	c.contextFunction = s.function
+	c.contextEntryBlock = s.entryBlock
+	c.contextBlock = s.block
+	c.contextCondAfter = s.condAfter
+	c.contextFunctionScope = s.functionScope
+	c.contextTemporaries = s.temporaries
+	c.contextDefers = s.defers
+	c.contextInit = s.init
+}
+
+// genClosure generates the function name taking the environment as the first
+// parameter, and creates a closure of it. A nested function named self can
+// refer to itself.
+func (c *CodeGen) genClosure(name string, self string, copies []*ast.Identifier, sig *ast.FunctionSignature, body *ast.BlockStatement, pos token.Position, isReturned bool) Value {
+	env := ir.NewParam("env", types.I8Ptr)
+	params := []*ir.Param{env}
+	var paramTypes []types.Type
+	for _, p := range sig.Params {
+		if p.IsReference {
+			errors.ErrorExit(fmt.Sprintf("%s | closure cannot take ref parameter '%s'", pos, p.Ident.Name))
+		}
+		if p.Default != nil {
+			errors.ErrorExit(fmt.Sprintf("%s | closure cannot have default value for '%s'", pos, p.Ident.Name))
+		}
+		if p.IsVariadic {
+			errors.ErrorExit(fmt.Sprintf("%s | closure cannot have variadic parameter '%s'", pos, p.Ident.Name))
+		}
+		typ := c.llvmType(p.Type)
+		params = append(params, ir.NewParam("", typ))
+		paramTypes = append(paramTypes, typ)
+	}
+	retTyp := c.llvmType(sig.RetType)
+
+	fn := c.module.NewFunc(name, retTyp, params...)
+	typ := funcValueType(retTyp, paramTypes...)
+
+	cl := &Closure{
+		Env:    types.NewStruct(),
+		copies: make(map[string]*ast.Identifier),
+	}
+	c.module.NewTypeDef(name+".env", cl.Env)
+
+	saved := c.saveFunctionState()
+
+	c.into()
+	c.context.closure = cl
+	c.contextFunction = fn
+	c.contextFunctionScope = c.context
+	c.contextCondAfter = nil
+	c.contextTemporaries = nil
+	c.contextDefers = nil
+	c.contextInit = newInitState()
+	c.contextBlock = fn.NewBlock("entry")
+	c.contextEntryBlock = c.contextBlock
+
+	cl.entry = c.contextEntryBlock
+	cl.env = c.contextBlock.NewBitCast(env, types.NewPointer(cl.Env))
+
+	for _, ident := range copies {
+		cl.copies[ident.Name] = ident
+		v, ok := c.findVariable(ident.Name)
+		if !ok {
+			errors.ErrorExit(fmt.Sprintf("%s | unresolved variable '%s'", ident.Pos, ident.Name))
+		}
+		_, isSlice := c.sliceElem(valueType(v))
+		if isSlice || c.isDroppable(valueType(v)) {
+			errors.ErrorExit(fmt.Sprintf("%s | cannot copy '%s' into a closure", ident.Pos, ident.Name))
+		}
+	}
+
+	if self != "" {
+		selfVal := c.contextEntryBlock.NewAlloca(typ)
+		var val value.Value = constant.NewUndef(typ)
+		val = c.contextBlock.NewInsertValue(val, fn, 0)
+		val = c.contextBlock.NewInsertValue(val, env, 1)
+		c.contextBlock.NewStore(val, selfVal)
+		c.context.addVariable(self, Value{
+			Value:      selfVal,
+			IsVariable: true,
+			IsConstant: true,
+		})
+	}
+
+	c.genParams(sig.Params, fn.Params[1:])
+	c.genBlockStatement(body)
+
+	if retTyp.Equal(types.Void) {
+		c.genReturn(nil)
+	} else if c.contextBlock.Term == nil {
+		errors.ErrorExit(fmt.Sprintf("%s | missing return at end of function", body.RBrace))
+	}
+
+	c.restoreFunctionState(saved)
+
+	return c.genClosureValue(fn, cl, typ, pos, isReturned)
+}
+
+// genClosureValue creates the environment of cl from the variables of the
+// current function and pairs it with fn. The environment is on the heap only
+// if it is counted by ARC, or if the closure is returned as is or created at
+// the top level. Otherwise nothing could free it.
+func (c *CodeGen) genClosureValue(fn *ir.Func, cl *Closure, typ types.Type, pos token.Position, isReturned bool) Value {
+	var envPtr value.Value = constant.NewNull(types.I8Ptr)
+	var escape *Escape
+
+	if len(cl.Captures) != 0 {
+		hasRef := false
+		for _, cp := range cl.Captures {
+			hasRef = hasRef || cp.ByRef
+		}
+
+		// the environment is on the stack if the closure cannot escape anyway
+		var env value.Value
+		if c.options.ARC || !hasRef && (isReturned || c.contextFunction == c.initFunc) {
+			env = c.genAlloc(cl.Env, pos)
+		} else {
+			env = c.contextEntryBlock.NewAlloca(cl.Env)
+			if !hasRef {
+				escape = &Escape{Scope: c.context, Name: cl.Captures[0].Name, IsCopy: true}
+			}
+		}
+
+		zero := constant.NewInt(types.I32, 0)
+		for _, cp := range cl.Captures {
+			v, _ := c.findVariable(cp.Name)
+			// the variables are read when the closure is created
+			ident := cl.copies[cp.Name]
+			if ident == nil {
+				ident = &ast.Identifier{Pos: pos, Name: cp.Name}
+			}
+			c.checkAssigned(ident, v)
+			v = v.Dereference(c.contextBlock)
+			field := c.contextBlock.NewGetElementPtr(cl.Env, env, zero, constant.NewInt(types.I32, int64(cp.Id)))
+
+			if cp.ByRef {
+				c.contextBlock.NewStore(c.contextBlock.NewBitCast(v.Value, types.I8Ptr), field)
+				if scope := c.variableScope(cp.Name); scope != nil {
+					escape = deeperEscape(escape, &Escape{Scope: scope, Name: cp.Name})
+				}
+			} else {
+				c.genInit(field, v.Load(c.contextBlock))
+			}
+			escape = deeperEscape(escape, v.Escape)
+		}
+
+		envPtr = c.contextBlock.NewBitCast(env, types.I8Ptr)
+	}
+
+	var val value.Value = constant.NewUndef(typ)
+	val = c.contextBlock.NewInsertValue(val, fn, 0)
+	val = c.contextBlock.NewInsertValue(val, envPtr, 1)
+
+	v := c.genTemporary(val)
+	v.Escape = escape
+	return v
+}
+
+// checkEscape reports an error if the closure v cannot be assigned to left.
+// Only a variable in the scope of the captured variables can hold it.
+func (c *CodeGen) checkEscape(left ast.Expression, v Value, pos token.Position) {
+	if v.Escape == nil {
+		return
+	}
+
+	if ident, ok := left.(*ast.Identifier); ok {
+		for ctx := c.context; ctx != nil; ctx = ctx.parent {
+			dest, ok := ctx.variables[ident.Name]
+			if !ok {
+				continue
+			}
+			if ctx.within(v.Escape.Scope) {
+				dest.Escape = deeperEscape(dest.Escape, v.Escape)
+				ctx.variables[ident.Name] = dest
+				return
+			}
+			break
+		}
+	}
+
+	c.escapeError(v.Escape, pos)
+}
+
+func (c *CodeGen) escapeError(e *Escape, pos token.Position) {
+	if e.IsParam {
+		errors.ErrorExit(fmt.Sprintf("%s | function parameter '%s' cannot outlive the call", pos, e.Name))
+	}
+	if e.IsCopy {
+		errors.ErrorExit(fmt.Sprintf("%s | closure copying '%s' cannot outlive the scope it is created in, unless it is returned as is", pos, e.Name))
+	}
+	errors.ErrorExit(fmt.Sprintf("%s | closure cannot outlive '%s' it captures by reference", pos, e.Name))
+}
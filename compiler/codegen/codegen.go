@@ -4,15 +4,29 @@ import (
 	"fmt"
 	"github.com/arata-nvm/visket/compiler/ast"
 	"github.com/arata-nvm/visket/compiler/errors"
+	"github.com/arata-nvm/visket/compiler/target"
 	"github.com/llir/llvm/ir"
 	"github.com/llir/llvm/ir/constant"
 	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
 	"io"
 )
 
+type Options struct {
+	// ARC enables automatic reference counting of heap objects
+	ARC bool
+	// MemCheck reports heap objects that are still alive at exit
+	MemCheck bool
+	// Target is the target triple, or empty for the host
+	Target string
+	// StrictInit reports reads of possibly unassigned variables as errors
+	StrictInit bool
+}
+
 type CodeGen struct {
 	program *ast.Program
 	output  io.Writer
+	options Options
 	context *Context
 
 	module *ir.Module
@@ -21,19 +35,57 @@ type CodeGen struct {
 	mainFunc *ir.Func
 
 	contextModuleName string
+	contextInstance   string
 	contextFunction   *ir.Func
 	contextEntryBlock *ir.Block
 	contextBlock      *ir.Block
 	contextCondAfter  []*ir.Block
+
+	contextFunctionScope *Context
+	contextTemporaries   []dropVar
+	contextDefers        []*deferCall
+	contextInit          *initState
+	// the function literal being returned by a return statement
+	contextReturned *ast.FunctionLiteral
+
+	// reads of possibly unassigned variables already reported
+	initReported map[*ast.Identifier]bool
+	// the variable generated by genPlace, which is checked by the caller
+	placeRoot *ast.Identifier
+	// local variables dropped at scope exit by their slots
+	dropVars map[value.Value]dropVar
+
+	runtime runtime
+
+	// bodies of instantiated generic functions waiting to be generated
+	pendingBodies []func()
+
+	// values of global constants, nil while being evaluated
+	globalConsts map[*ast.VarStatement]interface{}
+
+	// symbols kept by '@export'
+	exported []constant.Constant
+	// '@link_name' attributes, checked after all the declarations
+	linkNames []*ast.Attribute
+
+	// data layout of the target, loaded by sizeof, alignof or offsetof
+	layout *target.DataLayout
 }
 
-func New(program *ast.Program, w io.Writer) *CodeGen {
+func New(program *ast.Program, w io.Writer, opts Options) *CodeGen {
 	c := &CodeGen{
 		program: program,
 		output:  w,
+		options: opts,
 		context: newContext(nil),
 		module:  ir.NewModule(),
+
+		globalConsts: make(map[*ast.VarStatement]interface{}),
+		contextInit:  newInitState(),
+		initReported: make(map[*ast.Identifier]bool),
+		dropVars:     make(map[value.Value]dropVar),
 	}
+	c.module.TargetTriple = opts.Target
 
 	c.addGlobal()
 
@@ -58,8 +110,32 @@ func (c *CodeGen) addGlobal() {
 func (c *CodeGen) GenerateCode() {
 	c.genStdlib()
 
+	for _, s := range c.program.Interfaces {
+		c.genInterfaceDeclaration(s)
+	}
+
 	for _, s := range c.program.Structs {
-		c.genStructStatement(s)
+		c.genStructDeclaration(s)
+	}
+
+	for _, s := range c.program.Types {
+		c.genTypeDeclaration(s)
+	}
+
+	for _, s := range c.program.Types {
+		c.genTypeBody(s)
+	}
+
+	for _, s := range c.program.Structs {
+		c.genStructBody(s)
+	}
+
+	for _, s := range c.program.Interfaces {
+		c.genInterfaceMethods(s)
+	}
+
+	for _, s := range c.program.Structs {
+		c.genStructFunctionDeclaration(s)
 	}
 
 	for _, s := range c.program.Functions {
@@ -74,6 +150,12 @@ func (c *CodeGen) GenerateCode() {
 		c.genGlobalVarStatement(s)
 	}
 
+	c.checkSymbols()
+
+	for _, s := range c.program.Asserts {
+		c.genStaticAssert(s)
+	}
+
 	for _, s := range c.program.Functions {
 		if s.Body != nil {
 			c.genFunctionBody(s)
@@ -84,6 +166,18 @@ func (c *CodeGen) GenerateCode() {
 		c.genModuleBody(s)
 	}
 
+	for _, s := range c.program.Structs {
+		c.genStructFunctionBody(s)
+	}
+
+	for len(c.pendingBodies) > 0 {
+		body := c.pendingBodies[0]
+		c.pendingBodies = c.pendingBodies[1:]
+		body()
+	}
+
+	c.genExported()
+
 	irCode := c.module.String()
 	_, err := fmt.Fprint(c.output, irCode)
 	if err != nil {
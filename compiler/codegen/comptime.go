@@ -0,0 +1,553 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/codegen/builtin"
+	"github.com/arata-nvm/visket/compiler/errors"
+	"github.com/arata-nvm/visket/compiler/token"
+	"github.com/llir/llvm/ir/types"
+	"strings"
+)
+
+const (
+	maxComptimeSteps = 10000000
+	maxComptimeDepth = 1000
+)
+
+// constArray is an array evaluated at compile time.
+type constArray struct {
+	typ   *types.ArrayType
+	elems []interface{}
+}
+
+// copyConst copies arrays, which are values like at runtime.
+func copyConst(v interface{}) interface{} {
+	arr, ok := v.(*constArray)
+	if !ok {
+		return v
+	}
+
+	elems := make([]interface{}, len(arr.elems))
+	for i, e := range arr.elems {
+		elems[i] = copyConst(e)
+	}
+	return &constArray{typ: arr.typ, elems: elems}
+}
+
+// zeroConst returns the zero value of typ, or false if values of typ cannot be
+// evaluated at compile time.
+func zeroConst(typ types.Type) (interface{}, bool) {
+	switch {
+	case typ.Equal(types.I32):
+		return int32(0), true
+	case typ.Equal(types.Float):
+		return float32(0), true
+	case typ.Equal(types.I1):
+		return false, true
+	case typ.Equal(builtin.STRING):
+		return "", true
+	}
+
+	arrTyp, ok := typ.(*types.ArrayType)
+	if !ok {
+		return nil, false
+	}
+
+	arr := &constArray{typ: arrTyp}
+	for i := uint64(0); i < arrTyp.Len; i++ {
+		elem, ok := zeroConst(arrTyp.ElemType)
+		if !ok {
+			return nil, false
+		}
+		arr.elems = append(arr.elems, elem)
+	}
+	return arr, true
+}
+
+// interpreter evaluates calls to const functions by interpreting their bodies.
+type interpreter struct {
+	c     *CodeGen
+	steps int
+	depth int
+	scope *constScope
+}
+
+type constScope struct {
+	vars   map[string]*constVar
+	parent *constScope
+}
+
+type constVar struct {
+	value      interface{}
+	isConstant bool
+}
+
+func (s *constScope) find(name string) (*constVar, bool) {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// evalComptime evaluates 'comptime expr', which must be a constant expression.
+func (c *CodeGen) evalComptime(expr *ast.ComptimeExpression) interface{} {
+	v, bad := c.evalConst(expr.Value)
+	if bad != nil {
+		errors.ErrorExit(fmt.Sprintf("%s | '%s' is not a constant expression", expr.Comptime, ast.Show(bad)))
+	}
+	return v
+}
+
+func (c *CodeGen) genComptimeExpression(expr *ast.ComptimeExpression) Value {
+	v := c.evalComptime(expr)
+	if v == nil {
+		errors.ErrorExit(fmt.Sprintf("%s | '%s' has no value", expr.Comptime, ast.Show(expr.Value)))
+	}
+	return Value{Value: c.constValue(v)}
+}
+
+// checkConstFunction reports the features that const functions cannot use.
+func (c *CodeGen) checkConstFunction(stmt *ast.FunctionStatement) {
+	name := stmt.Ident.Name
+	if name == "main" {
+		errors.ErrorExit(fmt.Sprintf("%s | function 'main' cannot be const", stmt.Func))
+	}
+	if stmt.Receiver != nil {
+		errors.ErrorExit(fmt.Sprintf("%s | method '%s' cannot be const", stmt.Func, name))
+	}
+	if len(stmt.TypeParams) != 0 {
+		errors.ErrorExit(fmt.Sprintf("%s | generic function '%s' cannot be const", stmt.Func, name))
+	}
+	for _, p := range stmt.Sig.Params {
+		if p.IsReference || p.IsVariadic {
+			errors.ErrorExit(fmt.Sprintf("%s | parameter '%s' of const function '%s' must be passed by value", p.Ident.Pos, p.Ident.Name, name))
+		}
+	}
+}
+
+// evalConstCall evaluates a call to a const function with constant arguments.
+func (c *CodeGen) evalConstCall(expr *ast.CallExpression) (interface{}, ast.Expression) {
+	if expr.Callee != nil || expr.IsMethod || len(c.constFunctions(expr.Function.Name)) == 0 {
+		return nil, expr
+	}
+
+	var args []interface{}
+	for _, arg := range expr.Args {
+		v, bad := c.evalConst(argValue(arg))
+		if bad != nil {
+			return nil, bad
+		}
+		args = append(args, v)
+	}
+
+	errors.PushContext(fmt.Sprintf("%s | in compile-time evaluation of '%s'", expr.LParen, ast.Show(expr)))
+	in := &interpreter{c: c}
+	v := in.call(expr, args)
+	errors.PopContext()
+
+	return v, nil
+}
+
+// constFunctions returns the const functions declared as name.
+func (c *CodeGen) constFunctions(name string) []*ast.FunctionStatement {
+	var fs []*ast.FunctionStatement
+	for _, f := range c.program.Functions {
+		if f.IsConst && f.Ident.Name == name {
+			fs = append(fs, f)
+		}
+	}
+	return fs
+}
+
+// findConstFunction returns the const function that expr calls with args.
+func (c *CodeGen) findConstFunction(expr *ast.CallExpression, args []interface{}) (*ast.FunctionStatement, []int) {
+	name := calleeName(expr)
+	if expr.Callee != nil || expr.IsMethod {
+		errors.ErrorExit(fmt.Sprintf("%s | cannot call '%s' at compile time", expr.LParen, name))
+	}
+
+	fs := c.constFunctions(name)
+	if len(fs) == 0 {
+		errors.ErrorExit(fmt.Sprintf("%s | cannot call non-const function '%s' at compile time", expr.LParen, name))
+	}
+
+	var reason string
+	for _, f := range fs {
+		var order []int
+		if order, reason = arrangeArgs(expr, f.Sig.Params); reason != "" {
+			continue
+		}
+
+		matched := true
+		for i, p := range f.Sig.Params {
+			if order[i] != -1 && !c.llvmType(p.Type).Equal(constType(args[order[i]])) {
+				matched = false
+			}
+		}
+		if matched {
+			return f, order
+		}
+	}
+
+	if len(fs) == 1 && reason != "" {
+		errors.ErrorExit(fmt.Sprintf("%s | %s", expr.LParen, reason))
+	}
+
+	var argTypes []string
+	for _, arg := range args {
+		argTypes = append(argTypes, typeName(constType(arg)))
+	}
+	errors.ErrorExit(fmt.Sprintf("%s | no matching const function for '%s(%s)'", expr.LParen, name, strings.Join(argTypes, ", ")))
+	return nil, nil // unreachable
+}
+
+func (in *interpreter) step(pos token.Position) {
+	in.steps++
+	if in.steps > maxComptimeSteps {
+		errors.ErrorExit(fmt.Sprintf("%s | compile-time evaluation exceeded %d steps", pos, maxComptimeSteps))
+	}
+}
+
+// call interprets the const function called by expr with the arguments args.
+func (in *interpreter) call(expr *ast.CallExpression, args []interface{}) interface{} {
+	in.step(expr.LParen)
+	if in.depth >= maxComptimeDepth {
+		errors.ErrorExit(fmt.Sprintf("%s | compile-time evaluation exceeded the maximum call depth %d", expr.LParen, maxComptimeDepth))
+	}
+
+	f, order := in.c.findConstFunction(expr, args)
+
+	scope := &constScope{vars: make(map[string]*constVar)}
+	for i, p := range f.Sig.Params {
+		var v interface{}
+		if order[i] != -1 {
+			v = copyConst(args[order[i]])
+		} else {
+			v = in.c.evalDefaultConst(p.Default)
+		}
+		scope.vars[p.Ident.Name] = &constVar{value: v}
+	}
+
+	saved := in.scope
+	in.scope = scope
+	in.depth++
+	v, returned := in.execBlock(f.Body)
+	in.depth--
+	in.scope = saved
+
+	retTyp := in.c.llvmType(f.Sig.RetType)
+	if !returned && !retTyp.Equal(types.Void) {
+		errors.ErrorExit(fmt.Sprintf("%s | missing return at end of function", f.Body.RBrace))
+	}
+	if retTyp.Equal(types.Void) {
+		return nil
+	}
+	return v
+}
+
+// evalDefaultConst evaluates the default value of a parameter of a const function.
+func (c *CodeGen) evalDefaultConst(expr ast.Expression) interface{} {
+	ctx := c.context
+	c.context = ctx.root()
+	v, bad := c.evalConst(expr)
+	c.context = ctx
+
+	if bad != nil {
+		errors.ErrorExit(fmt.Sprintf("%s | '%s' is not a constant expression", exprPos(bad), ast.Show(bad)))
+	}
+	return v
+}
+
+func (in *interpreter) execBlock(block *ast.BlockStatement) (interface{}, bool) {
+	saved := in.scope
+	in.scope = &constScope{vars: make(map[string]*constVar), parent: saved}
+	defer func() { in.scope = saved }()
+
+	for _, stmt := range block.Statements {
+		if v, returned := in.exec(stmt); returned {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// exec interprets stmt. It returns true with the value if stmt returns.
+func (in *interpreter) exec(stmt ast.Statement) (interface{}, bool) {
+	switch stmt := stmt.(type) {
+	case *ast.ExpressionStatement:
+		in.eval(stmt.Expression)
+	case *ast.VarStatement:
+		in.execVar(stmt)
+	case *ast.ReturnStatement:
+		if stmt.Value == nil {
+			return nil, true
+		}
+		return copyConst(in.eval(stmt.Value)), true
+	case *ast.BlockStatement:
+		return in.execBlock(stmt)
+	case *ast.IfStatement:
+		if in.evalBool(stmt.Condition, stmt.If) {
+			return in.execBlock(stmt.Consequence)
+		} else if stmt.Alternative != nil {
+			return in.execBlock(stmt.Alternative)
+		}
+	case *ast.WhileStatement:
+		for in.evalBool(stmt.Condition, stmt.While) {
+			in.step(stmt.While)
+			if v, returned := in.execBlock(stmt.Body); returned {
+				return v, true
+			}
+		}
+	case *ast.ForStatement:
+		return in.execFor(stmt)
+	case *ast.ForRangeStatement:
+		return in.execForRange(stmt)
+	default:
+		errors.ErrorExit(fmt.Sprintf("%s | cannot evaluate '%s' at compile time", stmtPos(stmt), ast.Show(stmt)))
+	}
+
+	return nil, false
+}
+
+func (in *interpreter) execVar(stmt *ast.VarStatement) {
+	if _, ok := in.scope.vars[stmt.Ident.Name]; ok {
+		errors.ErrorExit(fmt.Sprintf("%s | already declared variable '%s'", stmt.Var, stmt.Ident.Name))
+	}
+
+	var v interface{}
+	if stmt.Value != nil {
+		v = copyConst(in.eval(stmt.Value))
+	}
+
+	if stmt.Type != nil {
+		typ := in.c.llvmType(stmt.Type)
+		if v == nil {
+			zero, ok := zeroConst(typ)
+			if !ok {
+				errors.ErrorExit(fmt.Sprintf("%s | cannot use type '%s' at compile time", stmt.Var, typeName(typ)))
+			}
+			v = zero
+		} else if !typ.Equal(constType(v)) {
+			errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", stmt.Var, typ, constType(v)))
+		}
+	}
+
+	in.scope.vars[stmt.Ident.Name] = &constVar{value: v, isConstant: stmt.IsConstant}
+}
+
+func (in *interpreter) execFor(stmt *ast.ForStatement) (interface{}, bool) {
+	saved := in.scope
+	in.scope = &constScope{vars: make(map[string]*constVar), parent: saved}
+	defer func() { in.scope = saved }()
+
+	if stmt.Init != nil {
+		in.exec(stmt.Init)
+	}
+	for stmt.Condition == nil || in.evalBool(stmt.Condition, stmt.For) {
+		in.step(stmt.For)
+		if v, returned := in.execBlock(stmt.Body); returned {
+			return v, true
+		}
+		if stmt.Post != nil {
+			in.exec(stmt.Post)
+		}
+	}
+	return nil, false
+}
+
+func (in *interpreter) execForRange(stmt *ast.ForRangeStatement) (interface{}, bool) {
+	from, ok := in.eval(stmt.From).(int32)
+	to, ok2 := in.eval(stmt.To).(int32)
+	if !ok || !ok2 {
+		errors.ErrorExit(fmt.Sprintf("%s | range must be integers", stmt.For))
+	}
+
+	step := int32(1)
+	if stmt.Step != nil {
+		if step, ok = in.eval(stmt.Step).(int32); !ok {
+			errors.ErrorExit(fmt.Sprintf("%s | range must be integers", stmt.For))
+		}
+		if step == 0 {
+			errors.ErrorExit(fmt.Sprintf("%s | step of range cannot be zero", stmt.In))
+		}
+	}
+	// the counter is wider than int, so that it cannot overflow near the bounds
+	inRange := func(i int64) bool {
+		switch {
+		case step > 0 && stmt.Exclusive:
+			return i < int64(to)
+		case step > 0:
+			return i <= int64(to)
+		case stmt.Exclusive:
+			return i > int64(to)
+		}
+		return i >= int64(to)
+	}
+
+	saved := in.scope
+	defer func() { in.scope = saved }()
+
+	for i := int64(from); inRange(i); i += int64(step) {
+		in.step(stmt.For)
+		in.scope = &constScope{vars: make(map[string]*constVar), parent: saved}
+		in.scope.vars[stmt.VarName.Name] = &constVar{value: int32(i)}
+		if v, returned := in.execBlock(stmt.Body); returned {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (in *interpreter) evalBool(expr ast.Expression, pos token.Position) bool {
+	b, ok := in.eval(expr).(bool)
+	if !ok {
+		errors.ErrorExit(fmt.Sprintf("%s | condition must be a bool", pos))
+	}
+	return b
+}
+
+func (in *interpreter) eval(expr ast.Expression) interface{} {
+	switch expr := expr.(type) {
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral,
+		*ast.SizeofExpression, *ast.AlignofExpression, *ast.OffsetofExpression:
+		v, _ := in.c.evalConst(expr)
+		return v
+	case *ast.Identifier:
+		if v, ok := in.scope.find(expr.Name); ok {
+			return v.value
+		}
+		v, bad := in.c.evalConstGlobal(expr)
+		if bad != nil {
+			errors.ErrorExit(fmt.Sprintf("%s | cannot use '%s' at compile time", expr.Pos, expr.Name))
+		}
+		return v
+	case *ast.PrefixExpression:
+		if expr.Op == token.NOT {
+			return evalConstNot(expr, in.eval(expr.Right))
+		}
+	case *ast.InfixExpression:
+		lhs := in.eval(expr.Left)
+		if v, ok := evalConstShortCircuit(expr, lhs); ok {
+			return v
+		}
+		rhs := in.eval(expr.Right)
+		v, _ := in.c.evalConstInfix(expr, lhs, rhs)
+		return v
+	case *ast.CallExpression:
+		var args []interface{}
+		for _, arg := range expr.Args {
+			args = append(args, in.eval(argValue(arg)))
+		}
+		return in.call(expr, args)
+	case *ast.IndexExpression:
+		arr, i := constElem(expr, in.eval(expr.Left), in.eval(expr.Index))
+		return arr.elems[i]
+	case *ast.AssignExpression:
+		v := copyConst(in.eval(expr.Value))
+		in.assign(expr, v)
+		return v
+	case *ast.ComptimeExpression:
+		return in.eval(expr.Value)
+	}
+
+	errors.ErrorExit(fmt.Sprintf("%s | cannot evaluate '%s' at compile time", exprPos(expr), ast.Show(expr)))
+	return nil // unreachable
+}
+
+// constElem returns the array v and the index into it for expr.
+func constElem(expr *ast.IndexExpression, v, index interface{}) (*constArray, int32) {
+	arr, ok := v.(*constArray)
+	if !ok {
+		errors.ErrorExit(fmt.Sprintf("%s | cannot index '%s' at compile time", expr.LBrack, typeName(constType(v))))
+	}
+	i, ok := index.(int32)
+	if !ok {
+		errors.ErrorExit(fmt.Sprintf("%s | index must be an integer", expr.LBrack))
+	}
+	if i < 0 || int(i) >= len(arr.elems) {
+		errors.ErrorExit(fmt.Sprintf("%s | index %d out of range for '%s'", expr.LBrack, i, typeName(arr.typ)))
+	}
+	return arr, i
+}
+
+func (in *interpreter) assign(expr *ast.AssignExpression, v interface{}) {
+	switch left := expr.Left.(type) {
+	case *ast.Identifier:
+		variable, ok := in.scope.find(left.Name)
+		if !ok || variable.isConstant {
+			errors.ErrorExit(fmt.Sprintf("%s | constant '%s' cannot be reassigned", expr.OpPos, left.Name))
+		}
+		if variable.value != nil && !constType(variable.value).Equal(constType(v)) {
+			errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", expr.OpPos, constType(variable.value), constType(v)))
+		}
+		variable.value = v
+	case *ast.IndexExpression:
+		arr, i := constElem(left, in.eval(left.Left), in.eval(left.Index))
+		elems := arr.elems
+		if !constType(elems[i]).Equal(constType(v)) {
+			errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", expr.OpPos, constType(elems[i]), constType(v)))
+		}
+		elems[i] = v
+	default:
+		errors.ErrorExit(fmt.Sprintf("%s | cannot assign to '%s' at compile time", expr.OpPos, ast.Show(expr.Left)))
+	}
+}
+
+// exprPos returns the position of expr for error messages.
+func exprPos(expr ast.Expression) token.Position {
+	switch expr := expr.(type) {
+	case *ast.Identifier:
+		return expr.Pos
+	case *ast.IntegerLiteral:
+		return expr.Pos
+	case *ast.FloatLiteral:
+		return expr.Pos
+	case *ast.StringLiteral:
+		return expr.Token.Pos
+	case *ast.CharLiteral:
+		return expr.Token.Pos
+	case *ast.NilLiteral:
+		return expr.Pos
+	case *ast.PrefixExpression:
+		return expr.OpPos
+	case *ast.InfixExpression:
+		return expr.OpPos
+	case *ast.AssignExpression:
+		return expr.OpPos
+	case *ast.CallExpression:
+		return expr.LParen
+	case *ast.IndexExpression:
+		return expr.LBrack
+	case *ast.NewExpression:
+		return expr.New
+	case *ast.LoadMemberExpression:
+		return expr.Period
+	case *ast.FunctionLiteral:
+		return expr.Func
+	case *ast.ComptimeExpression:
+		return expr.Comptime
+	case *ast.IfExpression:
+		return expr.Stmt.If
+	case *ast.BlockExpression:
+		return expr.Block.LBrace
+	}
+	return token.Position{}
+}
+
+// stmtPos returns the position of stmt for error messages.
+func stmtPos(stmt ast.Statement) token.Position {
+	switch stmt := stmt.(type) {
+	case *ast.DeleteStatement:
+		return stmt.Delete
+	case *ast.DeferStatement:
+		return stmt.Defer
+	case *ast.FunctionStatement:
+		return stmt.Func
+	case *ast.ForInStatement:
+		return stmt.For
+	}
+	return token.Position{}
+}
@@ -0,0 +1,294 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/codegen/builtin"
+	"github.com/arata-nvm/visket/compiler/errors"
+	"github.com/arata-nvm/visket/compiler/token"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+)
+
+// evalConst evaluates expr at compile time into an int32, float32, bool, string
+// or array. If expr is not constant, it returns the subexpression that is not.
+func (c *CodeGen) evalConst(expr ast.Expression) (interface{}, ast.Expression) {
+	switch expr := expr.(type) {
+	case *ast.IntegerLiteral:
+		return int32(expr.Value), nil
+	case *ast.FloatLiteral:
+		return float32(expr.Value), nil
+	case *ast.StringLiteral:
+		return expr.Value, nil
+	case *ast.Identifier:
+		return c.evalConstIdentifier(expr)
+	case *ast.PrefixExpression:
+		if expr.Op != token.NOT {
+			return nil, expr
+		}
+		v, bad := c.evalConst(expr.Right)
+		if bad != nil {
+			return nil, bad
+		}
+		return evalConstNot(expr, v), nil
+	case *ast.InfixExpression:
+		lhs, bad := c.evalConst(expr.Left)
+		if bad != nil {
+			return nil, bad
+		}
+		if v, ok := evalConstShortCircuit(expr, lhs); ok {
+			return v, nil
+		}
+		rhs, bad := c.evalConst(expr.Right)
+		if bad != nil {
+			return nil, bad
+		}
+		return c.evalConstInfix(expr, lhs, rhs)
+	case *ast.IndexExpression:
+		left, bad := c.evalConst(expr.Left)
+		if bad != nil {
+			return nil, bad
+		}
+		index, bad := c.evalConst(expr.Index)
+		if bad != nil {
+			return nil, bad
+		}
+		arr, i := constElem(expr, left, index)
+		return arr.elems[i], nil
+	case *ast.CallExpression:
+		return c.evalConstCall(expr)
+	case *ast.ComptimeExpression:
+		return c.evalComptime(expr), nil
+	case *ast.SizeofExpression, *ast.AlignofExpression, *ast.OffsetofExpression:
+		v, _ := c.evalLayout(expr)
+		return v, nil
+	}
+
+	return nil, expr
+}
+
+func (c *CodeGen) evalConstIdentifier(expr *ast.Identifier) (interface{}, ast.Expression) {
+	ctx := c.context
+	if expr.IsGlobal {
+		ctx = ctx.root()
+	}
+	if v, ok := ctx.findVariable(expr.Name); ok {
+		if v.Const == nil {
+			return nil, expr
+		}
+		return v.Const, nil
+	}
+
+	return c.evalConstGlobal(expr)
+}
+
+func (c *CodeGen) evalConstGlobal(expr *ast.Identifier) (interface{}, ast.Expression) {
+	// global constants can be used before they are generated
+	for _, stmt := range c.program.Globals {
+		if stmt.Ident.Name != expr.Name || !stmt.IsConstant {
+			continue
+		}
+
+		if v, ok := c.globalConsts[stmt]; ok {
+			if v == nil {
+				errors.ErrorExit(fmt.Sprintf("%s | initialization cycle for '%s'", stmt.Var, stmt.Ident.Name))
+			}
+			return v, nil
+		}
+
+		c.globalConsts[stmt] = nil
+		ctx := c.context
+		c.context = ctx.root()
+		v, bad := c.evalConst(stmt.Value)
+		c.context = ctx
+		if bad != nil {
+			delete(c.globalConsts, stmt)
+			return nil, expr
+		}
+		c.globalConsts[stmt] = v
+		return v, nil
+	}
+
+	return nil, expr
+}
+
+func evalConstNot(expr *ast.PrefixExpression, v interface{}) interface{} {
+	b, ok := v.(bool)
+	if !ok {
+		errors.ErrorExit(fmt.Sprintf("%s | unexpected operator: %s%s", expr.OpPos, expr.Op, constType(v)))
+	}
+	return !b
+}
+
+// evalConstShortCircuit returns the result of '&&' and '||' if the left
+// operand decides it, so that the right operand is not evaluated.
+func evalConstShortCircuit(expr *ast.InfixExpression, lhs interface{}) (interface{}, bool) {
+	l, ok := lhs.(bool)
+	if !ok {
+		return nil, false
+	}
+	if expr.Op == token.LAND && !l || expr.Op == token.LOR && l {
+		return l, true
+	}
+	return nil, false
+}
+
+// evalConstInfix applies the operator of expr like genInfix does at runtime.
+func (c *CodeGen) evalConstInfix(expr *ast.InfixExpression, lhs, rhs interface{}) (interface{}, ast.Expression) {
+	// for - prefix
+	if l, ok := lhs.(int32); ok {
+		if _, ok := rhs.(float32); ok {
+			lhs = float32(l)
+		}
+	}
+
+	switch l := lhs.(type) {
+	case int32:
+		if r, ok := rhs.(int32); ok {
+			return c.evalConstInteger(expr, l, r), nil
+		}
+	case float32:
+		if r, ok := rhs.(float32); ok {
+			return c.evalConstFloat(expr, l, r), nil
+		}
+	case bool:
+		if r, ok := rhs.(bool); ok {
+			switch expr.Op {
+			case "==":
+				return l == r, nil
+			case "!=":
+				return l != r, nil
+			case token.LAND:
+				return l && r, nil
+			case token.LOR:
+				return l || r, nil
+			}
+		}
+	}
+
+	if !constType(lhs).Equal(constType(rhs)) {
+		errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", expr.OpPos, constType(lhs), constType(rhs)))
+	}
+	errors.ErrorExit(fmt.Sprintf("%s | unexpected operator: %s %s %s", expr.OpPos, constType(lhs), expr.Op, constType(rhs)))
+	return nil, nil // unreachable
+}
+
+func (c *CodeGen) evalConstInteger(expr *ast.InfixExpression, l, r int32) interface{} {
+	switch expr.Op {
+	case "+":
+		return l + r
+	case "-":
+		return l - r
+	case "*":
+		return l * r
+	case "/", "%":
+		if r == 0 {
+			errors.ErrorExit(fmt.Sprintf("%s | division by zero in constant expression", expr.OpPos))
+		}
+		if expr.Op == "/" {
+			return l / r
+		}
+		return l % r
+	case "<<":
+		return l << uint32(r)
+	case ">>":
+		return l >> uint32(r)
+	case "==":
+		return l == r
+	case "!=":
+		return l != r
+	// integers are compared as unsigned like genInfixInteger
+	case "<":
+		return uint32(l) < uint32(r)
+	case "<=":
+		return uint32(l) <= uint32(r)
+	case ">":
+		return uint32(l) > uint32(r)
+	case ">=":
+		return uint32(l) >= uint32(r)
+	}
+
+	errors.ErrorExit(fmt.Sprintf("%s | unexpected operator: %s %s %s", expr.OpPos, types.I32, expr.Op, types.I32))
+	return nil // unreachable
+}
+
+func (c *CodeGen) evalConstFloat(expr *ast.InfixExpression, l, r float32) interface{} {
+	switch expr.Op {
+	case "+":
+		return l + r
+	case "-":
+		return l - r
+	case "*":
+		return l * r
+	case "/":
+		return l / r
+	case "==":
+		return l == r
+	case "!=":
+		return l != r
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	case ">=":
+		return l >= r
+	}
+
+	errors.ErrorExit(fmt.Sprintf("%s | unexpected operator: %s %s %s", expr.OpPos, types.Float, expr.Op, types.Float))
+	return nil // unreachable
+}
+
+// constType returns the type of the constant value v.
+func constType(v interface{}) types.Type {
+	switch v := v.(type) {
+	case int32:
+		return types.I32
+	case float32:
+		return types.Float
+	case bool:
+		return types.I1
+	case string:
+		return builtin.STRING
+	case *constArray:
+		return v.typ
+	}
+	return types.Void
+}
+
+// constValue returns v as a constant of LLVM IR.
+func (c *CodeGen) constValue(v interface{}) constant.Constant {
+	switch v := v.(type) {
+	case int32:
+		return constant.NewInt(types.I32, int64(v))
+	case float32:
+		return constant.NewFloat(types.Float, float64(v))
+	case bool:
+		return constant.NewBool(v)
+	case string:
+		length := constant.NewInt(types.I32, int64(len(v)))
+		return constant.NewStruct(builtin.STRING.(*types.StructType), builtin.NewCString(v, c.module), length)
+	case *constArray:
+		var elems []constant.Constant
+		for _, e := range v.elems {
+			elems = append(elems, c.constValue(e))
+		}
+		return constant.NewArray(v.typ, elems...)
+	}
+	return nil
+}
+
+// constLength evaluates the length of an array type.
+func (c *CodeGen) constLength(expr ast.Expression, pos token.Position) uint64 {
+	v, bad := c.evalConst(expr)
+	if bad != nil {
+		errors.ErrorExit(fmt.Sprintf("%s | '%s' is not a constant expression", pos, ast.Show(bad)))
+	}
+
+	n, ok := v.(int32)
+	if !ok || n < 0 {
+		errors.ErrorExit(fmt.Sprintf("%s | invalid array length '%s'", pos, ast.Show(expr)))
+	}
+	return uint64(n)
+}
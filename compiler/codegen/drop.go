@@ -0,0 +1,356 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/codegen/internal"
+	"github.com/arata-nvm/visket/compiler/errors"
+	"github.com/arata-nvm/visket/compiler/token"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// dropVar is a variable dropped at scope exit. The variable is dropped only
+// while flag is true; flag becomes false when the value is moved out.
+//
+// A variable declared without a value holds the zero value, which is not
+// dropped. Its flag stays false until it is assigned, and parts holds a flag
+// for each member or element assigned before the whole, which is dropped alone.
+type dropVar struct {
+	slot  value.Value
+	flag  value.Value
+	parts value.Value
+}
+
+// isDroppable reports whether values of typ need to be dropped, that is,
+// typ is a struct with a drop function or contains one.
+func (c *CodeGen) isDroppable(typ types.Type) bool {
+	switch typ := typ.(type) {
+	case *types.ArrayType:
+		return c.isDroppable(typ.ElemType)
+	case *types.StructType:
+		s, ok := c.context.findStruct(typ.Name())
+		if !ok || s.IsIncomplete {
+			return false
+		}
+		if s.Drop != nil {
+			return true
+		}
+		if s.Underlying != nil {
+			return c.isDroppable(s.Underlying)
+		}
+		for _, m := range s.Members {
+			if c.isDroppable(m.Type) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (c *CodeGen) genStructFunctionDeclaration(stmt *ast.StructStatement) {
+	if len(stmt.TypeParams) != 0 {
+		return
+	}
+
+	s, _ := c.context.findStruct(stmt.Ident.Name)
+	c.genStructFunctions(s, stmt)
+}
+
+func (c *CodeGen) genStructFunctions(s *Struct, stmt *ast.StructStatement) {
+	tmpModName := c.contextModuleName
+	c.contextModuleName = s.Name
+
+	for _, f := range stmt.Functions {
+		if f.Ident.Name != "drop" {
+			errors.ErrorExit(fmt.Sprintf("%s | unexpected function '%s' in struct '%s'", f.Func, f.Ident.Name, s.Name))
+		}
+
+		params := f.Sig.Params
+		if len(params) != 1 || !params[0].IsReference || c.llvmType(params[0].Type) != types.Type(s.Type) || f.Sig.RetType.Name != "void" {
+			errors.ErrorExit(fmt.Sprintf("%s | drop must be declared as 'fun drop(ref self: %s)'", f.Func, s.Name))
+		}
+
+		if s.Drop != nil {
+			errors.ErrorExit(fmt.Sprintf("%s | already declared function 'drop' in struct '%s'", f.Func, s.Name))
+		}
+
+		s.Drop = c.genFunctionDeclaration(f).Func
+	}
+
+	c.contextModuleName = tmpModName
+}
+
+func (c *CodeGen) genStructFunctionBody(stmt *ast.StructStatement) {
+	if len(stmt.TypeParams) != 0 {
+		return
+	}
+
+	c.genStructFunctionBodies(stmt.Ident.Name, stmt)
+}
+
+func (c *CodeGen) genStructFunctionBodies(name string, stmt *ast.StructStatement) {
+	tmpModName := c.contextModuleName
+	c.contextModuleName = name
+
+	for _, f := range stmt.Functions {
+		if f.Body != nil {
+			c.genFunctionBody(f)
+		}
+	}
+
+	c.contextModuleName = tmpModName
+}
+
+// genDrop drops the value stored at addr.
+func (c *CodeGen) genDrop(block *ir.Block, addr value.Value) {
+	block.NewCall(c.dropGlue(internal.PtrElmType(addr)), addr)
+}
+
+// dropGlue returns a function that calls the drop function of typ and then
+// drops its members.
+func (c *CodeGen) dropGlue(typ types.Type) *ir.Func {
+	name := "drop." + typeName(typ)
+	if glue, ok := c.runtime.helpers[name]; ok {
+		return glue
+	}
+
+	param := ir.NewParam("ptr", types.NewPointer(typ))
+	glue := c.module.NewFunc(name, types.Void, param)
+	c.runtime.helpers[name] = glue
+
+	block := glue.NewBlock("entry")
+	zero := constant.NewInt(types.I32, 0)
+
+	switch typ := typ.(type) {
+	case *types.StructType:
+		s, _ := c.context.findStruct(typ.Name())
+		if s.Drop != nil {
+			block.NewCall(s.Drop, param)
+		}
+		for i, f := range typ.Fields {
+			if !c.isDroppable(f) {
+				continue
+			}
+			field := block.NewGetElementPtr(typ, param, zero, constant.NewInt(types.I32, int64(i)))
+			c.genDrop(block, field)
+		}
+	case *types.ArrayType:
+		for i := uint64(0); i < typ.Len; i++ {
+			elm := block.NewGetElementPtr(typ, param, zero, constant.NewInt(types.I32, int64(i)))
+			c.genDrop(block, elm)
+		}
+	}
+
+	block.NewRet(nil)
+	return glue
+}
+
+// newDropFlag returns a flag that is false until the variable is initialized.
+func (c *CodeGen) newDropFlag() value.Value {
+	flag := c.contextEntryBlock.NewAlloca(types.I1)
+	c.contextEntryBlock.NewStore(constant.False, flag)
+	c.contextBlock.NewStore(constant.True, flag)
+	return flag
+}
+
+// ownDrop makes the current scope responsible for dropping the variable v,
+// and returns its drop flag. v is not dropped until it is assigned unless
+// isInitialized is set.
+func (c *CodeGen) ownDrop(v value.Value, isInitialized bool) value.Value {
+	typ := internal.PtrElmType(v)
+	if !c.isDroppable(typ) {
+		return nil
+	}
+
+	// global variables are dropped at exit unconditionally
+	d := dropVar{slot: v}
+	if c.contextFunction != c.initFunc {
+		d.flag = c.newDropFlag()
+		if !isInitialized {
+			c.contextBlock.NewStore(constant.False, d.flag)
+			d.parts = c.newPartFlags(typ)
+		}
+	}
+
+	c.context.drops = append(c.context.drops, d)
+	c.dropVars[v] = d
+	return d.flag
+}
+
+// newPartFlags returns the flags of the members of a struct or the elements of
+// an array typ, which are false until assigned. The members of a struct with a
+// drop function are not dropped alone, so it has none.
+func (c *CodeGen) newPartFlags(typ types.Type) value.Value {
+	n := len(partTypes(typ))
+	if s, ok := c.context.findStruct(typ.Name()); ok && (s.Drop != nil || s.Underlying != nil) || n == 0 {
+		return nil
+	}
+
+	parts := c.contextEntryBlock.NewAlloca(types.NewArray(uint64(n), types.I1))
+	c.clearParts(c.contextBlock, parts)
+	return parts
+}
+
+// partTypes returns the types of the members of a struct or the elements of an
+// array typ.
+func partTypes(typ types.Type) []types.Type {
+	switch typ := typ.(type) {
+	case *types.StructType:
+		return typ.Fields
+	case *types.ArrayType:
+		parts := make([]types.Type, typ.Len)
+		for i := range parts {
+			parts[i] = typ.ElemType
+		}
+		return parts
+	}
+	return nil
+}
+
+// clearParts marks every part as unassigned, since the whole variable owns
+// them or nothing does.
+func (c *CodeGen) clearParts(block *ir.Block, parts value.Value) {
+	if parts != nil {
+		block.NewStore(constant.NewZeroInitializer(internal.PtrElmType(parts)), parts)
+	}
+}
+
+// genMove transfers the ownership of v, which was evaluated from expr, to the
+// destination of a copy. Only variables and results of calls can be moved.
+func (c *CodeGen) genMove(v Value, expr ast.Expression, pos token.Position) {
+	typ := v.Value.Type()
+	if v.IsVariable {
+		typ = internal.PtrElmType(v.Value)
+	}
+
+	if !c.isDroppable(typ) {
+		return
+	}
+
+	if v.DropFlag == nil {
+		errors.ErrorExit(fmt.Sprintf("%s | cannot move out of '%s'", pos, ast.Show(expr)))
+	}
+
+	c.contextBlock.NewStore(constant.False, v.DropFlag)
+	if v.IsVariable {
+		c.clearParts(c.contextBlock, c.dropVars[v.Value].parts)
+		c.markMoved(v, expr, pos)
+	}
+}
+
+// genDropVar drops d if it still owns its value, or the parts of d assigned
+// alone.
+func (c *CodeGen) genDropVar(d dropVar) {
+	if d.flag == nil {
+		c.genDrop(c.contextBlock, d.slot)
+		return
+	}
+
+	blockDrop := c.contextFunction.NewBlock(internal.NextLabel("drop"))
+	blockParts := c.contextFunction.NewBlock(internal.NextLabel("drop.parts"))
+	blockNext := c.contextFunction.NewBlock(internal.NextLabel("drop.next"))
+
+	flag := c.contextBlock.NewLoad(types.I1, d.flag)
+	c.contextBlock.NewCondBr(flag, blockDrop, blockParts)
+
+	c.genDrop(blockDrop, d.slot)
+	blockDrop.NewStore(constant.False, d.flag)
+	blockDrop.NewBr(blockNext)
+
+	c.contextBlock = blockParts
+	if d.parts != nil {
+		zero := constant.NewInt(types.I32, 0)
+		typ := internal.PtrElmType(d.slot)
+		for i, partTyp := range partTypes(typ) {
+			if !c.isDroppable(partTyp) {
+				continue
+			}
+			index := constant.NewInt(types.I32, int64(i))
+			c.genDropVar(dropVar{
+				slot: c.contextBlock.NewGetElementPtr(typ, d.slot, zero, index),
+				flag: c.contextBlock.NewGetElementPtr(internal.PtrElmType(d.parts), d.parts, zero, index),
+			})
+		}
+	}
+	c.contextBlock.NewBr(blockNext)
+
+	c.contextBlock = blockNext
+}
+
+// genDropOld drops the value at dest before it is overwritten, unless dest is
+// a variable or its part not assigned yet. dest owns the new value then.
+func (c *CodeGen) genDropOld(dest Value) {
+	d, part, ok := c.findDropVar(dest.Value)
+	if !ok {
+		if c.isDroppable(internal.PtrElmType(dest.Value)) {
+			c.genDropVar(dropVar{slot: dest.Value, flag: dest.DropFlag})
+		}
+		if dest.DropFlag != nil {
+			c.contextBlock.NewStore(constant.True, dest.DropFlag)
+		}
+		return
+	}
+
+	if part == nil {
+		c.genDropVar(d)
+	} else if c.isDroppable(internal.PtrElmType(dest.Value)) {
+		var owned value.Value = c.contextBlock.NewLoad(types.I1, d.flag)
+		if d.parts != nil {
+			flag := c.partFlag(d, part)
+			owned = c.contextBlock.NewOr(owned, c.contextBlock.NewLoad(types.I1, flag))
+		}
+
+		blockDrop := c.contextFunction.NewBlock(internal.NextLabel("drop"))
+		blockNext := c.contextFunction.NewBlock(internal.NextLabel("drop.next"))
+		c.contextBlock.NewCondBr(owned, blockDrop, blockNext)
+		c.genDrop(blockDrop, dest.Value)
+		blockDrop.NewBr(blockNext)
+		c.contextBlock = blockNext
+	}
+	c.markOwned(dest.Value)
+}
+
+// markOwned records that the variable or its part at ptr holds a value, which
+// is dropped at scope exit.
+func (c *CodeGen) markOwned(ptr value.Value) {
+	d, part, ok := c.findDropVar(ptr)
+	if !ok {
+		return
+	}
+
+	if part == nil || d.parts == nil {
+		c.contextBlock.NewStore(constant.True, d.flag)
+		c.clearParts(c.contextBlock, d.parts)
+		return
+	}
+	c.contextBlock.NewStore(constant.True, c.partFlag(d, part))
+}
+
+// findDropVar returns the variable with a drop flag at ptr, or the variable
+// ptr points into with the index of the member or element containing it.
+func (c *CodeGen) findDropVar(ptr value.Value) (dropVar, value.Value, bool) {
+	if d, ok := c.dropVars[ptr]; ok && d.flag != nil {
+		return d, nil, true
+	}
+
+	for {
+		gep, ok := ptr.(*ir.InstGetElementPtr)
+		if !ok || len(gep.Indices) < 2 {
+			return dropVar{}, nil, false
+		}
+		if d, ok := c.dropVars[gep.Src]; ok && d.flag != nil {
+			return d, gep.Indices[1], true
+		}
+		ptr = gep.Src
+	}
+}
+
+// partFlag returns the flag of the part of d at index.
+func (c *CodeGen) partFlag(d dropVar, index value.Value) value.Value {
+	zero := constant.NewInt(types.I32, 0)
+	return c.contextBlock.NewGetElementPtr(internal.PtrElmType(d.parts), d.parts, zero, index)
+}
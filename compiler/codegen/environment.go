@@ -1,6 +1,7 @@
 package codegen
 
 import (
+	"github.com/arata-nvm/visket/compiler/ast"
 	"github.com/arata-nvm/visket/compiler/codegen/internal"
 	"github.com/llir/llvm/ir"
 	llvmType "github.com/llir/llvm/ir/types"
@@ -9,10 +10,22 @@ import (
 
 type Context struct {
 	variables map[string]Value
-	functions map[string]*Func
+	functions map[string][]*Func
 	types     map[string]llvmType.Type
 	structs   map[string]*Struct
 	parent    *Context
+
+	genericFunctions map[string]*GenericFunc
+	genericStructs   map[string]*GenericStruct
+	interfaces       map[string]*Interface
+
+	// owned holds the variables whose references are released at scope exit
+	owned []value.Value
+	// drops holds the variables dropped at scope exit
+	drops []dropVar
+
+	// closure is set on the outermost scope of a closure body
+	closure *Closure
 }
 
 type Value struct {
@@ -20,6 +33,17 @@ type Value struct {
 	IsVariable  bool
 	IsReference bool
 	IsConstant  bool
+	// Binding is the constant the value is reached from, like 'p' of 'p.name'
+	Binding *Binding
+
+	// DropFlag is cleared when the value is moved out of the variable
+	DropFlag value.Value
+
+	// Escape is set on closures that capture local variables by reference
+	Escape *Escape
+
+	// Const is the value of constants known at compile time
+	Const interface{}
 }
 
 func (v Value) Load(block *ir.Block) value.Value {
@@ -35,6 +59,10 @@ func (v Value) Dereference(block *ir.Block) Value {
 			Value:       block.NewLoad(internal.PtrElmType(v.Value), v.Value),
 			IsVariable:  true,
 			IsReference: v.IsReference,
+			IsConstant:  v.IsConstant,
+			Binding:     v.Binding,
+			DropFlag:    v.DropFlag,
+			Escape:      v.Escape,
 		}
 	}
 	return v
@@ -44,15 +72,28 @@ func (v Value) Dereference(block *ir.Block) Value {
 type Func struct {
 	Func        *ir.Func
 	IsReference []bool
+	// IsReadOnly is set for 'in' parameters, which are passed by reference but
+	// cannot be modified
+	IsReadOnly []bool
+	// IsMethod is set for methods taking self as the first parameter
+	IsMethod bool
+	// IsVariadic is set for functions whose last parameter is variadic
+	IsVariadic bool
+	// Stmt is the declaration of the function, or nil for builtin functions
+	Stmt *ast.FunctionStatement
 }
 
 func newContext(parent *Context) *Context {
 	c := &Context{
 		variables: make(map[string]Value),
-		functions: make(map[string]*Func),
+		functions: make(map[string][]*Func),
 		types:     make(map[string]llvmType.Type),
 		structs:   make(map[string]*Struct),
 		parent:    parent,
+
+		genericFunctions: make(map[string]*GenericFunc),
+		genericStructs:   make(map[string]*GenericStruct),
+		interfaces:       make(map[string]*Interface),
 	}
 
 	c.initType()
@@ -95,17 +136,29 @@ func (c *Context) findVariableCurrent(name string) (Value, bool) {
 }
 
 func (c *Context) addFunction(name string, f *Func) {
-	c.functions[name] = f
+	c.functions[name] = append(c.functions[name], f)
 }
 
+// findFunction finds the function name. An overloaded function is found only
+// if the name has just one function.
 func (c *Context) findFunction(name string) (*Func, bool) {
-	f, ok := c.functions[name]
+	fs := c.findFunctions(name)
+	if len(fs) != 1 {
+		return nil, false
+	}
+
+	return fs[0], true
+}
+
+// findFunctions returns the overloads of the function name.
+func (c *Context) findFunctions(name string) []*Func {
+	fs, ok := c.functions[name]
 
 	if !ok && c.parent != nil {
-		return c.parent.findFunction(name)
+		return c.parent.findFunctions(name)
 	}
 
-	return f, ok
+	return fs
 }
 
 func (c *Context) addType(name string, t llvmType.Type) {
@@ -137,6 +190,81 @@ func (c *Context) findStruct(name string) (*Struct, bool) {
 	return s, ok
 }
 
+func (c *Context) addGenericFunction(name string, g *GenericFunc) {
+	c.genericFunctions[name] = g
+}
+
+func (c *Context) findGenericFunction(name string) (*GenericFunc, bool) {
+	g, ok := c.genericFunctions[name]
+
+	if !ok && c.parent != nil {
+		return c.parent.findGenericFunction(name)
+	}
+
+	return g, ok
+}
+
+func (c *Context) addGenericStruct(name string, g *GenericStruct) {
+	c.genericStructs[name] = g
+}
+
+func (c *Context) findGenericStruct(name string) (*GenericStruct, bool) {
+	g, ok := c.genericStructs[name]
+
+	if !ok && c.parent != nil {
+		return c.parent.findGenericStruct(name)
+	}
+
+	return g, ok
+}
+
+func (c *Context) addInterface(name string, i *Interface) {
+	c.interfaces[name] = i
+	c.addType(name, i.Type)
+}
+
+func (c *Context) findInterface(name string) (*Interface, bool) {
+	i, ok := c.interfaces[name]
+
+	if !ok && c.parent != nil {
+		return c.parent.findInterface(name)
+	}
+
+	return i, ok
+}
+
+// within reports whether c is scope or one of its descendants.
+func (c *Context) within(scope *Context) bool {
+	for ; c != nil; c = c.parent {
+		if c == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// declares reports whether c or its ancestors declare the variable at slot.
+func (c *Context) declares(slot value.Value) bool {
+	for ; c != nil; c = c.parent {
+		for _, v := range c.variables {
+			if v.Value == slot {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (c *Context) root() *Context {
+	if c.parent == nil {
+		return c
+	}
+
+	return c.parent.root()
+}
+
 func (c *CodeGen) into() {
 	c.context = newContext(c.context)
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/arata-nvm/visket/compiler/codegen/internal"
 	"github.com/arata-nvm/visket/compiler/errors"
 	"github.com/arata-nvm/visket/compiler/token"
+	"github.com/llir/llvm/ir"
 	"github.com/llir/llvm/ir/constant"
 	"github.com/llir/llvm/ir/enum"
 	"github.com/llir/llvm/ir/types"
@@ -33,10 +34,25 @@ func (c *CodeGen) genExpression(expr ast.Expression) Value {
 		return c.genCharLiteral(expr)
 	case *ast.Identifier:
 		return c.genIdentifier(expr)
+	case *ast.NilLiteral:
+		return c.genNilLiteral(expr)
+	case *ast.PrefixExpression:
+		return c.genPrefixExpression(expr)
 	case *ast.NewExpression:
 		return c.genNewExpression(expr)
 	case *ast.LoadMemberExpression:
 		return c.genLoadMemberExpression(expr)
+	case *ast.FunctionLiteral:
+		return c.genFunctionLiteral(expr)
+	case *ast.ComptimeExpression:
+		return c.genComptimeExpression(expr)
+	case *ast.IfExpression:
+		return c.genIfExpression(expr.Stmt)
+	case *ast.BlockExpression:
+		return c.genBlockExpression(expr.Block)
+	case *ast.SizeofExpression, *ast.AlignofExpression, *ast.OffsetofExpression:
+		v, _ := c.evalLayout(expr)
+		return Value{Value: c.constValue(v)}
 	}
 
 	errors.ErrorExit(fmt.Sprintf("unexpexted expression: %s\n", ast.Show(expr)))
@@ -44,8 +60,77 @@ func (c *CodeGen) genExpression(expr ast.Expression) Value {
 }
 
 func (c *CodeGen) genInfix(ie *ast.InfixExpression) Value {
-	lhs := c.genExpression(ie.Left).Load(c.contextBlock)
-	rhs := c.genExpression(ie.Right).Load(c.contextBlock)
+	if ie.Op == token.LAND || ie.Op == token.LOR {
+		return c.genLogical(ie)
+	}
+
+	left := c.genExpression(ie.Left)
+	right := c.genExpression(ie.Right)
+
+	if v, ok := c.genOperator(ie, left, right); ok {
+		return v
+	}
+
+	lhs := left.Load(c.contextBlock)
+	rhs := right.Load(c.contextBlock)
+
+	// distinct types take the operators of the underlying type
+	if s, ok := c.findNewtype(lhs.Type()); ok && lhs.Type().Equal(rhs.Type()) {
+		lhs = c.contextBlock.NewExtractValue(lhs, 0)
+		rhs = c.contextBlock.NewExtractValue(rhs, 0)
+		v := c.genInfixValues(ie, lhs, rhs)
+		if !isComparison(ie.Op) {
+			v.Value = c.newtypeValue(s, v.Value)
+		}
+		return v
+	}
+
+	return c.genInfixValues(ie, lhs, rhs)
+}
+
+// genLogical generates '&&' and '||', which evaluate the right operand only if
+// the left one does not decide the result.
+func (c *CodeGen) genLogical(ie *ast.InfixExpression) Value {
+	lhs := c.genBool(ie.Left, ie)
+	blockLhs := c.contextBlock
+	blockRhs := c.contextFunction.NewBlock(internal.NextLabel("logical.rhs"))
+	blockMerge := c.contextFunction.NewBlock(internal.NextLabel("logical.merge"))
+
+	// the merge block takes over the jump to the end of the enclosing block
+	blockMerge.Term = blockLhs.Term
+	if ie.Op == token.LAND {
+		blockLhs.NewCondBr(lhs, blockRhs, blockMerge)
+	} else {
+		blockLhs.NewCondBr(lhs, blockMerge, blockRhs)
+	}
+
+	c.contextBlock = blockRhs
+	var rhs value.Value
+	c.genMaybe(func() {
+		rhs = c.genBool(ie.Right, ie)
+	})
+	blockRhsEnd := c.contextBlock
+	blockRhsEnd.NewBr(blockMerge)
+
+	c.contextBlock = blockMerge
+	short := constant.NewBool(ie.Op == token.LOR)
+	return Value{
+		Value: blockMerge.NewPhi(ir.NewIncoming(short, blockLhs), ir.NewIncoming(rhs, blockRhsEnd)),
+	}
+}
+
+func (c *CodeGen) genBool(expr ast.Expression, ie *ast.InfixExpression) value.Value {
+	v := c.genExpression(expr).Load(c.contextBlock)
+	if !v.Type().Equal(types.I1) {
+		errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", ie.OpPos, v.Type(), types.I1))
+	}
+	return v
+}
+
+func (c *CodeGen) genInfixValues(ie *ast.InfixExpression, lhs value.Value, rhs value.Value) Value {
+	// nil takes the type of the other operand
+	lhs = c.convertValue(Value{Value: lhs}, rhs.Type(), ie.OpPos)
+	rhs = c.convertValue(Value{Value: rhs}, lhs.Type(), ie.OpPos)
 
 	lhsTyp := lhs.Type()
 	rhsTyp := rhs.Type()
@@ -57,13 +142,25 @@ func (c *CodeGen) genInfix(ie *ast.InfixExpression) Value {
 	}
 
 	if !lhsTyp.Equal(rhsTyp) {
-		errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", ie.OpPos, lhsTyp, rhsTyp))
+		errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", ie.OpPos, showType(lhsTyp), showType(rhsTyp)))
 	}
 
 	if lhsTyp.Equal(types.Float) {
 		return c.genInfixFloat(ie.Op, lhs, rhs, ie.OpPos)
 	}
 
+	if _, ok := lhsTyp.(*types.PointerType); ok {
+		return c.genInfixPointer(ie.Op, lhs, rhs, ie.OpPos)
+	}
+
+	if _, ok := funcSig(lhsTyp); ok {
+		return c.genInfixFunc(ie.Op, lhs, rhs, ie.OpPos)
+	}
+
+	if _, ok := lhsTyp.(*types.IntType); !ok {
+		errors.ErrorExit(fmt.Sprintf("%s | unexpected operator: %s %s %s", ie.OpPos, lhsTyp, ie.Op, rhsTyp))
+	}
+
 	// TODO make default infix expr gen
 	return c.genInfixInteger(ie.Op, lhs, rhs, ie.OpPos)
 }
@@ -142,16 +239,128 @@ func (c *CodeGen) genInfixFloat(op string, lhs value.Value, rhs value.Value, pos
 	}
 }
 
+func (c *CodeGen) genInfixPointer(op string, lhs value.Value, rhs value.Value, pos token.Position) Value {
+	var opResult value.Value
+
+	switch op {
+	case "==":
+		opResult = c.contextBlock.NewICmp(enum.IPredEQ, lhs, rhs)
+	case "!=":
+		opResult = c.contextBlock.NewICmp(enum.IPredNE, lhs, rhs)
+	default:
+		errors.ErrorExit(fmt.Sprintf("%s | unexpected operator: %s %s %s", pos, lhs.Type(), op, rhs.Type()))
+	}
+
+	return Value{
+		Value:      opResult,
+		IsVariable: false,
+	}
+}
+
+// genInfixFunc compares function values, which are equal if both the function
+// and the environment are the same.
+func (c *CodeGen) genInfixFunc(op string, lhs value.Value, rhs value.Value, pos token.Position) Value {
+	fn := c.genInfixPointer(op, c.contextBlock.NewExtractValue(lhs, 0), c.contextBlock.NewExtractValue(rhs, 0), pos)
+	env := c.genInfixPointer(op, c.contextBlock.NewExtractValue(lhs, 1), c.contextBlock.NewExtractValue(rhs, 1), pos)
+
+	var opResult value.Value
+	if op == "==" {
+		opResult = c.contextBlock.NewAnd(fn.Value, env.Value)
+	} else {
+		opResult = c.contextBlock.NewOr(fn.Value, env.Value)
+	}
+
+	return Value{
+		Value:      opResult,
+		IsVariable: false,
+	}
+}
+
 func (c *CodeGen) genCallExpression(expr *ast.CallExpression) Value {
-	f, ok := c.context.findFunction(expr.Function.Name)
-	if !ok {
-		errors.ErrorExit(fmt.Sprintf("%s | undefined function '%s'", expr.LParen, expr.Function.Name))
+	if v, ok := c.genConversion(expr); ok {
+		return v
 	}
 
-	if len(expr.Args) < len(f.Func.Params) {
-		errors.ErrorExit(fmt.Sprintf("%s | not enough arguments in call to '%s'", expr.LParen, expr.Function.Name))
-	} else if !f.Func.Sig.Variadic && len(expr.Args) > len(f.Func.Params) {
-		errors.ErrorExit(fmt.Sprintf("%s | too many arguments in call to '%s'", expr.LParen, expr.Function.Name))
+	f := c.genCallee(expr)
+	funcRet := c.contextBlock.NewCall(f.fn, f.args...)
+	if isNoReturn(f.fn) {
+		c.genNoReturn()
+	}
+
+	return c.genTemporary(funcRet)
+}
+
+// genCallee resolves the function called by expr, instantiating a generic
+// function or looking up the vtable of an interface if needed, and evaluates
+// the arguments.
+func (c *CodeGen) genCallee(expr *ast.CallExpression) *callee {
+	if f, ok := c.genFunctionValue(expr); ok {
+		return f
+	}
+
+	args := c.genArgs(expr)
+
+	if expr.IsMethod {
+		if f, ok := c.findMethod(expr, args); ok {
+			return c.directCallee(expr, f, args)
+		}
+	}
+
+	if len(args) != 0 {
+		if i, ok := c.findInterfaceType(valueType(args[0])); ok {
+			if id, m := i.findMethod(expr.Function.Name); m != nil {
+				c.checkPositional(expr)
+				fn := c.genInterfaceCallee(i, id, args)
+				isReference := append([]bool{false}, m.IsReference...)
+				isReadOnly := append([]bool{false}, m.IsReadOnly...)
+				return &callee{
+					fn:          fn,
+					isReference: isReference,
+					args:        c.genCallArgs(expr, m.funcType(), isReference, isReadOnly, args),
+				}
+			}
+		}
+	}
+
+	if fs := c.context.findFunctions(expr.Function.Name); len(fs) != 0 {
+		f := c.resolveOverload(expr, expr.Function.Name, fs, args, 0)
+		return c.directCallee(expr, f, args)
+	}
+
+	if g, ok := c.context.findGenericFunction(expr.Function.Name); ok {
+		if !isVariadic(g.Stmt) {
+			expr, args = c.arrangeCall(expr, g.Stmt, args)
+		}
+		f := c.instantiateFunction(g, c.inferTypeArgs(g, expr, args), expr.LParen)
+		return c.directCallee(expr, f, args)
+	}
+
+	if f, ok := c.genFieldCallee(expr, args); ok {
+		return f
+	}
+
+	errors.ErrorExit(fmt.Sprintf("%s | undefined function '%s'", expr.LParen, expr.Function.Name))
+	return nil // unreachable
+}
+
+func (c *CodeGen) directCallee(expr *ast.CallExpression, f *Func, args []Value) *callee {
+	if f.IsVariadic {
+		expr, args = c.packVariadic(expr, f, args)
+	}
+	expr, args = c.arrangeCall(expr, f.Stmt, args)
+	return &callee{
+		fn:          f.Func,
+		isReference: f.IsReference,
+		args:        c.genCallArgs(expr, f.Func.Sig, f.IsReference, f.IsReadOnly, args),
+	}
+}
+
+// genCallArgs converts the evaluated arguments args into the parameters of sig.
+func (c *CodeGen) genCallArgs(expr *ast.CallExpression, sig *types.FuncType, isReference []bool, isReadOnly []bool, args []Value) []value.Value {
+	if len(expr.Args) < len(sig.Params) {
+		errors.ErrorExit(fmt.Sprintf("%s | not enough arguments in call to '%s'", expr.LParen, calleeName(expr)))
+	} else if !sig.Variadic && len(expr.Args) > len(sig.Params) {
+		errors.ErrorExit(fmt.Sprintf("%s | too many arguments in call to '%s'", expr.LParen, calleeName(expr)))
 	}
 
 	var params []value.Value
@@ -159,51 +368,96 @@ func (c *CodeGen) genCallExpression(expr *ast.CallExpression) Value {
 	for i, param := range expr.Args {
 		// TODO rewrite
 		// isReference
-		exprVal := c.genExpression(param)
+		exprVal := args[i]
 		var v value.Value
-		if i < len(f.IsReference) && f.IsReference[i] {
-			if !exprVal.IsVariable || exprVal.IsConstant {
-				errors.ErrorExit(fmt.Sprintf("%s | a ref value must be an assignable variable", expr.RParen))
-			}
-			v = exprVal.Value
+		if i < len(isReadOnly) && isReadOnly[i] {
+			c.checkArg(param, exprVal, false)
+			v = c.genReadOnlyArg(expr, exprVal, sig.Params[i])
+		} else if i < len(isReference) && isReference[i] {
+			c.checkArg(param, exprVal, true)
+			v = c.genRefArg(expr, param, exprVal)
+		} else if i < len(sig.Params) {
+			c.checkArg(param, exprVal, false)
+			c.checkCopyable(exprVal, param, expr.LParen)
+			v = c.convertValue(exprVal, sig.Params[i], expr.LParen)
+			c.genMove(exprVal, param, expr.LParen)
 		} else {
+			c.checkArg(param, exprVal, false)
+			c.checkCopyable(exprVal, param, expr.LParen)
 			v = exprVal.Load(c.contextBlock)
+			c.genMove(exprVal, param, expr.LParen)
 		}
 		params = append(params, v)
-		if i >= len(f.Func.Sig.Params) {
+		if i >= len(sig.Params) {
 			// variadic function
 			continue
 		}
-		if !v.Type().Equal(f.Func.Sig.Params[i]) {
-			errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", expr.LParen, v.Type(), f.Func.Sig.Params[i]))
+		if !v.Type().Equal(sig.Params[i]) {
+			errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", expr.LParen, showType(v.Type()), showType(sig.Params[i])))
 		}
 	}
 
-	funcRet := c.contextBlock.NewCall(f.Func, params...)
+	return params
+}
 
-	return Value{
-		Value:      funcRet,
-		IsVariable: false,
+// genReadOnlyArg returns the address passed to an 'in' parameter of typ.
+// Constants are passed as is, and temporaries are stored to be passed.
+func (c *CodeGen) genReadOnlyArg(expr *ast.CallExpression, v Value, typ types.Type) value.Value {
+	elem := typ.(*types.PointerType).ElemType
+	if v.IsVariable && valueType(v).Equal(elem) {
+		return v.Value
 	}
-}
 
-func (c *CodeGen) genAssignExpression(expr *ast.AssignExpression) Value {
-	left := c.genExpression(expr.Left)
-	if left.IsConstant {
-		errors.ErrorExit(fmt.Sprintf("%s | constant '%s' cannot be reassigned", expr.OpPos, ast.Show(expr.Left)))
+	val := c.convertValue(v, elem, expr.LParen)
+	if !val.Type().Equal(elem) {
+		errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", expr.LParen, showType(val.Type()), showType(elem)))
 	}
-	lhs := left.Value
 
-	rhs := c.genExpression(expr.Value).Load(c.contextBlock)
+	tmp := c.contextEntryBlock.NewAlloca(elem)
+	c.contextBlock.NewStore(val, tmp)
+	return tmp
+}
 
+// genRefArg returns the address of the variable passed to a ref parameter.
+func (c *CodeGen) genRefArg(expr *ast.CallExpression, arg ast.Expression, v Value) value.Value {
+	if !v.IsVariable {
+		errors.ErrorExit(fmt.Sprintf("%s | a ref value must be an assignable variable", expr.RParen))
+	}
+	if b := v.Binding; v.IsConstant && b != nil {
+		if ident, ok := arg.(*ast.Identifier); ok && ident.Name == b.Name {
+			c.constantError(b, fmt.Sprintf("%s | cannot pass constant '%s' by ref", expr.RParen, b.Name))
+		}
+		c.constantError(b, fmt.Sprintf("%s | cannot pass '%s' of constant '%s' by ref", expr.RParen, showPlace(arg), b.Name))
+	}
+	if v.IsConstant {
+		errors.ErrorExit(fmt.Sprintf("%s | a ref value must be an assignable variable", expr.RParen))
+	}
+	// the callee may assign it
+	c.markOwned(v.Value)
+	return v.Value
+}
+
+func (c *CodeGen) genAssignExpression(expr *ast.AssignExpression) Value {
+	left := c.genPlace(expr.Left)
+	c.checkAssignable(left, expr.Left, expr.OpPos)
+	lhs := left.Value
 	lhsTyp := internal.PtrElmType(lhs)
+
+	right := c.genExpression(expr.Value)
+	c.checkEscape(expr.Left, right, expr.OpPos)
+	c.checkCopyable(right, expr.Value, expr.OpPos)
+	rhs := c.convertValue(right, lhsTyp, expr.OpPos)
 	rhsTyp := rhs.Type()
 
 	if !lhsTyp.Equal(rhsTyp) {
-		errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", expr.OpPos, lhsTyp, rhsTyp))
+		errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", expr.OpPos, showType(lhsTyp), showType(rhsTyp)))
 	}
 
-	c.contextBlock.NewStore(rhs, lhs)
+	c.genMove(right, expr.Value, expr.OpPos)
+	c.genDropOld(left)
+	c.genAssign(lhs, rhs)
+	c.markAssigned(left)
+	c.markAssignedPlace(expr.Left)
 
 	return Value{
 		Value:      rhs,
@@ -212,15 +466,24 @@ func (c *CodeGen) genAssignExpression(expr *ast.AssignExpression) Value {
 }
 
 func (c *CodeGen) genIndexExpression(expr *ast.IndexExpression) Value {
-	left := c.genExpression(expr.Left).Value
+	leftVal := c.genExpression(expr.Left)
+	if v, ok := c.genIndexOperator(expr, leftVal); ok {
+		return v
+	}
+
+	left := c.derefPointer(leftVal)
 	leftTyp := internal.PtrElmType(left)
 
 	if _, ok := leftTyp.(*types.ArrayType); ok {
-		return c.genArrayIndexing(left, leftTyp, expr)
+		return reachedFrom(c.genArrayIndexing(left, leftTyp, expr), leftVal)
 	}
 
 	if leftTyp.Equal(builtin.STRING) {
-		return c.genStringIndexing(left, expr)
+		return reachedFrom(c.genStringIndexing(left, expr), leftVal)
+	}
+
+	if _, ok := c.sliceElem(leftTyp); ok {
+		return reachedFrom(c.genSliceIndexing(left, leftTyp, expr), leftVal)
 	}
 
 	errors.ErrorExit(fmt.Sprintf("%s | cannot index '%s'", expr.LBrack, leftTyp))
@@ -275,29 +538,92 @@ func (c *CodeGen) genCharLiteral(expr *ast.CharLiteral) Value {
 	}
 }
 
+func (c *CodeGen) genNilLiteral(expr *ast.NilLiteral) Value {
+	return Value{
+		Value:      constant.NewNull(types.I8Ptr),
+		IsVariable: false,
+	}
+}
+
 func (c *CodeGen) genIdentifier(expr *ast.Identifier) Value {
-	v, ok := c.context.findVariable(expr.Name)
+	v := c.genVariable(expr)
+	c.checkAssigned(expr, v)
+	return v
+}
+
+// genVariable generates the variable expr without checking that it is assigned.
+func (c *CodeGen) genVariable(expr *ast.Identifier) Value {
+	v, ok := c.findName(expr)
 	if !ok {
+		if f, ok := c.findFunctionValue(expr); ok {
+			return Value{Value: f}
+		}
 		errors.ErrorExit(fmt.Sprintf("%s | unresolved variable '%s'", expr.Pos, expr.Name))
 	}
 
 	return v.Dereference(c.contextBlock)
 }
 
+func (c *CodeGen) genPrefixExpression(expr *ast.PrefixExpression) Value {
+	switch expr.Op {
+	case "&":
+		if c.options.ARC {
+			errors.ErrorExit(fmt.Sprintf("%s | cannot take the address with automatic reference counting", expr.OpPos))
+		}
+
+		v := c.genPlace(expr.Right)
+		if !v.IsVariable {
+			errors.ErrorExit(fmt.Sprintf("%s | cannot take the address of '%s'", expr.OpPos, ast.Show(expr.Right)))
+		}
+		c.checkAddressable(v, expr.Right, expr.OpPos)
+		// the variable may be assigned through the pointer
+		c.markAssignedPlace(expr.Right)
+		c.markAssigned(v)
+		c.markOwned(v.Value)
+
+		return Value{
+			Value:      v.Value,
+			IsVariable: false,
+		}
+	case "*":
+		v := c.genExpression(expr.Right)
+		ptr := v.Load(c.contextBlock)
+		if _, ok := ptr.Type().(*types.PointerType); !ok {
+			errors.ErrorExit(fmt.Sprintf("%s | cannot dereference '%s'", expr.OpPos, ptr.Type()))
+		}
+
+		return reachedFrom(Value{
+			Value:      ptr,
+			IsVariable: true,
+		}, v)
+	case "!":
+		v := c.genExpression(expr.Right).Load(c.contextBlock)
+		if !v.Type().Equal(types.I1) {
+			errors.ErrorExit(fmt.Sprintf("%s | unexpected operator: %s%s", expr.OpPos, expr.Op, v.Type()))
+		}
+
+		return Value{
+			Value: c.contextBlock.NewXor(v, constant.True),
+		}
+	}
+
+	errors.ErrorExit(fmt.Sprintf("%s | unexpected operator: %s", expr.OpPos, expr.Op))
+	return Value{} // unreachable
+}
+
 func (c *CodeGen) genNewExpression(expr *ast.NewExpression) Value {
 	typ := c.llvmType(expr.Type)
-	val := c.contextBlock.NewAlloca(typ)
-	initVal := constant.NewZeroInitializer(typ)
-	c.contextBlock.NewStore(initVal, val)
 
-	return Value{
-		Value:      val,
-		IsVariable: true,
-	}
+	return c.genTemporary(c.genAlloc(typ, expr.New))
 }
 
 func (c *CodeGen) genLoadMemberExpression(expr *ast.LoadMemberExpression) Value {
-	lhs := c.genExpression(expr.Left).Value
+	return c.genMember(expr, c.genExpression(expr.Left))
+}
+
+// genMember generates the member of expr in base, the value of expr.Left.
+func (c *CodeGen) genMember(expr *ast.LoadMemberExpression, base Value) Value {
+	lhs := c.derefPointer(base)
 	lhsTyp := internal.PtrElmType(lhs)
 
 	structLlvmTyp, ok := lhsTyp.(*types.StructType)
@@ -319,14 +645,15 @@ func (c *CodeGen) genLoadMemberExpression(expr *ast.LoadMemberExpression) Value
 		errors.ErrorExit(fmt.Sprintf("%s | unresolved member '%s'", expr.Period, expr.MemberIdent.Name))
 	}
 
-	member := structTyp.Members[id]
-
 	zero := constant.NewInt(types.I32, 0)
-	index := constant.NewInt(types.I32, int64(member.Id))
+	index := constant.NewInt(types.I32, int64(id))
 	val := c.contextBlock.NewGetElementPtr(lhsTyp, lhs, zero, index)
 
-	return Value{
+	v := reachedFrom(Value{
 		Value:      val,
 		IsVariable: true,
-	}
+	}, base)
+	// the fields of slices cannot be assigned
+	v.IsConstant = v.IsConstant || structTyp.Slice != nil
+	return v
 }
@@ -0,0 +1,238 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/errors"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"strings"
+)
+
+// callee is a function to be called with its arguments.
+type callee struct {
+	fn          value.Value
+	isReference []bool
+	args        []value.Value
+
+	// env is the environment of a closure, which must be alive at the call
+	env value.Value
+}
+
+// funcValueType returns the type of function values, which is a pair of a
+// function taking the environment as the first parameter and the environment.
+func funcValueType(ret types.Type, params ...types.Type) *types.StructType {
+	fn := types.NewFunc(ret, append([]types.Type{types.I8Ptr}, params...)...)
+	return types.NewStruct(types.NewPointer(fn), types.I8Ptr)
+}
+
+// funcSig returns the signature of the function type typ without the environment.
+func funcSig(typ types.Type) (*types.FuncType, bool) {
+	structTyp, ok := typ.(*types.StructType)
+	if !ok || structTyp.Name() != "" || len(structTyp.Fields) != 2 || !structTyp.Fields[1].Equal(types.I8Ptr) {
+		return nil, false
+	}
+
+	ptrTyp, ok := structTyp.Fields[0].(*types.PointerType)
+	if !ok {
+		return nil, false
+	}
+
+	fn, ok := ptrTyp.ElemType.(*types.FuncType)
+	if !ok || len(fn.Params) == 0 || !fn.Params[0].Equal(types.I8Ptr) {
+		return nil, false
+	}
+
+	return types.NewFunc(fn.RetType, fn.Params[1:]...), true
+}
+
+// showType shows typ in diagnostics. A function value is shown by its
+// signature like 'fun(int): int', rather than as the pair holding it.
+func showType(typ types.Type) string {
+	sig, ok := funcSig(typ)
+	if !ok {
+		return fmt.Sprint(typ)
+	}
+
+	var params []string
+	for _, p := range sig.Params {
+		params = append(params, sourceTypeName(p))
+	}
+	s := fmt.Sprintf("fun(%s)", strings.Join(params, ", "))
+	if !sig.RetType.Equal(types.Void) {
+		s += ": " + sourceTypeName(sig.RetType)
+	}
+	return s
+}
+
+// sourceTypeName returns the name typ is written as in the source.
+func sourceTypeName(typ types.Type) string {
+	if _, ok := funcSig(typ); ok {
+		return showType(typ)
+	}
+
+	switch typ := typ.(type) {
+	case *types.IntType:
+		switch typ.BitSize {
+		case 1:
+			return "bool"
+		case 32:
+			return "int"
+		}
+		return fmt.Sprintf("int%d", typ.BitSize)
+	case *types.FloatType:
+		if typ.Kind == types.FloatKindDouble {
+			return "float64"
+		}
+		return "float"
+	case *types.PointerType:
+		return "*" + sourceTypeName(typ.ElemType)
+	case *types.ArrayType:
+		return fmt.Sprintf("[%d]%s", typ.Len, sourceTypeName(typ.ElemType))
+	}
+	return typeName(typ)
+}
+
+// findFunctionValue returns the function named by expr as a value.
+func (c *CodeGen) findFunctionValue(expr *ast.Identifier) (value.Value, bool) {
+	if _, ok := c.context.findGenericFunction(expr.Name); ok {
+		errors.ErrorExit(fmt.Sprintf("%s | cannot use generic function '%s' as a value", expr.Pos, expr.Name))
+	}
+
+	fs := c.context.findFunctions(expr.Name)
+	if len(fs) == 0 {
+		return nil, false
+	}
+	if len(fs) != 1 {
+		errors.ErrorExit(fmt.Sprintf("%s | cannot use overloaded function '%s' as a value", expr.Pos, expr.Name))
+	}
+	f := fs[0]
+
+	for _, isReference := range f.IsReference {
+		if isReference {
+			errors.ErrorExit(fmt.Sprintf("%s | cannot use function '%s' with ref parameters as a value", expr.Pos, expr.Name))
+		}
+	}
+
+	if f.Func.Sig.Variadic || f.IsVariadic {
+		errors.ErrorExit(fmt.Sprintf("%s | cannot use variadic function '%s' as a value", expr.Pos, expr.Name))
+	}
+
+	typ := funcValueType(f.Func.Sig.RetType, f.Func.Sig.Params...)
+	return constant.NewStruct(typ, c.functionThunk(f.Func), constant.NewNull(types.I8Ptr)), true
+}
+
+// functionThunk returns a function that ignores the environment and calls f.
+func (c *CodeGen) functionThunk(f *ir.Func) *ir.Func {
+	name := f.Name() + ".thunk"
+	if thunk, ok := c.runtime.helpers[name]; ok {
+		return thunk
+	}
+
+	params := []*ir.Param{ir.NewParam("env", types.I8Ptr)}
+	for _, p := range f.Sig.Params {
+		params = append(params, ir.NewParam("", p))
+	}
+
+	thunk := c.module.NewFunc(name, f.Sig.RetType, params...)
+	c.runtime.helpers[name] = thunk
+
+	block := thunk.NewBlock("entry")
+	var args []value.Value
+	for _, p := range params[1:] {
+		args = append(args, p)
+	}
+
+	ret := block.NewCall(f, args...)
+	if f.Sig.RetType.Equal(types.Void) {
+		block.NewRet(nil)
+	} else {
+		block.NewRet(ret)
+	}
+
+	return thunk
+}
+
+// genFunctionValue evaluates the callee of expr if it is a value of function
+// type, that is, an expression or a variable rather than a function name.
+func (c *CodeGen) genFunctionValue(expr *ast.CallExpression) (*callee, bool) {
+	ctx := c.context
+	if expr.Function != nil && expr.Function.IsGlobal {
+		ctx = ctx.root()
+	}
+
+	var v Value
+	if expr.Callee != nil {
+		v = c.genExpression(expr.Callee)
+	} else if found, ok := ctx.findVariable(expr.Function.Name); ok && !expr.IsMethod {
+		if _, ok := funcSig(variableType(found)); !ok {
+			// a variable does not hide the function of the same name
+			return nil, false
+		}
+		found, _ = c.findName(expr.Function)
+		v = found.Dereference(c.contextBlock)
+	} else {
+		return nil, false
+	}
+
+	if _, ok := funcSig(valueType(v)); !ok {
+		errors.ErrorExit(fmt.Sprintf("%s | cannot call non-function '%s'", expr.LParen, ast.Show(expr.Callee)))
+	}
+
+	c.checkPositional(expr)
+	return c.closureCallee(expr, v, c.genArgs(expr)), true
+}
+
+// genFieldCallee calls the member of function type of the receiver, like
+// 's.op(1, 2)', if no method or function of the name is found.
+func (c *CodeGen) genFieldCallee(expr *ast.CallExpression, args []Value) (*callee, bool) {
+	if !expr.IsMethod || len(args) == 0 {
+		return nil, false
+	}
+
+	s, _, ok := c.receiverStruct(args[0])
+	if !ok || s.findMember(expr.Function.Name) == -1 {
+		return nil, false
+	}
+
+	member := &ast.LoadMemberExpression{
+		Left:        expr.Args[0],
+		Period:      expr.Function.Pos,
+		MemberIdent: expr.Function,
+	}
+	v := c.genMember(member, args[0])
+	if _, ok := funcSig(valueType(v)); !ok {
+		return nil, false
+	}
+
+	call := *expr
+	call.Callee = member
+	call.Args = expr.Args[1:]
+	call.IsMethod = false
+	c.checkPositional(&call)
+	return c.closureCallee(&call, v, args[1:]), true
+}
+
+// closureCallee calls the function value v with the evaluated arguments args.
+func (c *CodeGen) closureCallee(expr *ast.CallExpression, v Value, args []Value) *callee {
+	sig, _ := funcSig(valueType(v))
+	closure := v.Load(c.contextBlock)
+	fn := c.contextBlock.NewExtractValue(closure, 0)
+	env := c.contextBlock.NewExtractValue(closure, 1)
+
+	return &callee{
+		fn:   fn,
+		args: append([]value.Value{env}, c.genCallArgs(expr, sig, nil, nil, args)...),
+		env:  env,
+	}
+}
+
+// calleeName returns the name of the function called by expr for error messages.
+func calleeName(expr *ast.CallExpression) string {
+	if expr.Callee != nil {
+		return ast.Show(expr.Callee)
+	}
+	return expr.Function.Name
+}
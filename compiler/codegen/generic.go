@@ -0,0 +1,274 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/errors"
+	"github.com/arata-nvm/visket/compiler/token"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+)
+
+// GenericFunc is a function with type parameters. It is instantiated for
+// each combination of type arguments it is called with.
+type GenericFunc struct {
+	Stmt       *ast.FunctionStatement
+	ModuleName string
+	Instances  map[string]*Func
+}
+
+// GenericStruct is a struct with type parameters. Its instances are
+// registered as ordinary structs named like 'Pair<i32,float>'.
+type GenericStruct struct {
+	Stmt *ast.StructStatement
+}
+
+func (c *CodeGen) declareGenericFunction(name string, stmt *ast.FunctionStatement) {
+	c.checkTypeParams(stmt.TypeParams)
+
+	c.context.addGenericFunction(name, &GenericFunc{
+		Stmt:       stmt,
+		ModuleName: c.contextModuleName,
+		Instances:  make(map[string]*Func),
+	})
+}
+
+func (c *CodeGen) declareGenericStruct(stmt *ast.StructStatement) {
+	c.checkTypeParams(stmt.TypeParams)
+
+	if stmt.IsIncomplete {
+		errors.ErrorExit(fmt.Sprintf("%s | generic struct '%s' must have a body", stmt.Struct, stmt.Ident.Name))
+	}
+
+	c.context.addGenericStruct(stmt.Ident.Name, &GenericStruct{Stmt: stmt})
+}
+
+func (c *CodeGen) checkTypeParams(params []*ast.Identifier) {
+	seen := make(map[string]bool)
+	for _, p := range params {
+		if seen[p.Name] {
+			errors.ErrorExit(fmt.Sprintf("%s | duplicate type parameter '%s'", p.Pos, p.Name))
+		}
+		seen[p.Name] = true
+	}
+}
+
+// mangleTypeArgs returns the suffix of the name of an instance, like '<i32,float>'.
+func mangleTypeArgs(args []types.Type) string {
+	var b bytes.Buffer
+	b.WriteString("<")
+	for i, arg := range args {
+		if i != 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(typeName(arg))
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+// instanceContext returns a context where the type parameters are bound to args.
+func (c *CodeGen) instanceContext(params []*ast.Identifier, args []types.Type) *Context {
+	ctx := newContext(c.context.root())
+	for i, p := range params {
+		ctx.addType(p.Name, args[i])
+	}
+	return ctx
+}
+
+func (c *CodeGen) instantiateFunction(g *GenericFunc, args []types.Type, pos token.Position) *Func {
+	suffix := mangleTypeArgs(args)
+	if f, ok := g.Instances[suffix]; ok {
+		return f
+	}
+
+	ctx := c.instanceContext(g.Stmt.TypeParams, args)
+	instance := fmt.Sprintf("%s | in instantiation of '%s%s'", pos, g.Stmt.Ident.Name, suffix)
+
+	var f *Func
+	c.withInstance(ctx, g.ModuleName, suffix, instance, func() {
+		f = c.genFunctionDeclaration(g.Stmt)
+	})
+	g.Instances[suffix] = f
+
+	c.pendingBodies = append(c.pendingBodies, func() {
+		c.withInstance(ctx, g.ModuleName, suffix, instance, func() {
+			c.genFunctionBody(g.Stmt)
+		})
+	})
+
+	return f
+}
+
+// genericStructType returns the instance of the generic struct t refers to.
+func (c *CodeGen) genericStructType(t *ast.Type) types.Type {
+	g, ok := c.context.findGenericStruct(t.Name)
+	if !ok {
+		errors.ErrorExit(fmt.Sprintf("%s | '%s' is not a generic type", t.NamePos, t.Name))
+	}
+
+	if len(t.Args) != len(g.Stmt.TypeParams) {
+		errors.ErrorExit(fmt.Sprintf("%s | wrong number of type arguments for '%s'", t.NamePos, t.Name))
+	}
+
+	var args []types.Type
+	for _, arg := range t.Args {
+		args = append(args, c.llvmType(arg))
+	}
+
+	return c.instantiateStruct(g, args, t.NamePos).Type
+}
+
+func (c *CodeGen) instantiateStruct(g *GenericStruct, args []types.Type, pos token.Position) *Struct {
+	name := g.Stmt.Ident.Name + mangleTypeArgs(args)
+
+	root := c.context.root()
+	if s, ok := root.findStruct(name); ok {
+		return s
+	}
+
+	s := &Struct{
+		Name:     name,
+		Type:     types.NewStruct(),
+		Generic:  g.Stmt.Ident.Name,
+		TypeArgs: args,
+	}
+	c.module.NewTypeDef(s.Name, s.Type)
+	root.addStruct(s.Name, s)
+
+	ctx := c.instanceContext(g.Stmt.TypeParams, args)
+	instance := fmt.Sprintf("%s | in instantiation of '%s'", pos, name)
+
+	c.withInstance(ctx, "", "", instance, func() {
+		c.genStructMembers(s, g.Stmt)
+		c.genStructFunctions(s, g.Stmt)
+	})
+
+	if len(g.Stmt.Functions) != 0 {
+		c.pendingBodies = append(c.pendingBodies, func() {
+			c.withInstance(ctx, "", "", instance, func() {
+				c.genStructFunctionBodies(s.Name, g.Stmt)
+			})
+		})
+	}
+
+	return s
+}
+
+// withInstance runs f in the context of an instance of a generic function or struct.
+func (c *CodeGen) withInstance(ctx *Context, moduleName, suffix, instance string, f func()) {
+	tmpContext := c.context
+	tmpModName := c.contextModuleName
+	tmpInstance := c.contextInstance
+
+	c.context = ctx
+	c.contextModuleName = moduleName
+	c.contextInstance = suffix
+	errors.PushContext(instance)
+
+	f()
+
+	errors.PopContext()
+	c.context = tmpContext
+	c.contextModuleName = tmpModName
+	c.contextInstance = tmpInstance
+}
+
+// inferTypeArgs infers the type arguments of a call to g from the arguments.
+func (c *CodeGen) inferTypeArgs(g *GenericFunc, expr *ast.CallExpression, args []Value) []types.Type {
+	bindings := make(map[string]types.Type)
+	for _, p := range g.Stmt.TypeParams {
+		bindings[p.Name] = nil
+	}
+
+	for i, p := range g.Stmt.Sig.Params {
+		if i >= len(args) {
+			break
+		}
+
+		if p.IsVariadic {
+			// every trailing argument is an element of the variadic parameter
+			for j := i; j < len(args); j++ {
+				c.inferArg(p.Type, args[j], expr.Args[j], bindings, expr.LParen)
+			}
+			break
+		}
+
+		c.inferArg(p.Type, args[i], expr.Args[i], bindings, expr.LParen)
+	}
+
+	var typeArgs []types.Type
+	for _, p := range g.Stmt.TypeParams {
+		typ := bindings[p.Name]
+		if typ == nil {
+			errors.ErrorExit(fmt.Sprintf("%s | cannot infer type parameter '%s' in call to '%s'", expr.LParen, p.Name, calleeName(expr)))
+		}
+		typeArgs = append(typeArgs, typ)
+	}
+
+	return typeArgs
+}
+
+// inferArg binds the type parameters in t from the argument arg.
+func (c *CodeGen) inferArg(t *ast.Type, arg Value, expr ast.Expression, bindings map[string]types.Type, pos token.Position) {
+	if _, ok := arg.Value.(*constant.Null); ok {
+		// nil has no type to infer from
+		return
+	}
+
+	typ := valueType(arg)
+	if _, ok := expr.(*ast.SpreadArgument); ok {
+		elem, ok := c.spreadElem(typ)
+		if !ok {
+			return
+		}
+		typ = elem
+	}
+	c.unify(t, typ, bindings, pos)
+}
+
+// unify binds the type parameters in t so that t matches typ.
+func (c *CodeGen) unify(t *ast.Type, typ types.Type, bindings map[string]types.Type, pos token.Position) {
+	switch {
+	case t.IsPointer:
+		if ptrTyp, ok := typ.(*types.PointerType); ok {
+			c.unify(t.Elem, ptrTyp.ElemType, bindings, pos)
+		}
+	case t.IsArray:
+		if arrTyp, ok := typ.(*types.ArrayType); ok {
+			c.unify(t.Elem, arrTyp.ElemType, bindings, pos)
+		}
+	case t.IsFunc:
+		funcTyp, ok := funcSig(typ)
+		if !ok || len(funcTyp.Params) != len(t.Params) {
+			return
+		}
+		for i, p := range t.Params {
+			c.unify(p, funcTyp.Params[i], bindings, pos)
+		}
+		c.unify(t.RetType, funcTyp.RetType, bindings, pos)
+	case len(t.Args) != 0:
+		structTyp, ok := typ.(*types.StructType)
+		if !ok {
+			return
+		}
+		s, ok := c.context.findStruct(structTyp.Name())
+		if !ok || s.Generic != t.Name || len(s.TypeArgs) != len(t.Args) {
+			return
+		}
+		for i, arg := range t.Args {
+			c.unify(arg, s.TypeArgs[i], bindings, pos)
+		}
+	default:
+		bound, ok := bindings[t.Name]
+		if !ok {
+			return
+		}
+		if bound == nil {
+			bindings[t.Name] = typ
+		} else if !bound.Equal(typ) {
+			errors.ErrorExit(fmt.Sprintf("%s | type mismatch for '%s': '%s' and '%s'", pos, t.Name, bound, typ))
+		}
+	}
+}
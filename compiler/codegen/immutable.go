@@ -0,0 +1,142 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/errors"
+	"github.com/arata-nvm/visket/compiler/token"
+	"github.com/llir/llvm/ir/types"
+)
+
+// Binding is the declaration of a constant by 'val' or an 'in' parameter. The
+// members, elements and pointees reached from it cannot be modified either,
+// unlike the other constants such as the variables copied into closures.
+//
+// A pointer read from a constant keeps its pointee read-only, so neither its
+// address can be taken nor the pointer can be copied into a variable, an
+// argument passed by value or a return value, where the pointee could be
+// modified. It can be bound by 'val' or passed to an 'in' parameter instead.
+// If expressions and block expressions yielding such a pointer keep it
+// read-only, but the values returned by calls are not tracked.
+type Binding struct {
+	Name string
+	Pos  token.Position
+}
+
+// newBinding returns the binding of ident declared at pos, or nil unless it is
+// a constant.
+func newBinding(isConstant bool, ident *ast.Identifier, pos token.Position) *Binding {
+	if !isConstant {
+		return nil
+	}
+	return &Binding{Name: ident.Name, Pos: pos}
+}
+
+// reachedFrom makes v constant if it is reached from the constant base.
+func reachedFrom(v Value, base Value) Value {
+	if base.IsConstant && base.Binding != nil {
+		v.IsConstant = true
+		v.Binding = base.Binding
+	}
+	return v
+}
+
+// checkAssignable reports an error if the variable v of expr is reached from a
+// constant.
+func (c *CodeGen) checkAssignable(v Value, expr ast.Expression, pos token.Position) {
+	if !v.IsConstant {
+		return
+	}
+
+	b := v.Binding
+	if b == nil {
+		errors.ErrorExit(fmt.Sprintf("%s | constant '%s' cannot be reassigned", pos, ast.Show(expr)))
+	}
+
+	if ident, ok := expr.(*ast.Identifier); ok && ident.Name == b.Name {
+		c.constantError(b, fmt.Sprintf("%s | constant '%s' cannot be reassigned", pos, b.Name))
+	}
+	c.constantError(b, fmt.Sprintf("%s | cannot modify '%s' of constant '%s'", pos, showPlace(expr), b.Name))
+}
+
+// checkAddressable reports an error if the variable v of expr is reached from
+// a constant, which could be modified through its address.
+func (c *CodeGen) checkAddressable(v Value, expr ast.Expression, pos token.Position) {
+	if !v.IsConstant {
+		return
+	}
+
+	b := v.Binding
+	if b == nil {
+		errors.ErrorExit(fmt.Sprintf("%s | cannot take the address of constant '%s'", pos, ast.Show(expr)))
+	}
+
+	if ident, ok := expr.(*ast.Identifier); ok && ident.Name == b.Name {
+		c.constantError(b, fmt.Sprintf("%s | cannot take the address of constant '%s'", pos, b.Name))
+	}
+	c.constantError(b, fmt.Sprintf("%s | cannot take the address of '%s' of constant '%s'", pos, showPlace(expr), b.Name))
+}
+
+// checkCopyable reports an error if v of expr holds a pointer read from a
+// constant, whose pointee could be modified through the copy.
+func (c *CodeGen) checkCopyable(v Value, expr ast.Expression, pos token.Position) {
+	b := v.Binding
+	if !v.IsConstant || b == nil || !c.holdsPointer(valueType(v)) {
+		return
+	}
+
+	switch expr := expr.(type) {
+	case *ast.Identifier:
+		if expr.Name == b.Name {
+			c.constantError(b, fmt.Sprintf("%s | cannot copy the pointer of constant '%s'", pos, b.Name))
+		}
+	case *ast.IfExpression:
+		c.constantError(b, fmt.Sprintf("%s | cannot copy the pointer of constant '%s' yielded by if expression", pos, b.Name))
+	case *ast.BlockExpression:
+		c.constantError(b, fmt.Sprintf("%s | cannot copy the pointer of constant '%s' yielded by block expression", pos, b.Name))
+	}
+	c.constantError(b, fmt.Sprintf("%s | cannot copy the pointer '%s' of constant '%s'", pos, showPlace(expr), b.Name))
+}
+
+// holdsPointer reports whether values of typ hold pointers to the data that
+// can be modified, directly or in their members or elements. Strings, slices,
+// interfaces and function values are not counted.
+func (c *CodeGen) holdsPointer(typ types.Type) bool {
+	switch typ := typ.(type) {
+	case *types.PointerType:
+		return true
+	case *types.ArrayType:
+		return c.holdsPointer(typ.ElemType)
+	case *types.StructType:
+		s, ok := c.context.root().findStruct(typ.Name())
+		if !ok || s.Slice != nil {
+			return false
+		}
+		for _, field := range typ.Fields {
+			if c.holdsPointer(field) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// constantError reports msg with the declaration of the constant b.
+func (c *CodeGen) constantError(b *Binding, msg string) {
+	errors.ErrorExit(fmt.Sprintf("%s\n%s | '%s' is declared as a constant here", msg, b.Pos, b.Name))
+}
+
+// showPlace shows a member, element or pointee like 'p.name' or 'xs[0]'.
+func showPlace(expr ast.Expression) string {
+	switch expr := expr.(type) {
+	case *ast.LoadMemberExpression:
+		return showPlace(expr.Left) + "." + expr.MemberIdent.Name
+	case *ast.IndexExpression:
+		return fmt.Sprintf("%s[%s]", showPlace(expr.Left), ast.Show(expr.Index))
+	case *ast.PrefixExpression:
+		if expr.Op == "*" {
+			return "*" + showPlace(expr.Right)
+		}
+	}
+	return ast.Show(expr)
+}
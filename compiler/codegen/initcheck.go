@@ -0,0 +1,256 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/codegen/builtin"
+	"github.com/arata-nvm/visket/compiler/errors"
+	"github.com/arata-nvm/visket/compiler/token"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// initState holds the local variables that may not be assigned yet at the
+// current point of a function, and those that may be moved out. It follows the
+// control flow as the code is generated, so that reads of such variables can
+// be reported.
+type initState struct {
+	vars  map[value.Value]*ast.VarStatement
+	moved map[value.Value]move
+	// unreachable is set after return, where every variable counts as assigned
+	unreachable bool
+}
+
+// move is where the variable name is moved out.
+type move struct {
+	name string
+	pos  token.Position
+}
+
+func newInitState() *initState {
+	return &initState{
+		vars:  make(map[value.Value]*ast.VarStatement),
+		moved: make(map[value.Value]move),
+	}
+}
+
+func (s *initState) clone() *initState {
+	n := newInitState()
+	for v, stmt := range s.vars {
+		n.vars[v] = stmt
+	}
+	for v, m := range s.moved {
+		n.moved[v] = m
+	}
+	n.unreachable = s.unreachable
+	return n
+}
+
+// join merges the state of another path into s. A variable may be unassigned
+// if it is on either path.
+func (s *initState) join(other *initState) {
+	if other.unreachable {
+		return
+	}
+	if s.unreachable {
+		*s = *other.clone()
+		return
+	}
+	for v, stmt := range other.vars {
+		s.vars[v] = stmt
+	}
+	for v, m := range other.moved {
+		s.moved[v] = m
+	}
+}
+
+// declareUnassigned records the variable v declared by stmt without a value.
+// Structs and arrays are left zero-initialized without '-strict-init', since
+// they are often built member by member or used as zero values.
+func (c *CodeGen) declareUnassigned(stmt *ast.VarStatement, v value.Value, typ types.Type) {
+	if stmt.Value != nil || c.contextInit.unreachable {
+		return
+	}
+	if isAggregate(typ) && !c.options.StrictInit {
+		return
+	}
+
+	c.contextInit.vars[v] = stmt
+}
+
+// isAggregate reports whether typ is a struct or an array, whose members and
+// elements can be assigned one by one.
+func isAggregate(typ types.Type) bool {
+	switch typ.(type) {
+	case *types.ArrayType:
+		return true
+	case *types.StructType:
+		_, isFunc := funcSig(typ)
+		return !typ.Equal(builtin.STRING) && !isFunc
+	}
+	return false
+}
+
+// aggregateRoot returns the variable of a struct or an array whose member or
+// element is expr, like 'p' of 'p.pos.x' or 'xs[i]'.
+func (c *CodeGen) aggregateRoot(expr ast.Expression) (*ast.Identifier, Value, bool) {
+	for {
+		switch e := expr.(type) {
+		case *ast.LoadMemberExpression:
+			expr = e.Left
+			continue
+		case *ast.IndexExpression:
+			expr = e.Left
+			continue
+		}
+		break
+	}
+
+	ident, ok := expr.(*ast.Identifier)
+	if !ok {
+		return nil, Value{}, false
+	}
+
+	ctx := c.context
+	if ident.IsGlobal {
+		ctx = ctx.root()
+	}
+	v, ok := ctx.findVariable(ident.Name)
+	if !ok || v.IsReference || !isAggregate(variableType(v)) {
+		return nil, Value{}, false
+	}
+	return ident, v, true
+}
+
+// markAssignedPlace records that the variable whose member or element is expr
+// is assigned. A struct or an array counts as assigned once any of its members
+// or elements is assigned, but the rest of it is gone if it is moved out.
+func (c *CodeGen) markAssignedPlace(expr ast.Expression) {
+	if ident, v, ok := c.aggregateRoot(expr); ok {
+		c.checkMoved(ident, v)
+		c.markAssigned(v)
+	}
+}
+
+// checkAssignedPlace reports a read of expr if the variable whose member or
+// element is expr may be unassigned.
+func (c *CodeGen) checkAssignedPlace(expr ast.Expression) {
+	if ident, v, ok := c.aggregateRoot(expr); ok {
+		c.checkAssigned(ident, v)
+	}
+}
+
+// markAssigned records that the variable v is assigned.
+func (c *CodeGen) markAssigned(v Value) {
+	delete(c.contextInit.vars, v.Value)
+	delete(c.contextInit.moved, v.Value)
+}
+
+// markMoved records that the variable v is moved out by expr.
+func (c *CodeGen) markMoved(v Value, expr ast.Expression, pos token.Position) {
+	if c.contextInit != nil && !c.contextInit.unreachable {
+		c.contextInit.moved[v.Value] = move{name: ast.Show(expr), pos: pos}
+	}
+}
+
+// checkMoved reports a use of the variable v by expr if it may be moved out.
+func (c *CodeGen) checkMoved(expr *ast.Identifier, v Value) {
+	m, ok := c.contextInit.moved[v.Value]
+	if !ok || c.contextInit.unreachable {
+		return
+	}
+	errors.ErrorExit(fmt.Sprintf("%s | '%s' may be used after it is moved\n%s | '%s' is moved here", expr.Pos, expr.Name, m.pos, expr.Name))
+}
+
+// checkAssigned reports a read of the variable v by expr if it may be
+// unassigned. The read is an error with '-strict-init', and otherwise the
+// variable is zero-initialized.
+func (c *CodeGen) checkAssigned(expr *ast.Identifier, v Value) {
+	if expr != c.placeRoot {
+		c.checkMoved(expr, v)
+	}
+
+	stmt, ok := c.contextInit.vars[v.Value]
+	if !ok || c.contextInit.unreachable || c.initReported[expr] || expr == c.placeRoot {
+		return
+	}
+	c.initReported[expr] = true
+
+	msg := fmt.Sprintf("%s | '%s' may be used before it is assigned\n%s | '%s' is declared here without a value", expr.Pos, expr.Name, stmt.Var, stmt.Ident.Name)
+	if c.options.StrictInit {
+		errors.ErrorExit(msg)
+	}
+	errors.Warning(msg)
+}
+
+// checkArg checks the argument arg of a call, which is evaluated by genArgs. A
+// variable passed by ref counts as assigned by the callee.
+func (c *CodeGen) checkArg(arg ast.Expression, v Value, isReference bool) {
+	ident, ok := arg.(*ast.Identifier)
+	if !ok {
+		if isReference {
+			c.markAssignedPlace(arg)
+		} else {
+			c.checkAssignedPlace(arg)
+		}
+		return
+	}
+
+	if isReference {
+		c.checkMoved(ident, v)
+		c.markAssigned(v)
+	} else {
+		c.checkAssigned(ident, v)
+	}
+}
+
+// genMaybe generates the code that may not run, like the body of a loop, by
+// gen. The variables assigned in it are still unassigned after it, and those
+// moved out in it may be moved out after it.
+func (c *CodeGen) genMaybe(gen func()) {
+	entry := c.contextInit.clone()
+	gen()
+	if !c.contextInit.unreachable {
+		for v, m := range c.contextInit.moved {
+			entry.moved[v] = m
+		}
+	}
+	c.contextInit = entry
+}
+
+// checkMovedInLoop reports a variable declared outside a loop, which the body
+// of the loop moves out without assigning it again. The next iteration would
+// use it. moved holds the variables moved out before the body.
+func (c *CodeGen) checkMovedInLoop(moved map[value.Value]move) {
+	if c.contextInit.unreachable {
+		return
+	}
+
+	var first *move
+	for v, m := range c.contextInit.moved {
+		m := m
+		if _, ok := moved[v]; ok || !c.context.parent.declares(v) {
+			continue
+		}
+		if first == nil || m.pos.Line < first.pos.Line {
+			first = &m
+		}
+	}
+	if first != nil {
+		errors.ErrorExit(fmt.Sprintf("%s | '%s' is moved out in the loop without being assigned again", first.pos, first.name))
+	}
+}
+
+// genPlace generates expr, which may be assigned to. A variable, or the struct
+// or the array whose member or element is expr, is not checked to be assigned,
+// unlike other expressions.
+func (c *CodeGen) genPlace(expr ast.Expression) Value {
+	if ident, ok := expr.(*ast.Identifier); ok {
+		return c.genVariable(ident)
+	}
+
+	saved := c.placeRoot
+	c.placeRoot, _, _ = c.aggregateRoot(expr)
+	defer func() { c.placeRoot = saved }()
+	return c.genExpression(expr)
+}
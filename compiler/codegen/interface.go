@@ -0,0 +1,303 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/codegen/internal"
+	"github.com/arata-nvm/visket/compiler/errors"
+	"github.com/arata-nvm/visket/compiler/token"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Interface is lowered to a pair of a pointer to the data and a pointer to
+// the vtable of its struct, both kept as i8*. The data is a heap object, which
+// ARC counts like a pointer.
+type Interface struct {
+	Name    string
+	Type    *types.StructType
+	Methods []*Method
+	VTable  *types.StructType
+
+	// vtables of the structs converted to the interface
+	vtables map[string]*ir.Global
+}
+
+// Method is a method signature of an interface without the receiver.
+type Method struct {
+	Name        string
+	Params      []types.Type
+	IsReference []bool
+	IsReadOnly  []bool
+	RetType     types.Type
+}
+
+func (i *Interface) findMethod(name string) (int, *Method) {
+	for id, m := range i.Methods {
+		if m.Name == name {
+			return id, m
+		}
+	}
+
+	return -1, nil
+}
+
+// funcType returns the type of the functions in the vtable, which take the
+// data pointer in place of the receiver.
+func (m *Method) funcType() *types.FuncType {
+	params := []types.Type{types.I8Ptr}
+	params = append(params, m.Params...)
+	return types.NewFunc(m.RetType, params...)
+}
+
+func (c *CodeGen) genInterfaceDeclaration(stmt *ast.InterfaceStatement) {
+	i := &Interface{
+		Name:    stmt.Ident.Name,
+		Type:    types.NewStruct(types.I8Ptr, types.I8Ptr),
+		VTable:  types.NewStruct(),
+		vtables: make(map[string]*ir.Global),
+	}
+
+	c.module.NewTypeDef(i.Name, i.Type)
+	c.module.NewTypeDef(i.Name+".vtable", i.VTable)
+	c.context.addInterface(i.Name, i)
+}
+
+func (c *CodeGen) genInterfaceMethods(stmt *ast.InterfaceStatement) {
+	i, _ := c.context.findInterface(stmt.Ident.Name)
+
+	for _, f := range stmt.Methods {
+		if len(f.TypeParams) != 0 {
+			errors.ErrorExit(fmt.Sprintf("%s | interface method '%s' cannot have type parameters", f.Func, f.Ident.Name))
+		}
+		if _, m := i.findMethod(f.Ident.Name); m != nil {
+			errors.ErrorExit(fmt.Sprintf("%s | already declared method '%s' in interface '%s'", f.Func, f.Ident.Name, i.Name))
+		}
+
+		m := &Method{
+			Name:    f.Ident.Name,
+			RetType: c.llvmType(f.Sig.RetType),
+		}
+		for _, p := range f.Sig.Params {
+			if p.Default != nil {
+				errors.ErrorExit(fmt.Sprintf("%s | interface method '%s' cannot have default value for '%s'", f.Func, f.Ident.Name, p.Ident.Name))
+			}
+			if p.IsVariadic {
+				errors.ErrorExit(fmt.Sprintf("%s | interface method '%s' cannot have variadic parameter '%s'", f.Func, f.Ident.Name, p.Ident.Name))
+			}
+			typ := c.llvmType(p.Type)
+			if p.IsReference {
+				typ = types.NewPointer(typ)
+			}
+			m.Params = append(m.Params, typ)
+			m.IsReference = append(m.IsReference, p.IsReference)
+			m.IsReadOnly = append(m.IsReadOnly, p.IsReadOnly)
+		}
+
+		i.Methods = append(i.Methods, m)
+		i.VTable.Fields = append(i.VTable.Fields, types.NewPointer(m.funcType()))
+	}
+}
+
+// findInterfaceType returns the interface typ refers to.
+func (c *CodeGen) findInterfaceType(typ types.Type) (*Interface, bool) {
+	structTyp, ok := typ.(*types.StructType)
+	if !ok || structTyp.Name() == "" {
+		return nil, false
+	}
+
+	i, ok := c.context.findInterface(structTyp.Name())
+	if !ok || i.Type != structTyp {
+		return nil, false
+	}
+
+	return i, true
+}
+
+// convertInterface converts a struct, or a pointer to it, into an interface
+// value referring to it. With ARC, a struct is copied into a box on the heap,
+// so that the interface value can outlive the struct. Otherwise the interface
+// refers to the struct like '&' does, or to a copy on the stack if it is not a
+// variable. It reports false if v cannot be converted.
+func (c *CodeGen) convertInterface(v Value, i *Interface, pos token.Position) (value.Value, bool) {
+	if _, ok := v.Value.(*constant.Null); ok {
+		return constant.NewZeroInitializer(i.Type), true
+	}
+
+	typ := v.Value.Type()
+	if v.IsVariable {
+		typ = internal.PtrElmType(v.Value)
+	}
+
+	isBoxed := false
+	switch t := typ.(type) {
+	case *types.PointerType:
+		if _, ok := t.ElemType.(*types.StructType); !ok {
+			return nil, false
+		}
+		typ = t.ElemType
+	case *types.StructType:
+		isBoxed = c.options.ARC
+	default:
+		return nil, false
+	}
+
+	s, ok := c.context.findStruct(typ.Name())
+	if !ok || s.Type != typ {
+		return nil, false
+	}
+
+	var data value.Value
+	switch {
+	case isBoxed:
+		data = c.genAlloc(typ, pos)
+		c.genInit(data, v.Load(c.contextBlock))
+	case typ == valueType(v) && v.IsVariable:
+		data = v.Value
+	case typ == valueType(v):
+		data = c.contextEntryBlock.NewAlloca(typ)
+		c.contextBlock.NewStore(v.Value, data)
+	default:
+		data = v.Load(c.contextBlock)
+	}
+
+	vtable := c.genVTable(i, s, pos)
+
+	var val value.Value = constant.NewUndef(i.Type)
+	val = c.contextBlock.NewInsertValue(val, c.contextBlock.NewBitCast(data, types.I8Ptr), 0)
+	val = c.contextBlock.NewInsertValue(val, constant.NewBitCast(vtable, types.I8Ptr), 1)
+
+	// the box is owned by the expression
+	if isBoxed {
+		c.genTemporary(val)
+	}
+	return val, true
+}
+
+// genVTable returns the vtable of s as an implementation of i.
+func (c *CodeGen) genVTable(i *Interface, s *Struct, pos token.Position) *ir.Global {
+	if vtable, ok := i.vtables[s.Name]; ok {
+		return vtable
+	}
+
+	var thunks []constant.Constant
+	for _, m := range i.Methods {
+		thunks = append(thunks, c.genThunk(i, s, m, pos))
+	}
+
+	vtable := c.module.NewGlobalDef(fmt.Sprintf("%s.%s.vtable", i.Name, s.Name), constant.NewStruct(i.VTable, thunks...))
+	vtable.Immutable = true
+	i.vtables[s.Name] = vtable
+	return vtable
+}
+
+// genThunk returns a function that calls the implementation of m for s with
+// the data pointer cast back to s.
+func (c *CodeGen) genThunk(i *Interface, s *Struct, m *Method, pos token.Position) *ir.Func {
+	impl := c.findImplementation(i, s, m, pos)
+
+	self := ir.NewParam("self", types.I8Ptr)
+	params := []*ir.Param{self}
+	for _, p := range m.Params {
+		params = append(params, ir.NewParam("", p))
+	}
+
+	thunk := c.module.NewFunc(fmt.Sprintf("%s.%s.%s", i.Name, s.Name, m.Name), m.RetType, params...)
+	block := thunk.NewBlock("entry")
+
+	var receiver value.Value = block.NewBitCast(self, types.NewPointer(s.Type))
+	if !impl.IsReference[0] && impl.Func.Sig.Params[0].Equal(s.Type) {
+		receiver = block.NewLoad(s.Type, receiver)
+	}
+
+	args := []value.Value{receiver}
+	for _, p := range params[1:] {
+		args = append(args, p)
+	}
+
+	ret := block.NewCall(impl.Func, args...)
+	if m.RetType.Equal(types.Void) {
+		block.NewRet(nil)
+	} else {
+		block.NewRet(ret)
+	}
+
+	return thunk
+}
+
+// findImplementation finds the method or the function implementing m for s.
+// It takes s, 'ref s' or a pointer to s as the first parameter.
+func (c *CodeGen) findImplementation(i *Interface, s *Struct, m *Method, pos token.Position) *Func {
+	var fs []*Func
+	for _, f := range c.context.findFunctions(fmt.Sprintf("%s::%s", s.Name, m.Name)) {
+		if f.IsMethod {
+			fs = append(fs, f)
+		}
+	}
+	if len(fs) == 0 {
+		fs = c.context.findFunctions(m.Name)
+	}
+
+	reason := fmt.Sprintf("missing method '%s'", m.Name)
+	for j, f := range fs {
+		r := c.checkImplementation(s, m, f)
+		if r == "" {
+			return f
+		}
+		if j == 0 {
+			reason = r
+		}
+	}
+
+	errors.ErrorExit(fmt.Sprintf("%s | '%s' does not implement '%s' (%s)", pos, s.Name, i.Name, reason))
+	return nil // unreachable
+}
+
+// checkImplementation returns the reason why f does not implement m for s, or
+// an empty string if it does.
+func (c *CodeGen) checkImplementation(s *Struct, m *Method, f *Func) string {
+	sig := f.Func.Sig
+	if len(sig.Params) == 0 {
+		return fmt.Sprintf("missing method '%s'", m.Name)
+	}
+
+	receiver := sig.Params[0]
+	byValue := !f.IsReference[0] && receiver.Equal(s.Type)
+	if !byValue && !receiver.Equal(types.NewPointer(s.Type)) {
+		return fmt.Sprintf("missing method '%s'", m.Name)
+	}
+
+	// the data may live on the stack, which must not be counted by ARC
+	if !byValue && !f.IsReference[0] && c.options.ARC {
+		return fmt.Sprintf("method '%s' cannot take a pointer with automatic reference counting", m.Name)
+	}
+
+	matches := !(byValue && c.isDroppable(s.Type)) &&
+		len(sig.Params) == len(m.Params)+1 && !sig.Variadic && sig.RetType.Equal(m.RetType)
+	for j := 0; matches && j < len(m.Params); j++ {
+		matches = sig.Params[j+1].Equal(m.Params[j]) && f.IsReference[j+1] == m.IsReference[j] &&
+			f.IsReadOnly[j+1] == m.IsReadOnly[j]
+	}
+	if !matches {
+		return fmt.Sprintf("wrong type for method '%s'", m.Name)
+	}
+
+	return ""
+}
+
+// genInterfaceCallee returns the function in the vtable of the receiver args[0]
+// that implements the method m, and replaces the receiver with its data pointer.
+func (c *CodeGen) genInterfaceCallee(i *Interface, id int, args []Value) value.Value {
+	receiver := args[0].Load(c.contextBlock)
+	data := c.contextBlock.NewExtractValue(receiver, 0)
+	vtable := c.contextBlock.NewBitCast(c.contextBlock.NewExtractValue(receiver, 1), types.NewPointer(i.VTable))
+
+	zero := constant.NewInt(types.I32, 0)
+	slot := c.contextBlock.NewGetElementPtr(i.VTable, vtable, zero, constant.NewInt(types.I32, int64(id)))
+	fn := c.contextBlock.NewLoad(i.VTable.Fields[id], slot)
+
+	args[0] = Value{Value: data}
+	return fn
+}
@@ -0,0 +1,13 @@
+package internal
+
+import (
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+)
+
+// SizeOf returns the allocation size of t as an i64 constant expression.
+func SizeOf(t types.Type) constant.Constant {
+	null := constant.NewNull(types.NewPointer(t))
+	end := constant.NewGetElementPtr(t, null, constant.NewInt(types.I32, 1))
+	return constant.NewPtrToInt(end, types.I64)
+}
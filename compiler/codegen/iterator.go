@@ -0,0 +1,188 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/codegen/builtin"
+	"github.com/arata-nvm/visket/compiler/codegen/internal"
+	"github.com/arata-nvm/visket/compiler/errors"
+	"github.com/arata-nvm/visket/compiler/token"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// genForInStatement generates 'for i, x in xs { ... }'. xs is copied into a
+// hidden variable, and x is bound to a copy of each element of it. i counts
+// the iterations from 0.
+func (c *CodeGen) genForInStatement(stmt *ast.ForInStatement) {
+	blockLoop := c.contextFunction.NewBlock(internal.NextLabel("forin.loop"))
+	blockExit := c.contextFunction.NewBlock(internal.NextLabel("forin.exit"))
+
+	c.into()
+
+	iter := &ast.Identifier{Pos: stmt.In, Name: internal.NextLabel("for.iter")}
+	c.genVarStatement(&ast.VarStatement{Var: stmt.In, Ident: iter, Value: stmt.Value})
+	c.releaseTemporaries()
+
+	counter := &ast.Identifier{Pos: stmt.In, Name: internal.NextLabel("for.index")}
+	c.genVarStatement(&ast.VarStatement{Var: stmt.In, Ident: counter, Value: &ast.IntegerLiteral{Pos: stmt.In}})
+
+	if !c.genForEach(stmt, iter, counter, blockLoop, blockExit) {
+		c.genForNext(stmt, iter, counter, blockLoop, blockExit)
+	}
+
+	// the copy of xs lives until the end of the loop
+	c.contextBlock = blockExit
+	c.releaseOwned(c.context)
+	c.outOf()
+}
+
+// genForEach generates the loop over the elements of an array, a slice or a
+// string. It reports false if iter is none of them.
+func (c *CodeGen) genForEach(stmt *ast.ForInStatement, iter, counter *ast.Identifier, blockLoop, blockExit *ir.Block) bool {
+	v, _ := c.context.findVariable(iter.Name)
+	typ := valueType(v)
+
+	var length value.Value
+	if arrTyp, ok := typ.(*types.ArrayType); ok {
+		length = constant.NewInt(types.I32, int64(arrTyp.Len))
+	} else if typ.Equal(builtin.STRING) {
+		length = builtin.GetStringLength(v.Value, c.contextBlock)
+	} else if _, ok := c.sliceElem(typ); ok {
+		zero := constant.NewInt(types.I32, 0)
+		lenAddr := c.contextBlock.NewGetElementPtr(typ, v.Value, zero, constant.NewInt(types.I32, 1))
+		length = c.contextBlock.NewLoad(types.I32, lenAddr)
+	} else {
+		return false
+	}
+
+	genCond := func() {
+		index := c.genExpression(counter).Load(c.contextBlock)
+		cond := c.contextBlock.NewICmp(enum.IPredSLT, index, length)
+		c.contextBlock.NewCondBr(cond, blockLoop, blockExit)
+	}
+
+	genCond()
+	c.contextBlock = blockLoop
+	c.genForInBody(stmt, counter, &ast.IndexExpression{
+		Left:   iter,
+		LBrack: stmt.In,
+		Index:  counter,
+		RBrack: stmt.In,
+	})
+	genCond()
+
+	return true
+}
+
+// genForNext generates the loop calling next() of iter until it returns a
+// struct whose 'ok' is false, like Option<T> of std. Otherwise 'value' of the
+// struct is bound to x.
+//
+//	var next = iter.next()
+//	while next.ok {
+//	    var x = next.value
+//	    <body>
+//	    next = iter.next()
+//	}
+func (c *CodeGen) genForNext(stmt *ast.ForInStatement, iter, counter *ast.Identifier, blockLoop, blockExit *ir.Block) {
+	c.checkIterator(stmt, iter)
+
+	next := &ast.Identifier{Pos: stmt.In, Name: internal.NextLabel("for.next")}
+	call := &ast.CallExpression{
+		Function: &ast.Identifier{Pos: stmt.In, Name: "next"},
+		LParen:   stmt.In,
+		Args:     []ast.Expression{iter},
+		RParen:   stmt.In,
+		IsMethod: true,
+	}
+	c.genVarStatement(&ast.VarStatement{Var: stmt.In, Ident: next, Value: call})
+	c.releaseTemporaries()
+	c.checkNext(stmt, next)
+
+	ok := &ast.LoadMemberExpression{
+		Left:        next,
+		Period:      stmt.In,
+		MemberIdent: &ast.Identifier{Pos: stmt.In, Name: "ok"},
+	}
+	c.genIteratorCond(ok, blockLoop, blockExit)
+
+	c.contextBlock = blockLoop
+	c.genForInBody(stmt, counter, &ast.LoadMemberExpression{
+		Left:        next,
+		Period:      stmt.In,
+		MemberIdent: &ast.Identifier{Pos: stmt.In, Name: "value"},
+	})
+
+	c.genExpression(&ast.AssignExpression{Left: next, OpPos: stmt.In, Op: "=", Value: call})
+	c.releaseTemporaries()
+	c.genIteratorCond(ok, blockLoop, blockExit)
+}
+
+func (c *CodeGen) genIteratorCond(ok ast.Expression, blockLoop, blockExit *ir.Block) {
+	cond := c.genExpression(ok).Load(c.contextBlock)
+	c.releaseTemporaries()
+	result := c.contextBlock.NewICmp(enum.IPredNE, cond, constant.False)
+	c.contextBlock.NewCondBr(result, blockLoop, blockExit)
+}
+
+// genForInBody binds the index and elem to the variables of stmt in the scope
+// of the body, and increments counter after the body.
+func (c *CodeGen) genForInBody(stmt *ast.ForInStatement, counter *ast.Identifier, elem ast.Expression) {
+	c.into()
+
+	if stmt.IndexName != nil {
+		c.genLoopVariable(stmt.For, stmt.IndexName, counter)
+	}
+	c.genLoopVariable(stmt.For, stmt.VarName, elem)
+
+	c.genMaybe(func() {
+		c.genLoopBody(stmt.Body)
+		c.releaseOwned(c.context)
+	})
+	c.outOf()
+
+	c.genExpression(&ast.AssignExpression{
+		Left:  counter,
+		OpPos: stmt.In,
+		Op:    "=",
+		Value: &ast.InfixExpression{Left: counter, OpPos: stmt.In, Op: "+", Right: &ast.IntegerLiteral{Pos: stmt.In, Value: 1}},
+	})
+}
+
+func (c *CodeGen) genLoopVariable(pos token.Position, ident *ast.Identifier, val ast.Expression) {
+	c.genVarStatement(&ast.VarStatement{Var: pos, Ident: ident, Value: val})
+	c.releaseTemporaries()
+
+	// loops may declare the same name in a function
+	v, _ := c.context.findVariableCurrent(ident.Name)
+	v.Value.(*ir.InstAlloca).SetName(internal.NextForNum(ident.Name))
+}
+
+// checkIterator reports an error unless the variable iter has next().
+func (c *CodeGen) checkIterator(stmt *ast.ForInStatement, iter *ast.Identifier) {
+	v, _ := c.context.findVariable(iter.Name)
+	s, _, ok := c.receiverStruct(v)
+	if !ok || len(c.context.findFunctions(fmt.Sprintf("%s::next", s.Name))) == 0 {
+		errors.ErrorExit(fmt.Sprintf("%s | cannot iterate over '%s' (missing method 'next')", stmt.In, typeName(valueType(v))))
+	}
+}
+
+// checkNext reports an error unless the variable next holds a struct with
+// 'value' and 'ok: bool'.
+func (c *CodeGen) checkNext(stmt *ast.ForInStatement, next *ast.Identifier) {
+	v, _ := c.context.findVariable(next.Name)
+	typ := valueType(v)
+
+	if s, ok := c.context.findStruct(typ.Name()); ok && s.Type == typ {
+		ok := s.findMember("ok")
+		if s.findMember("value") != -1 && ok != -1 && s.Type.Fields[ok].Equal(types.I1) {
+			return
+		}
+	}
+
+	errors.ErrorExit(fmt.Sprintf("%s | next() must return a struct with 'value' and 'ok: bool' like Option<T>, got '%s'", stmt.In, typeName(typ)))
+}
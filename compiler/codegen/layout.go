@@ -0,0 +1,185 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/errors"
+	"github.com/arata-nvm/visket/compiler/target"
+	"github.com/arata-nvm/visket/compiler/token"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/types"
+)
+
+// dataLayout returns the data layout of the target, which sizeof, alignof and
+// offsetof at pos depend on.
+func (c *CodeGen) dataLayout(pos token.Position) *target.DataLayout {
+	if c.layout != nil {
+		return c.layout
+	}
+
+	triple := c.options.Target
+	if triple == "" {
+		triple = target.Host()
+	}
+	l, ok := target.Layout(triple)
+	if !ok {
+		errors.ErrorExit(fmt.Sprintf("%s | unknown data layout of target '%s'", pos, triple))
+	}
+	c.layout = l
+	return l
+}
+
+// evalLayout evaluates sizeof, alignof and offsetof. The layout follows the
+// data layout of LLVM for the target, which is the same as C structs.
+func (c *CodeGen) evalLayout(expr ast.Expression) (int32, bool) {
+	switch expr := expr.(type) {
+	case *ast.SizeofExpression:
+		return int32(c.sizeOf(c.llvmType(expr.Type), expr.Sizeof)), true
+	case *ast.AlignofExpression:
+		return int32(c.alignOf(c.llvmType(expr.Type), expr.Alignof)), true
+	case *ast.OffsetofExpression:
+		return int32(c.offsetOf(expr)), true
+	}
+	return 0, false
+}
+
+func (c *CodeGen) sizeOf(typ types.Type, pos token.Position) uint64 {
+	switch typ := typ.(type) {
+	case *types.IntType:
+		return alignUp((typ.BitSize+7)/8, c.dataLayout(pos).IntAlign(typ.BitSize))
+	case *types.FloatType:
+		bits := floatBits(typ)
+		return alignUp((bits+7)/8, c.dataLayout(pos).FloatAlign(bits))
+	case *types.PointerType:
+		l := c.dataLayout(pos)
+		return alignUp(l.PointerSize, l.PointerAlign)
+	case *types.ArrayType:
+		return typ.Len * c.sizeOf(typ.ElemType, pos)
+	case *types.StructType:
+		size := uint64(0)
+		for _, field := range c.structFields(typ, pos) {
+			size = c.alignTo(size, field, typ.Packed, pos) + c.sizeOf(field, pos)
+		}
+		return alignUp(size, c.alignOf(typ, pos))
+	}
+
+	errors.ErrorExit(fmt.Sprintf("%s | cannot take the size of '%s'", pos, typeName(typ)))
+	return 0 // unreachable
+}
+
+func (c *CodeGen) alignOf(typ types.Type, pos token.Position) uint64 {
+	switch typ := typ.(type) {
+	case *types.IntType:
+		return c.dataLayout(pos).IntAlign(typ.BitSize)
+	case *types.FloatType:
+		return c.dataLayout(pos).FloatAlign(floatBits(typ))
+	case *types.PointerType:
+		return c.dataLayout(pos).PointerAlign
+	case *types.ArrayType:
+		return c.alignOf(typ.ElemType, pos)
+	case *types.StructType:
+		if typ.Packed {
+			return 1
+		}
+		align := c.dataLayout(pos).AggregateAlign
+		for _, field := range c.structFields(typ, pos) {
+			if a := c.alignOf(field, pos); a > align {
+				align = a
+			}
+		}
+		return align
+	}
+	return c.sizeOf(typ, pos)
+}
+
+func (c *CodeGen) offsetOf(expr *ast.OffsetofExpression) uint64 {
+	typ := c.llvmType(expr.Type)
+	s, ok := c.context.findStruct(typeName(typ))
+	if !ok || !s.Type.Equal(typ) || s.Slice != nil || s.Underlying != nil {
+		errors.ErrorExit(fmt.Sprintf("%s | '%s' is not a struct", expr.Offsetof, ast.Show(expr.Type)))
+	}
+
+	id := s.findMember(expr.Member.Name)
+	if id == -1 {
+		errors.ErrorExit(fmt.Sprintf("%s | unresolved member '%s'", expr.Member.Pos, expr.Member.Name))
+	}
+
+	offset := uint64(0)
+	fields := c.structFields(s.Type, expr.Offsetof)
+	for i := 0; ; i++ {
+		offset = c.alignTo(offset, fields[i], s.Type.Packed, expr.Offsetof)
+		if i == id {
+			return offset
+		}
+		offset += c.sizeOf(fields[i], expr.Offsetof)
+	}
+}
+
+func (c *CodeGen) structFields(typ *types.StructType, pos token.Position) []types.Type {
+	if s, ok := c.context.findStruct(typ.Name()); ok && s.IsIncomplete {
+		errors.ErrorExit(fmt.Sprintf("%s | incomplete type '%s'", pos, s.Name))
+	}
+	return typ.Fields
+}
+
+// alignTo returns the offset of the field of typ placed after offset bytes.
+func (c *CodeGen) alignTo(offset uint64, typ types.Type, packed bool, pos token.Position) uint64 {
+	if packed {
+		return offset
+	}
+	return alignUp(offset, c.alignOf(typ, pos))
+}
+
+func alignUp(n, align uint64) uint64 {
+	return (n + align - 1) / align * align
+}
+
+// floatBits returns the bit width of floating-point numbers of typ.
+func floatBits(typ *types.FloatType) uint64 {
+	switch typ.Kind {
+	case types.FloatKindHalf:
+		return 16
+	case types.FloatKindFloat:
+		return 32
+	case types.FloatKindDouble:
+		return 64
+	case types.FloatKindX86_FP80:
+		return 80
+	}
+	return 128
+}
+
+// typeOf returns the type of expr without generating the code of it. The
+// instructions are generated into a block that is thrown away.
+func (c *CodeGen) typeOf(expr ast.Expression) types.Type {
+	saved := c.saveFunctionState()
+
+	c.into()
+	c.contextFunction = ir.NewFunc("typeof", types.Void)
+	c.contextBlock = c.contextFunction.NewBlock("entry")
+	c.contextEntryBlock = c.contextBlock
+	c.contextCondAfter = nil
+	c.contextTemporaries = nil
+	c.contextDefers = nil
+	c.contextInit = newInitState()
+
+	typ := valueType(c.genExpression(expr))
+
+	c.restoreFunctionState(saved)
+	return typ
+}
+
+func (c *CodeGen) genStaticAssert(stmt *ast.StaticAssertStatement) {
+	v, bad := c.evalConst(stmt.Condition)
+	if bad != nil {
+		errors.ErrorExit(fmt.Sprintf("%s | '%s' is not a constant expression", stmt.StaticAssert, ast.Show(bad)))
+	}
+
+	cond, ok := v.(bool)
+	if !ok {
+		errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", stmt.StaticAssert, constType(v), types.I1))
+	}
+	if !cond {
+		errors.ErrorExit(fmt.Sprintf("%s | static assertion failed: %s", stmt.StaticAssert, stmt.Message.Value))
+	}
+}
@@ -0,0 +1,70 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/errors"
+	"github.com/llir/llvm/ir/types"
+)
+
+// findMethod finds the method of the struct args[0] called by expr. A pointer
+// to the struct is dereferenced, so that it can be passed as the receiver.
+func (c *CodeGen) findMethod(expr *ast.CallExpression, args []Value) (*Func, bool) {
+	s, isPointer, ok := c.receiverStruct(args[0])
+	if !ok {
+		return nil, false
+	}
+
+	fs := c.context.findFunctions(fmt.Sprintf("%s::%s", s.Name, expr.Function.Name))
+	if len(fs) == 0 {
+		return nil, false
+	}
+
+	f := c.resolveOverload(expr, fmt.Sprintf("%s.%s", s.Name, expr.Function.Name), fs, args, 1)
+	if !f.IsMethod {
+		errors.ErrorExit(fmt.Sprintf("%s | '%s::%s' cannot be called as a method", expr.LParen, s.Name, expr.Function.Name))
+	}
+
+	c.genReceiver(f, args, isPointer)
+	return f, true
+}
+
+// receiverStruct returns the struct v is or points to.
+func (c *CodeGen) receiverStruct(v Value) (s *Struct, isPointer bool, ok bool) {
+	typ := valueType(v)
+	ptrTyp, isPointer := typ.(*types.PointerType)
+	if isPointer {
+		typ = ptrTyp.ElemType
+	}
+
+	s, ok = c.context.findStruct(typ.Name())
+	if !ok || s.Type != typ {
+		return nil, false, false
+	}
+
+	return s, isPointer, true
+}
+
+// genReceiver adapts the receiver args[0] to the self parameter of f. A
+// pointer is dereferenced, and a temporary is stored so that it can be passed
+// by reference.
+func (c *CodeGen) genReceiver(f *Func, args []Value, isPointer bool) {
+	recv := args[0]
+	self := f.Func.Sig.Params[0]
+
+	switch {
+	case isPointer && (f.IsReference[0] || !self.Equal(valueType(recv))):
+		args[0] = reachedFrom(Value{
+			Value:      recv.Load(c.contextBlock),
+			IsVariable: true,
+		}, recv)
+	case f.IsReference[0] && !recv.IsVariable:
+		tmp := c.contextEntryBlock.NewAlloca(recv.Value.Type())
+		c.contextBlock.NewStore(recv.Value, tmp)
+		args[0] = Value{
+			Value:      tmp,
+			IsVariable: true,
+			DropFlag:   recv.DropFlag,
+		}
+	}
+}
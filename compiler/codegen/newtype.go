@@ -0,0 +1,125 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/errors"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// genTypeDeclaration registers a distinct type so that the other declarations
+// can refer to it. Aliases are resolved later by genTypeBody.
+func (c *CodeGen) genTypeDeclaration(stmt *ast.TypeStatement) {
+	if _, ok := c.context.findType(stmt.Ident.Name); ok {
+		errors.ErrorExit(fmt.Sprintf("%s | already declared type '%s'", stmt.Ident.Pos, stmt.Ident.Name))
+	}
+
+	if stmt.IsAlias {
+		return
+	}
+
+	// a distinct type wraps the underlying value in a struct, so that it is
+	// not equal to any other type
+	s := &Struct{
+		Name: stmt.Ident.Name,
+		Type: types.NewStruct(),
+	}
+	c.module.NewTypeDef(s.Name, s.Type)
+	c.context.addStruct(s.Name, s)
+}
+
+func (c *CodeGen) genTypeBody(stmt *ast.TypeStatement) {
+	typ := c.llvmType(stmt.Target)
+
+	if stmt.IsAlias {
+		if _, ok := c.context.findType(stmt.Ident.Name); ok {
+			errors.ErrorExit(fmt.Sprintf("%s | already declared type '%s'", stmt.Ident.Pos, stmt.Ident.Name))
+		}
+		c.context.addType(stmt.Ident.Name, typ)
+		return
+	}
+
+	s, _ := c.context.findStruct(stmt.Ident.Name)
+	if typ.Equal(s.Type) {
+		errors.ErrorExit(fmt.Sprintf("%s | invalid recursive type '%s'", stmt.Ident.Pos, stmt.Ident.Name))
+	}
+	s.Type.Fields = []types.Type{typ}
+	s.Underlying = typ
+}
+
+// findNewtype returns the distinct type typ if typ is declared like 'type UserId int'.
+func (c *CodeGen) findNewtype(typ types.Type) (*Struct, bool) {
+	if _, ok := typ.(*types.StructType); !ok {
+		return nil, false
+	}
+
+	s, ok := c.context.findStruct(typ.Name())
+	if !ok || s.Underlying == nil {
+		return nil, false
+	}
+	return s, true
+}
+
+// genConversion converts the argument of expr like 'UserId(1)' into the type
+// named by the callee. Distinct types are converted from and into their
+// underlying types.
+func (c *CodeGen) genConversion(expr *ast.CallExpression) (Value, bool) {
+	if expr.IsMethod || expr.Callee != nil {
+		return Value{}, false
+	}
+
+	name := expr.Function.Name
+	typ, ok := c.context.findType(name)
+	if !ok || len(c.context.findFunctions(name)) != 0 {
+		return Value{}, false
+	}
+	if _, ok := c.context.findGenericFunction(name); ok {
+		return Value{}, false
+	}
+	if _, ok := c.context.findVariable(name); ok {
+		return Value{}, false
+	}
+
+	if len(expr.Args) != 1 {
+		errors.ErrorExit(fmt.Sprintf("%s | conversion to '%s' takes exactly one argument", expr.LParen, name))
+	}
+	c.checkPositional(expr)
+
+	arg := c.genExpression(expr.Args[0])
+	var val value.Value
+	if valueType(arg).Equal(typ) {
+		val = arg.Load(c.contextBlock)
+	} else if _, ok := c.findNewtype(valueType(arg)); ok {
+		val = c.contextBlock.NewExtractValue(arg.Load(c.contextBlock), 0)
+	} else {
+		val = c.convertValue(arg, typ, expr.LParen)
+	}
+
+	if s, ok := c.findNewtype(typ); ok && !val.Type().Equal(typ) {
+		val = c.convertValue(Value{Value: val}, s.Underlying, expr.LParen)
+		if val.Type().Equal(s.Underlying) {
+			val = c.newtypeValue(s, val)
+		}
+	}
+
+	if !val.Type().Equal(typ) {
+		errors.ErrorExit(fmt.Sprintf("%s | cannot convert '%s' to '%s'", expr.LParen, typeName(valueType(arg)), name))
+	}
+
+	// the converted value takes over the ownership of the argument
+	return Value{Value: val, DropFlag: arg.DropFlag}, true
+}
+
+func (c *CodeGen) newtypeValue(s *Struct, val value.Value) value.Value {
+	return c.contextBlock.NewInsertValue(constant.NewUndef(s.Type), val, 0)
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
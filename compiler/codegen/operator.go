@@ -0,0 +1,166 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/errors"
+	"github.com/arata-nvm/visket/compiler/token"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"sort"
+	"strings"
+)
+
+// declareOperator registers the operator function f declared by stmt to its struct.
+func (c *CodeGen) declareOperator(stmt *ast.FunctionStatement, f *Func) {
+	s, _ := c.context.findStruct(stmt.Receiver.Name)
+	op := strings.TrimPrefix(stmt.Ident.Name, "operator")
+
+	if !f.IsMethod || len(f.Func.Params) != 2 {
+		errors.ErrorExit(fmt.Sprintf("%s | '%s' must take self and one parameter", stmt.Func, stmt.Ident.Name))
+	}
+
+	if (op == "==" || op == "<") && !f.Func.Sig.RetType.Equal(types.I1) {
+		errors.ErrorExit(fmt.Sprintf("%s | '%s' must return bool", stmt.Func, stmt.Ident.Name))
+	}
+
+	if s.Operators == nil {
+		s.Operators = make(map[string][]*Func)
+	}
+	s.Operators[op] = append(s.Operators[op], f)
+}
+
+// genOperator calls the operator function of the struct on the left for ie.
+// '!=', '>', '<=' and '>=' are derived from '==' and '<'. It reports false if
+// the operands are not structs with such operators.
+func (c *CodeGen) genOperator(ie *ast.InfixExpression, left, right Value) (Value, bool) {
+	op := ie.Op
+	operands := []Value{left, right}
+	exprs := []ast.Expression{ie.Left, ie.Right}
+	negate := false
+
+	switch op {
+	case "+", "-", "*", "/", "%", "==", "<":
+	case "!=":
+		op, negate = "==", true
+	case ">":
+		op = "<"
+	case "<=":
+		op, negate = "<", true
+	case ">=":
+		op, negate = "<", true
+	default:
+		return Value{}, false
+	}
+
+	if ie.Op == ">" || ie.Op == "<=" {
+		operands[0], operands[1] = operands[1], operands[0]
+		exprs[0], exprs[1] = exprs[1], exprs[0]
+	}
+
+	s, isPointer, ok := c.receiverStruct(operands[0])
+	if !ok || isPointer {
+		return Value{}, false
+	}
+	if len(s.Operators[op]) == 0 {
+		// distinct types take the operators of the underlying type
+		if _, ok := c.findNewtype(s.Type); ok {
+			return Value{}, false
+		}
+		c.noOperatorError(s, ie.Op, ie.OpPos)
+	}
+
+	f := c.findOperator(s, op, operands[1], ie.OpPos)
+	ret := c.genOperatorCall(f, op, ie.OpPos, exprs, operands, false)
+	if negate {
+		return Value{Value: c.contextBlock.NewXor(ret, constant.True)}, true
+	}
+
+	return c.genTemporary(ret), true
+}
+
+// genIndexOperator calls 'operator[]' of the struct left refers to. The element
+// is assignable if the operator returns a pointer to it.
+func (c *CodeGen) genIndexOperator(expr *ast.IndexExpression, left Value) (Value, bool) {
+	s, isPointer, ok := c.receiverStruct(left)
+	if !ok || len(s.Operators["[]"]) == 0 {
+		return Value{}, false
+	}
+
+	index := c.genExpression(expr.Index)
+	f := c.findOperator(s, "[]", index, expr.LBrack)
+	exprs := []ast.Expression{expr.Left, expr.Index}
+
+	ret := c.genOperatorCall(f, "[]", expr.LBrack, exprs, []Value{left, index}, isPointer)
+	if _, ok := ret.Type().(*types.PointerType); ok {
+		return Value{Value: ret, IsVariable: true}, true
+	}
+
+	return c.genTemporary(ret), true
+}
+
+func (c *CodeGen) genOperatorCall(f *Func, op string, pos token.Position, exprs []ast.Expression, operands []Value, isPointer bool) value.Value {
+	call := &ast.CallExpression{
+		Function: &ast.Identifier{Pos: pos, Name: "operator" + op},
+		LParen:   pos,
+		Args:     exprs,
+		RParen:   pos,
+		IsMethod: true,
+	}
+	c.genReceiver(f, operands, isPointer)
+
+	params := c.genCallArgs(call, f.Func.Sig, f.IsReference, f.IsReadOnly, operands)
+	return c.contextBlock.NewCall(f.Func, params...)
+}
+
+// findOperator finds the operator function of s whose parameter accepts arg.
+func (c *CodeGen) findOperator(s *Struct, op string, arg Value, pos token.Position) *Func {
+	typ := valueType(arg)
+	_, isNil := arg.Value.(*constant.Null)
+
+	var candidates []string
+	for _, f := range s.Operators[op] {
+		param := f.Func.Sig.Params[1]
+		if f.IsReference[1] {
+			param = param.(*types.PointerType).ElemType
+		}
+
+		if param.Equal(typ) {
+			return f
+		}
+		if _, ok := param.(*types.PointerType); ok && isNil {
+			return f
+		}
+
+		candidates = append(candidates, fmt.Sprintf("'%s'", c.operatorSignature(s, op, f)))
+	}
+
+	errors.ErrorExit(fmt.Sprintf("%s | no matching operator for '%s %s %s' (candidates: %s)", pos, s.Name, op, typeName(typ), strings.Join(candidates, ", ")))
+	return nil // unreachable
+}
+
+// noOperatorError reports that s has no operator op, with the operators it has.
+func (c *CodeGen) noOperatorError(s *Struct, op string, pos token.Position) {
+	var ops []string
+	for o := range s.Operators {
+		ops = append(ops, o)
+	}
+	sort.Strings(ops)
+
+	var defined []string
+	for _, o := range ops {
+		for _, f := range s.Operators[o] {
+			defined = append(defined, fmt.Sprintf("'%s'", c.operatorSignature(s, o, f)))
+		}
+	}
+	if len(defined) == 0 {
+		errors.ErrorExit(fmt.Sprintf("%s | no operator '%s' for '%s' ('%s' defines no operators)", pos, op, s.Name, s.Name))
+	}
+	errors.ErrorExit(fmt.Sprintf("%s | no operator '%s' for '%s' (defined: %s)", pos, op, s.Name, strings.Join(defined, ", ")))
+}
+
+// operatorSignature returns the signature of f like 'Complex.operator+(Complex): Complex'.
+func (c *CodeGen) operatorSignature(s *Struct, op string, f *Func) string {
+	return functionSignature(fmt.Sprintf("%s.operator%s", s.Name, op), f, 1)
+}
@@ -0,0 +1,263 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/codegen/internal"
+	"github.com/arata-nvm/visket/compiler/errors"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"strings"
+)
+
+// addOverload adds f to the functions already declared as name. Overloaded
+// functions are named after their parameter types, like 'print(i32)'.
+func (c *CodeGen) addOverload(name string, f *Func, overloads []*Func) {
+	if len(overloads) != 0 {
+		pos := f.Stmt.Func
+		for _, g := range append(overloads, f) {
+			if g.Stmt != nil && hasFixedSymbol(g.Stmt.Attributes) {
+				errors.ErrorExit(fmt.Sprintf("%s | cannot overload function '%s' with a fixed symbol name", pos, name))
+			}
+		}
+		for _, g := range overloads {
+			if g.Stmt == nil || g.Stmt.Body == nil || f.Stmt.Body == nil {
+				errors.ErrorExit(fmt.Sprintf("%s | cannot overload external function '%s'", pos, name))
+			}
+			if sameParams(f, g) {
+				errors.ErrorExit(fmt.Sprintf("%s | already declared function '%s'", pos, name))
+			}
+		}
+
+		for _, g := range append(overloads, f) {
+			g.Func.SetName(mangleParams(name, g))
+		}
+	}
+
+	c.context.addFunction(name, f)
+}
+
+func sameParams(f, g *Func) bool {
+	if len(f.Func.Params) != len(g.Func.Params) {
+		return false
+	}
+
+	for i, p := range f.Func.Params {
+		if !p.Typ.Equal(g.Func.Params[i].Typ) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func mangleParams(name string, f *Func) string {
+	var params []string
+	for _, p := range f.Func.Params {
+		params = append(params, typeName(p.Typ))
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(params, ","))
+}
+
+// declaredFunction returns the function declared by stmt as name.
+func (c *CodeGen) declaredFunction(name string, stmt *ast.FunctionStatement) (*Func, bool) {
+	fs := c.context.findFunctions(name)
+	for _, f := range fs {
+		if f.Stmt == stmt {
+			return f, true
+		}
+	}
+
+	// main is declared before its body is found
+	if len(fs) == 1 {
+		return fs[0], true
+	}
+
+	return nil, false
+}
+
+// resolveOverload chooses the function of fs that the arguments args match.
+// Arguments passed without conversion are preferred, and then non-variadic
+// functions. The first skip arguments, like the receiver of methods, are not
+// compared.
+func (c *CodeGen) resolveOverload(expr *ast.CallExpression, name string, fs []*Func, args []Value, skip int) *Func {
+	if len(fs) == 1 {
+		return fs[0]
+	}
+
+	best := -1
+	var found []*Func
+	for _, f := range fs {
+		score := c.matchArgs(f, expr, args, skip)
+		if score < 0 || score < best {
+			continue
+		}
+		if score > best {
+			best = score
+			found = nil
+		}
+		found = append(found, f)
+	}
+
+	if len(found) > 1 {
+		var fixed []*Func
+		for _, f := range found {
+			if !f.IsVariadic {
+				fixed = append(fixed, f)
+			}
+		}
+		if len(fixed) != 0 {
+			found = fixed
+		}
+	}
+
+	if len(found) == 1 {
+		return found[0]
+	}
+
+	var argTypes []string
+	for _, arg := range args {
+		if _, ok := arg.Value.(*constant.Null); ok {
+			argTypes = append(argTypes, "nil")
+			continue
+		}
+		argTypes = append(argTypes, typeName(valueType(arg)))
+	}
+	call := fmt.Sprintf("%s(%s)", name, strings.Join(argTypes, ", "))
+
+	if len(found) == 0 {
+		errors.ErrorExit(fmt.Sprintf("%s | no matching function for '%s' (candidates: %s)", expr.LParen, call, c.candidates(name, fs)))
+	}
+
+	errors.ErrorExit(fmt.Sprintf("%s | ambiguous call to '%s' (candidates: %s)", expr.LParen, call, c.candidates(name, found)))
+	return nil // unreachable
+}
+
+// matchArgs returns the number of arguments of args that match the parameters
+// of f without conversion, or -1 if f cannot take args.
+func (c *CodeGen) matchArgs(f *Func, expr *ast.CallExpression, args []Value, skip int) int {
+	if f.IsVariadic {
+		return c.matchVariadic(f, expr, args, skip)
+	}
+
+	sig := f.Func.Sig
+
+	// the index of the argument for each parameter, -1 for default values
+	var order []int
+	if f.Stmt != nil {
+		var reason string
+		if order, reason = arrangeArgs(expr, f.Stmt.Sig.Params); reason != "" {
+			return -1
+		}
+	} else {
+		if len(args) < len(sig.Params) || !sig.Variadic && len(args) > len(sig.Params) {
+			return -1
+		}
+		for i := range sig.Params {
+			order = append(order, i)
+		}
+	}
+
+	score := 0
+	for i := skip; i < len(sig.Params); i++ {
+		if order[i] == -1 {
+			continue
+		}
+
+		s := c.matchArg(f, i, args[order[i]])
+		if s < 0 {
+			return -1
+		}
+		score += s
+	}
+
+	return score
+}
+
+// matchArg returns 1 if arg matches the i-th parameter of f without conversion,
+// 0 if arg is converted, or -1 if arg cannot be passed.
+func (c *CodeGen) matchArg(f *Func, i int, arg Value) int {
+	param := f.Func.Sig.Params[i]
+	typ := valueType(arg)
+
+	if i < len(f.IsReference) && f.IsReference[i] {
+		elem := internal.PtrElmType(f.Func.Params[i])
+		if i < len(f.IsReadOnly) && f.IsReadOnly[i] {
+			// constants and temporaries are also passed by a read-only reference
+			if elem.Equal(typ) {
+				return 1
+			} else if c.isConvertible(arg, elem) {
+				return 0
+			}
+			return -1
+		}
+		if !arg.IsVariable || arg.IsConstant || !elem.Equal(typ) {
+			return -1
+		}
+		return 1
+	}
+
+	if param.Equal(typ) {
+		return 1
+	} else if !c.isConvertible(arg, param) {
+		return -1
+	}
+	return 0
+}
+
+// isConvertible reports whether convertValue converts v into typ.
+func (c *CodeGen) isConvertible(v Value, typ types.Type) bool {
+	if _, ok := v.Value.(*constant.Null); ok {
+		if _, ok := typ.(*types.PointerType); ok {
+			return true
+		}
+		if _, ok := funcSig(typ); ok {
+			return true
+		}
+		_, ok := c.findInterfaceType(typ)
+		return ok
+	}
+
+	if _, ok := c.findInterfaceType(typ); ok {
+		_, _, ok := c.receiverStruct(v)
+		return ok
+	}
+
+	return false
+}
+
+func (c *CodeGen) candidates(name string, fs []*Func) string {
+	var candidates []string
+	for _, f := range fs {
+		candidates = append(candidates, fmt.Sprintf("'%s'", functionSignature(name, f, 0)))
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// functionSignature returns the signature of f like 'print(i32): void'
+// without the first skip parameters.
+func functionSignature(name string, f *Func, skip int) string {
+	var b bytes.Buffer
+	for i, param := range f.Func.Params[skip:] {
+		if i != 0 {
+			b.WriteString(", ")
+		}
+		typ := param.Typ
+		if i+skip < len(f.IsReadOnly) && f.IsReadOnly[i+skip] {
+			b.WriteString("in ")
+			typ = internal.PtrElmType(param)
+		} else if f.IsReference[i+skip] {
+			b.WriteString("ref ")
+			typ = internal.PtrElmType(param)
+		}
+		if f.IsVariadic && i+skip == len(f.Func.Params)-1 {
+			// slices hold a pointer to the elements
+			b.WriteString("...")
+			typ = typ.(*types.StructType).Fields[0].(*types.PointerType).ElemType
+		}
+		b.WriteString(typeName(typ))
+	}
+
+	return fmt.Sprintf("%s(%s): %s", name, b.String(), typeName(f.Func.Sig.RetType))
+}
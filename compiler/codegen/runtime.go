@@ -0,0 +1,324 @@
+package codegen
+
+import (
+	"github.com/arata-nvm/visket/compiler/codegen/builtin"
+	"github.com/arata-nvm/visket/compiler/codegen/internal"
+	"github.com/arata-nvm/visket/compiler/token"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// runtime holds the functions that generated code calls to manage heap memory.
+//
+// With ARC or MemCheck enabled every heap object is preceded by a header:
+//
+//	{ i64 refcount, void (i8*)* drop, i8* pos, header* prev, header* next }
+//
+// drop releases the references held by the object, pos is the source position
+// of the allocation and prev/next link the live objects for the leak report.
+type runtime struct {
+	alloc    *ir.Func
+	free     *ir.Func
+	retain   *ir.Func
+	release  *ir.Func
+	memcheck *ir.Func
+
+	header  *types.StructType
+	objects *ir.Global
+
+	helpers map[string]*ir.Func
+}
+
+const (
+	headerRefCount = iota
+	headerDrop
+	headerPos
+	headerPrev
+	headerNext
+)
+
+var dropFuncType = types.NewPointer(types.NewFunc(types.Void, types.I8Ptr))
+
+func (c *CodeGen) hasHeader() bool {
+	return c.options.ARC || c.options.MemCheck
+}
+
+func (c *CodeGen) genRuntime() {
+	c.runtime.helpers = make(map[string]*ir.Func)
+
+	if c.hasHeader() {
+		c.runtime.header = types.NewStruct()
+		c.module.NewTypeDef("runtime.header", c.runtime.header)
+		headerPtr := types.NewPointer(c.runtime.header)
+		c.runtime.header.Fields = []types.Type{types.I64, dropFuncType, types.I8Ptr, headerPtr, headerPtr}
+		c.runtime.objects = c.module.NewGlobalDef("runtime.objects", constant.NewNull(headerPtr))
+	}
+
+	c.genRuntimeAlloc()
+	c.genRuntimeFree()
+	c.genRuntimeRetain()
+	c.genRuntimeRelease()
+
+	if c.options.MemCheck {
+		c.genRuntimeMemCheck()
+	}
+}
+
+// headerOf returns the header of the object whose payload starts at ptr.
+func (c *CodeGen) headerOf(block *ir.Block, ptr value.Value) value.Value {
+	offset := constant.NewSub(constant.NewInt(types.I64, 0), internal.SizeOf(c.runtime.header))
+	mem := block.NewGetElementPtr(types.I8, ptr, offset)
+	return block.NewBitCast(mem, types.NewPointer(c.runtime.header))
+}
+
+func (c *CodeGen) headerField(block *ir.Block, header value.Value, field int64) value.Value {
+	zero := constant.NewInt(types.I32, 0)
+	return block.NewGetElementPtr(c.runtime.header, header, zero, constant.NewInt(types.I32, field))
+}
+
+func (c *CodeGen) genRuntimeAlloc() {
+	malloc, _ := c.context.findFunction("malloc")
+	trap := c.module.NewFunc("llvm.trap", types.Void)
+
+	size := ir.NewParam("size", types.I64)
+	drop := ir.NewParam("drop", dropFuncType)
+	pos := ir.NewParam("pos", types.I8Ptr)
+	alloc := c.module.NewFunc("runtime.alloc", types.I8Ptr, size, drop, pos)
+	c.runtime.alloc = alloc
+
+	entry := alloc.NewBlock("entry")
+	blockFail := alloc.NewBlock("fail")
+	blockOk := alloc.NewBlock("ok")
+
+	var total value.Value = size
+	if c.hasHeader() {
+		total = entry.NewAdd(size, internal.SizeOf(c.runtime.header))
+	}
+
+	mem := entry.NewCall(malloc.Func, total)
+	isNull := entry.NewICmp(enum.IPredEQ, mem, constant.NewNull(types.I8Ptr))
+	entry.NewCondBr(isNull, blockFail, blockOk)
+
+	blockFail.NewCall(trap)
+	blockFail.NewUnreachable()
+
+	if !c.hasHeader() {
+		blockOk.NewRet(mem)
+		return
+	}
+
+	header := blockOk.NewBitCast(mem, types.NewPointer(c.runtime.header))
+	blockOk.NewStore(constant.NewInt(types.I64, 1), c.headerField(blockOk, header, headerRefCount))
+	blockOk.NewStore(drop, c.headerField(blockOk, header, headerDrop))
+	blockOk.NewStore(pos, c.headerField(blockOk, header, headerPos))
+
+	block := blockOk
+	if c.options.MemCheck {
+		// 生存しているオブジェクトのリストの先頭に追加する
+		headerPtr := types.NewPointer(c.runtime.header)
+		head := block.NewLoad(headerPtr, c.runtime.objects)
+		block.NewStore(constant.NewNull(headerPtr), c.headerField(block, header, headerPrev))
+		block.NewStore(head, c.headerField(block, header, headerNext))
+
+		blockLink := alloc.NewBlock("link")
+		blockDone := alloc.NewBlock("done")
+		isEmpty := block.NewICmp(enum.IPredEQ, head, constant.NewNull(headerPtr))
+		block.NewCondBr(isEmpty, blockDone, blockLink)
+
+		blockLink.NewStore(header, c.headerField(blockLink, head, headerPrev))
+		blockLink.NewBr(blockDone)
+
+		block = blockDone
+		block.NewStore(header, c.runtime.objects)
+	}
+
+	payload := block.NewGetElementPtr(types.I8, mem, internal.SizeOf(c.runtime.header))
+	block.NewRet(payload)
+}
+
+func (c *CodeGen) genRuntimeFree() {
+	free, _ := c.context.findFunction("free")
+
+	ptr := ir.NewParam("ptr", types.I8Ptr)
+	runtimeFree := c.module.NewFunc("runtime.free", types.Void, ptr)
+	c.runtime.free = runtimeFree
+
+	entry := runtimeFree.NewBlock("entry")
+	if !c.hasHeader() {
+		entry.NewCall(free.Func, ptr)
+		entry.NewRet(nil)
+		return
+	}
+
+	blockFree := runtimeFree.NewBlock("free")
+	blockExit := runtimeFree.NewBlock("exit")
+	isNull := entry.NewICmp(enum.IPredEQ, ptr, constant.NewNull(types.I8Ptr))
+	entry.NewCondBr(isNull, blockExit, blockFree)
+	blockExit.NewRet(nil)
+
+	block := blockFree
+	header := c.headerOf(block, ptr)
+
+	if c.options.MemCheck {
+		// 生存しているオブジェクトのリストから取り除く
+		headerPtr := types.NewPointer(c.runtime.header)
+		null := constant.NewNull(headerPtr)
+		prev := block.NewLoad(headerPtr, c.headerField(block, header, headerPrev))
+		next := block.NewLoad(headerPtr, c.headerField(block, header, headerNext))
+
+		blockHead := runtimeFree.NewBlock("unlink.head")
+		blockPrev := runtimeFree.NewBlock("unlink.prev")
+		blockNext := runtimeFree.NewBlock("unlink.next")
+		blockDone := runtimeFree.NewBlock("unlink.done")
+
+		isHead := block.NewICmp(enum.IPredEQ, prev, null)
+		block.NewCondBr(isHead, blockHead, blockPrev)
+
+		blockHead.NewStore(next, c.runtime.objects)
+		blockHead.NewBr(blockNext)
+
+		blockPrev.NewStore(next, c.headerField(blockPrev, prev, headerNext))
+		blockPrev.NewBr(blockNext)
+
+		isTail := blockNext.NewICmp(enum.IPredEQ, next, null)
+		blockUnlinkNext := runtimeFree.NewBlock("unlink.next.prev")
+		blockNext.NewCondBr(isTail, blockDone, blockUnlinkNext)
+
+		blockUnlinkNext.NewStore(prev, c.headerField(blockUnlinkNext, next, headerPrev))
+		blockUnlinkNext.NewBr(blockDone)
+
+		block = blockDone
+	}
+
+	block.NewCall(free.Func, block.NewBitCast(header, types.I8Ptr))
+	block.NewRet(nil)
+}
+
+func (c *CodeGen) genRuntimeRetain() {
+	ptr := ir.NewParam("ptr", types.I8Ptr)
+	retain := c.module.NewFunc("runtime.retain", types.Void, ptr)
+	c.runtime.retain = retain
+
+	entry := retain.NewBlock("entry")
+	if !c.options.ARC {
+		entry.NewRet(nil)
+		return
+	}
+
+	blockRetain := retain.NewBlock("retain")
+	blockExit := retain.NewBlock("exit")
+	isNull := entry.NewICmp(enum.IPredEQ, ptr, constant.NewNull(types.I8Ptr))
+	entry.NewCondBr(isNull, blockExit, blockRetain)
+	blockExit.NewRet(nil)
+
+	refCount := c.headerField(blockRetain, c.headerOf(blockRetain, ptr), headerRefCount)
+	count := blockRetain.NewLoad(types.I64, refCount)
+	blockRetain.NewStore(blockRetain.NewAdd(count, constant.NewInt(types.I64, 1)), refCount)
+	blockRetain.NewBr(blockExit)
+}
+
+func (c *CodeGen) genRuntimeRelease() {
+	ptr := ir.NewParam("ptr", types.I8Ptr)
+	release := c.module.NewFunc("runtime.release", types.Void, ptr)
+	c.runtime.release = release
+
+	entry := release.NewBlock("entry")
+	if !c.options.ARC {
+		entry.NewRet(nil)
+		return
+	}
+
+	blockRelease := release.NewBlock("release")
+	blockDrop := release.NewBlock("drop")
+	blockCallDrop := release.NewBlock("drop.call")
+	blockFree := release.NewBlock("free")
+	blockExit := release.NewBlock("exit")
+
+	isNull := entry.NewICmp(enum.IPredEQ, ptr, constant.NewNull(types.I8Ptr))
+	entry.NewCondBr(isNull, blockExit, blockRelease)
+	blockExit.NewRet(nil)
+
+	header := c.headerOf(blockRelease, ptr)
+	refCount := c.headerField(blockRelease, header, headerRefCount)
+	count := blockRelease.NewSub(blockRelease.NewLoad(types.I64, refCount), constant.NewInt(types.I64, 1))
+	blockRelease.NewStore(count, refCount)
+	isDead := blockRelease.NewICmp(enum.IPredEQ, count, constant.NewInt(types.I64, 0))
+	blockRelease.NewCondBr(isDead, blockDrop, blockExit)
+
+	drop := blockDrop.NewLoad(dropFuncType, c.headerField(blockDrop, header, headerDrop))
+	hasDrop := blockDrop.NewICmp(enum.IPredNE, drop, constant.NewNull(dropFuncType))
+	blockDrop.NewCondBr(hasDrop, blockCallDrop, blockFree)
+
+	blockCallDrop.NewCall(drop, ptr)
+	blockCallDrop.NewBr(blockFree)
+
+	blockFree.NewCall(c.runtime.free, ptr)
+	blockFree.NewBr(blockExit)
+}
+
+// genRuntimeMemCheck generates the function that reports the objects which
+// are still alive.
+func (c *CodeGen) genRuntimeMemCheck() {
+	dprintf := c.module.NewFunc("dprintf", types.I32, ir.NewParam("", types.I32), ir.NewParam("", types.I8Ptr))
+	dprintf.Sig.Variadic = true
+	stderr := constant.NewInt(types.I32, 2)
+
+	memcheck := c.module.NewFunc("runtime.memcheck", types.Void)
+	c.runtime.memcheck = memcheck
+
+	entry := memcheck.NewBlock("entry")
+	blockLoop := memcheck.NewBlock("loop")
+	blockReport := memcheck.NewBlock("report")
+	blockExit := memcheck.NewBlock("exit")
+	blockSummary := memcheck.NewBlock("summary")
+	blockRet := memcheck.NewBlock("ret")
+
+	headerPtr := types.NewPointer(c.runtime.header)
+	head := entry.NewLoad(headerPtr, c.runtime.objects)
+	entry.NewBr(blockLoop)
+
+	header := blockLoop.NewPhi(ir.NewIncoming(head, entry))
+	count := blockLoop.NewPhi(ir.NewIncoming(constant.NewInt(types.I32, 0), entry))
+	isEnd := blockLoop.NewICmp(enum.IPredEQ, header, constant.NewNull(headerPtr))
+	blockLoop.NewCondBr(isEnd, blockExit, blockReport)
+
+	pos := blockReport.NewLoad(types.I8Ptr, c.headerField(blockReport, header, headerPos))
+	leakMsg := builtin.NewCString("memcheck: leaked object allocated at %s\n", c.module)
+	blockReport.NewCall(dprintf, stderr, leakMsg, pos)
+	next := blockReport.NewLoad(headerPtr, c.headerField(blockReport, header, headerNext))
+	nextCount := blockReport.NewAdd(count, constant.NewInt(types.I32, 1))
+	blockReport.NewBr(blockLoop)
+	header.Incs = append(header.Incs, ir.NewIncoming(next, blockReport))
+	count.Incs = append(count.Incs, ir.NewIncoming(nextCount, blockReport))
+
+	hasLeaks := blockExit.NewICmp(enum.IPredNE, count, constant.NewInt(types.I32, 0))
+	blockExit.NewCondBr(hasLeaks, blockSummary, blockRet)
+
+	summaryMsg := builtin.NewCString("memcheck: %d objects leaked\n", c.module)
+	blockSummary.NewCall(dprintf, stderr, summaryMsg, count)
+	blockSummary.NewBr(blockRet)
+
+	blockRet.NewRet(nil)
+}
+
+// genAlloc allocates zero-initialized memory for a value of typ on the heap.
+func (c *CodeGen) genAlloc(typ types.Type, pos token.Position) value.Value {
+	var posStr constant.Constant = constant.NewNull(types.I8Ptr)
+	if c.options.MemCheck {
+		posStr = builtin.NewCString(pos.String(), c.module)
+	}
+
+	mem := c.contextBlock.NewCall(c.runtime.alloc, internal.SizeOf(typ), c.dropFunc(typ), posStr)
+	ptr := c.contextBlock.NewBitCast(mem, types.NewPointer(typ))
+	c.contextBlock.NewStore(constant.NewZeroInitializer(typ), ptr)
+	return ptr
+}
+
+// genFree releases memory obtained from genAlloc.
+func (c *CodeGen) genFree(ptr value.Value) {
+	mem := c.contextBlock.NewBitCast(ptr, types.I8Ptr)
+	c.contextBlock.NewCall(c.runtime.free, mem)
+}
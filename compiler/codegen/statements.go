@@ -11,6 +11,7 @@ import (
 	"github.com/llir/llvm/ir/enum"
 	"github.com/llir/llvm/ir/types"
 	"github.com/llir/llvm/ir/value"
+	"strings"
 )
 
 func (c *CodeGen) genStatement(stmt ast.Statement) {
@@ -29,9 +30,26 @@ func (c *CodeGen) genStatement(stmt ast.Statement) {
 		c.genForStatement(stmt)
 	case *ast.ForRangeStatement:
 		c.genForRangeStatement(stmt)
+	case *ast.ForInStatement:
+		c.genForInStatement(stmt)
+	case *ast.DeleteStatement:
+		c.genDeleteStatement(stmt)
+	case *ast.DeferStatement:
+		c.genDeferStatement(stmt)
+	case *ast.FunctionStatement:
+		c.genNestedFunction(stmt)
+	case *ast.StaticAssertStatement:
+		c.genStaticAssert(stmt)
+	case *ast.BlockStatement:
+		c.into()
+		c.genBlockStatement(stmt)
+		c.releaseOwned(c.context)
+		c.outOf()
 	default:
 		errors.ErrorExit(fmt.Sprintf("unexpexted statement: %s\n", ast.Show(stmt)))
 	}
+
+	c.releaseTemporaries()
 }
 
 func (c *CodeGen) genModuleDeclaration(stmt *ast.ModuleStatement) {
@@ -64,19 +82,59 @@ func (c *CodeGen) genGlobalVarStatement(stmt *ast.VarStatement) {
 		errors.ErrorExit(fmt.Sprintf("%s | already declared variable '%s'", stmt.Var, stmt.Ident.Name))
 	}
 
-	c.contextBlock = c.initFunc.Blocks[0]
+	if stmt.IsConstant {
+		c.genGlobalConst(stmt)
+		return
+	}
+
+	c.contextFunction = c.initFunc
+	c.contextBlock = c.initFunc.Blocks[len(c.initFunc.Blocks)-1]
+	c.contextEntryBlock = c.initFunc.Blocks[0]
 
-	typ, val := c.checkTypeAndValue(stmt.Type, stmt.Value, stmt.Var)
+	typ, val, _ := c.checkTypeAndValue(stmt.Type, stmt.Value, stmt.Var, false)
 
 	global := c.module.NewGlobalDef(stmt.Ident.Name, constant.NewZeroInitializer(typ))
-	c.contextBlock.NewStore(val, global)
-	c.context.addVariable(global.Name(), Value{
+	c.applyGlobalAttributes(global, stmt)
+	c.genInit(global, val)
+	c.releaseTemporaries()
+	c.own(global)
+	c.ownDrop(global, true)
+	c.context.addVariable(stmt.Ident.Name, Value{
 		Value:      global,
 		IsVariable: true,
-		IsConstant: stmt.IsConstant,
+		Binding:    newBinding(false, stmt.Ident, stmt.Var),
 	})
 
+	if c.contextBlock.Term == nil {
+		c.contextBlock.NewRet(nil)
+	}
+
+	c.contextEntryBlock = nil
 	c.contextBlock = nil
+	c.contextFunction = nil
+}
+
+// genGlobalConst generates a global constant initialized at compile time. The
+// value must be a constant expression of the declared type.
+func (c *CodeGen) genGlobalConst(stmt *ast.VarStatement) {
+	v, bad := c.evalConst(&ast.Identifier{Pos: stmt.Var, Name: stmt.Ident.Name})
+	if bad != nil {
+		errors.ErrorExit(fmt.Sprintf("%s | '%s' is not a constant expression", stmt.Var, ast.Show(bad)))
+	}
+	if stmt.Type != nil && !c.llvmType(stmt.Type).Equal(constType(v)) {
+		errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", stmt.Var, showType(c.llvmType(stmt.Type)), showType(constType(v))))
+	}
+
+	global := c.module.NewGlobalDef(stmt.Ident.Name, c.constValue(v))
+	global.Immutable = true
+	c.applyGlobalAttributes(global, stmt)
+	c.context.addVariable(stmt.Ident.Name, Value{
+		Value:      global,
+		IsVariable: true,
+		IsConstant: true,
+		Binding:    newBinding(true, stmt.Ident, stmt.Var),
+		Const:      v,
+	})
 }
 
 func (c *CodeGen) genVarStatement(stmt *ast.VarStatement) {
@@ -85,91 +143,181 @@ func (c *CodeGen) genVarStatement(stmt *ast.VarStatement) {
 		errors.ErrorExit(fmt.Sprintf("%s | already declared variable '%s'", stmt.Var, stmt.Ident.Name))
 	}
 
-	_, val := c.checkTypeAndValue(stmt.Type, stmt.Value, stmt.Var)
+	_, val, escape := c.checkTypeAndValue(stmt.Type, stmt.Value, stmt.Var, stmt.IsConstant)
 
 	named := c.contextEntryBlock.NewAlloca(val.Type())
 	named.SetName(stmt.Ident.Name)
+	c.genInit(named, val)
+	c.own(named)
+	c.declareUnassigned(stmt, named, val.Type())
 	c.context.addVariable(stmt.Ident.Name, Value{
 		Value:      named,
 		IsVariable: true,
 		IsConstant: stmt.IsConstant,
+		Binding:    newBinding(stmt.IsConstant, stmt.Ident, stmt.Var),
+		DropFlag:   c.ownDrop(named, stmt.Value != nil),
+		Escape:     escape,
+		Const:      c.localConst(stmt, val),
 	})
-	c.contextBlock.NewStore(val, named)
 }
 
-func (c *CodeGen) checkTypeAndValue(typ *ast.Type, val ast.Expression, pos token.Position) (llTyp types.Type, llVal value.Value) {
-	if val != nil {
-		llVal = c.genExpression(val).Load(c.contextBlock)
-		llTyp = llVal.Type()
-	} else {
-		llTyp = c.llvmType(typ)
-		llVal = constant.NewZeroInitializer(llTyp)
+// localConst returns the value of a local constant known at compile time, or nil.
+func (c *CodeGen) localConst(stmt *ast.VarStatement, val value.Value) interface{} {
+	if !stmt.IsConstant {
+		return nil
+	}
+
+	v, bad := c.evalConst(stmt.Value)
+	if bad != nil || !val.Type().Equal(constType(v)) {
+		return nil
 	}
+	return v
+}
 
+func (c *CodeGen) checkTypeAndValue(typ *ast.Type, val ast.Expression, pos token.Position, isConstant bool) (llTyp types.Type, llVal value.Value, escape *Escape) {
 	if typ != nil {
 		llTyp = c.llvmType(typ)
 	}
 
+	if val == nil {
+		llVal = constant.NewZeroInitializer(llTyp)
+	} else if llTyp != nil {
+		v := c.genExpression(val)
+		if !isConstant {
+			c.checkCopyable(v, val, pos)
+		}
+		llVal = c.convertValue(v, llTyp, pos)
+		c.genMove(v, val, pos)
+		escape = v.Escape
+	} else {
+		v := c.genExpression(val)
+		if !isConstant {
+			c.checkCopyable(v, val, pos)
+		}
+		llVal = v.Load(c.contextBlock)
+		llTyp = llVal.Type()
+		c.genMove(v, val, pos)
+		escape = v.Escape
+	}
+
 	if !llTyp.Equal(llVal.Type()) {
-		errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", pos, llTyp, llVal.Type()))
+		errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", pos, showType(llTyp), showType(llVal.Type())))
 	}
 
 	return
 }
 
 func (c *CodeGen) genReturnStatement(stmt *ast.ReturnStatement) {
+	if isNoReturn(c.contextFunction) {
+		errors.ErrorExit(fmt.Sprintf("%s | cannot return from '@noreturn' function", stmt.Return))
+	}
+
 	retType := c.contextFunction.Sig.RetType
 
 	if stmt.Value == nil {
-		if c.contextFunction.Name() == "main" {
-			c.contextBlock.NewRet(constant.NewInt(types.I32, 0))
-			return
-		}
-
-		if retType != types.Void {
-			errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", stmt.Return, retType, types.Void))
+		if c.contextFunction != c.mainFunc && retType != types.Void {
+			errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", stmt.Return, showType(retType), types.Void))
 		}
-		c.contextBlock.NewRet(nil)
+		c.genReturn(nil)
+		c.contextInit.unreachable = true
 		return
 	}
 
-	result := c.genExpression(stmt.Value).Load(c.contextBlock)
+	// a closure returned as is keeps its environment on the heap
+	if lit, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		c.contextReturned = lit
+	}
+	v := c.genExpression(stmt.Value)
+	c.contextReturned = nil
+	if v.Escape != nil {
+		c.escapeError(v.Escape, stmt.Return)
+	}
+	c.checkCopyable(v, stmt.Value, stmt.Return)
+	result := c.convertValue(v, retType, stmt.Return)
+	c.genMove(v, stmt.Value, stmt.Return)
+
+	if !retType.Equal(result.Type()) {
+		errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", stmt.Return, showType(retType), showType(result.Type())))
+	}
 
-	if retType != result.Type() {
-		errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", stmt.Return, retType, result.Type()))
+	// the caller receives an owned reference
+	if c.isManaged(retType) {
+		tmp := c.contextEntryBlock.NewAlloca(retType)
+		c.contextBlock.NewStore(result, tmp)
+		c.genRefCount(c.contextBlock, c.runtime.retain, tmp)
 	}
 
-	c.contextBlock.NewRet(result)
+	c.genReturn(result)
+	c.contextInit.unreachable = true
 }
 
 func (c *CodeGen) moduleFuncName(funcName string) string {
-	if c.contextModuleName == "" {
-		return funcName
+	if c.contextModuleName != "" {
+		funcName = fmt.Sprintf("%s::%s", c.contextModuleName, funcName)
 	}
 
-	return fmt.Sprintf("%s_%s", c.contextModuleName, funcName)
+	// instances of generic functions are named like 'max<i32>'
+	return funcName + c.contextInstance
 }
 
-func (c *CodeGen) genFunctionDeclaration(stmt *ast.FunctionStatement) {
-	funcName := c.moduleFuncName(stmt.Ident.Name)
+// funcName returns the name of the function stmt declares. Methods are named
+// after their struct, like 'Person::rename', which no identifier can be.
+func (c *CodeGen) funcName(stmt *ast.FunctionStatement) string {
+	if stmt.Receiver == nil {
+		return c.moduleFuncName(stmt.Ident.Name)
+	}
+
+	return fmt.Sprintf("%s::%s%s", stmt.Receiver.Name, stmt.Ident.Name, c.contextInstance)
+}
+
+func (c *CodeGen) genFunctionDeclaration(stmt *ast.FunctionStatement) *Func {
+	funcName := c.funcName(stmt)
+
+	if stmt.Receiver != nil {
+		if _, ok := c.context.findStruct(stmt.Receiver.Name); !ok {
+			errors.ErrorExit(fmt.Sprintf("%s | unknown struct '%s'", stmt.Receiver.Pos, stmt.Receiver.Name))
+		}
+	}
+
+	if stmt.IsConst {
+		c.checkConstFunction(stmt)
+	}
+	c.checkFuncAttributes(funcName, stmt)
 
 	if funcName == "main" {
-		return
+		return nil
 	}
 
-	_, ok := c.context.findFunction(funcName)
-	if ok {
+	overloads := c.context.findFunctions(funcName)
+	_, isGeneric := c.context.findGenericFunction(funcName)
+	if isGeneric || len(overloads) != 0 && len(stmt.TypeParams) != 0 {
 		errors.ErrorExit(fmt.Sprintf("%s | already declared function '%s'", stmt.Func, funcName))
 	}
 
+	if len(stmt.TypeParams) != 0 && c.contextInstance == "" {
+		c.declareGenericFunction(funcName, stmt)
+		return nil
+	}
+
 	var params []*ir.Param
 	isReferece := make([]bool, len(stmt.Sig.Params))
+	isReadOnly := make([]bool, len(stmt.Sig.Params))
 
 	for i, p := range stmt.Sig.Params {
 		typ := c.llvmType(p.Type)
 		if p.IsReference {
 			typ = types.NewPointer(typ)
 			isReferece[i] = true
+			isReadOnly[i] = p.IsReadOnly
+		}
+		if p.IsVariadic {
+			if p.IsReference {
+				errors.ErrorExit(fmt.Sprintf("%s | variadic parameter '%s' cannot be ref", p.Ident.Pos, p.Ident.Name))
+			}
+			if p.Default != nil {
+				errors.ErrorExit(fmt.Sprintf("%s | variadic parameter '%s' cannot have default value", p.Ident.Pos, p.Ident.Name))
+			}
+			typ = c.sliceType(typ)
 		}
 		param := ir.NewParam("", typ)
 		params = append(params, param)
@@ -178,16 +326,32 @@ func (c *CodeGen) genFunctionDeclaration(stmt *ast.FunctionStatement) {
 	returnTyp := c.llvmType(stmt.Sig.RetType)
 
 	function := c.module.NewFunc(funcName, returnTyp, params...)
-	c.context.addFunction(funcName, &Func{
+	c.applyFuncAttributes(function, stmt)
+	f := &Func{
 		Func:        function,
 		IsReference: isReferece,
-	})
+		IsReadOnly:  isReadOnly,
+		IsMethod:    stmt.Receiver != nil && len(stmt.Sig.Params) != 0 && stmt.Sig.Params[0].Ident.Name == "self",
+		IsVariadic:  isVariadic(stmt),
+		Stmt:        stmt,
+	}
+	c.addOverload(funcName, f, overloads)
+
+	if stmt.Receiver != nil && strings.HasPrefix(stmt.Ident.Name, "operator") && stmt.Ident.Name != "operator" {
+		c.declareOperator(stmt, f)
+	}
+
+	return f
 }
 
 func (c *CodeGen) genFunctionBody(stmt *ast.FunctionStatement) {
-	funcName := c.moduleFuncName(stmt.Ident.Name)
+	if len(stmt.TypeParams) != 0 && c.contextInstance == "" {
+		return
+	}
 
-	f, ok := c.context.findFunction(funcName)
+	funcName := c.funcName(stmt)
+
+	f, ok := c.declaredFunction(funcName, stmt)
 	if !ok {
 		errors.ErrorExit(fmt.Sprintf("%s | undeclared function '%s'", stmt.Func, funcName))
 	}
@@ -195,6 +359,9 @@ func (c *CodeGen) genFunctionBody(stmt *ast.FunctionStatement) {
 	c.contextFunction = f.Func
 
 	c.into()
+	c.contextFunctionScope = c.context
+	c.contextDefers = nil
+	c.contextInit = newInitState()
 	if funcName == "main" {
 		retTyp := stmt.Sig.RetType.Name
 		if retTyp != "void" {
@@ -211,43 +378,71 @@ func (c *CodeGen) genFunctionBody(stmt *ast.FunctionStatement) {
 	}
 	c.contextEntryBlock = c.contextBlock
 
-	for i, p := range stmt.Sig.Params {
-		typ := f.Func.Params[i].Typ
-		val := c.contextBlock.NewAlloca(typ)
-		val.SetName(p.Ident.Name)
-		c.contextBlock.NewStore(f.Func.Params[i], val)
-		c.context.addVariable(p.Ident.Name, Value{
-			Value:       val,
-			IsVariable:  true,
-			IsReference: p.IsReference,
-		})
-	}
-
+	c.genParams(stmt.Sig.Params, f.Func.Params)
 	c.genBlockStatement(stmt.Body)
+	c.checkNoReturn(f.Func, stmt.Body)
 
-	if f.Func.Sig.RetType == types.Void {
-		c.contextBlock.NewRet(nil)
-	}
-
-	if funcName == "main" {
-		c.contextBlock.NewRet(constant.NewInt(types.I32, 0))
-	}
-
-	if c.contextBlock.Term == nil {
+	if f.Func.Sig.RetType == types.Void || f.Func == c.mainFunc {
+		c.genReturn(nil)
+	} else if c.contextBlock.Term == nil {
 		errors.ErrorExit(fmt.Sprintf("%s | missing return at end of function", stmt.Body.RBrace))
 	}
 
 	c.contextEntryBlock = nil
 	c.contextBlock = nil
 	c.outOf()
+	c.contextFunctionScope = nil
 
 	c.contextFunction = nil
 }
 
+// genParams declares the parameters of the current function as variables.
+func (c *CodeGen) genParams(decls []*ast.Param, params []*ir.Param) {
+	for i, p := range decls {
+		typ := params[i].Typ
+		val := c.contextBlock.NewAlloca(typ)
+		val.SetName(p.Ident.Name)
+		if p.IsReference {
+			c.contextBlock.NewStore(params[i], val)
+			c.context.addVariable(p.Ident.Name, Value{
+				Value:       val,
+				IsVariable:  true,
+				IsReference: true,
+				IsConstant:  p.IsReadOnly,
+				Binding:     newBinding(p.IsReadOnly, p.Ident, p.Ident.Pos),
+			})
+			continue
+		}
+
+		// the caller may pass a closure capturing its variables by reference
+		var escape *Escape
+		if _, ok := funcSig(typ); ok {
+			escape = &Escape{Scope: c.context, Name: p.Ident.Name, IsParam: true}
+		}
+
+		c.genInit(val, params[i])
+		c.own(val)
+		c.context.addVariable(p.Ident.Name, Value{
+			Value:      val,
+			IsVariable: true,
+			IsConstant: p.IsVariadic,
+			DropFlag:   c.ownDrop(val, true),
+			Escape:     escape,
+		})
+	}
+}
+
 func (c *CodeGen) genIfStatement(stmt *ast.IfStatement) {
+	c.genIf(stmt, c.genBlockStatement)
+}
+
+// genIf generates the branches of stmt with genBranch, and returns the blocks
+// the branches end in before they jump to the merge block.
+func (c *CodeGen) genIf(stmt *ast.IfStatement, genBranch func(*ast.BlockStatement)) (endThen *ir.Block, endElse *ir.Block) {
 	hasAlternative := stmt.Alternative != nil
 
 	condition := c.genExpression(stmt.Condition).Load(c.contextBlock)
+	c.releaseTemporaries()
 	blockThen := c.contextFunction.NewBlock(NextLabel("if.then"))
 	var blockElse *ir.Block
 	blockMerge := c.contextFunction.NewBlock(NextLabel("if.merge"))
@@ -260,19 +455,30 @@ func (c *CodeGen) genIfStatement(stmt *ast.IfStatement) {
 		c.contextBlock.NewCondBr(condition, blockThen, blockMerge)
 	}
 
+	entry := c.contextInit.clone()
+
 	c.into()
 	c.contextBlock = blockThen
 	c.contextBlock.NewBr(blockMerge)
-	c.genBlockStatement(stmt.Consequence)
+	genBranch(stmt.Consequence)
+	c.releaseOwned(c.context)
+	c.exitBranch(blockMerge)
+	endThen = c.contextBlock
 	c.outOf()
 
+	initThen := c.contextInit
+	c.contextInit = entry
 	if hasAlternative {
 		c.into()
 		c.contextBlock = blockElse
 		c.contextBlock.NewBr(blockMerge)
-		c.genBlockStatement(stmt.Alternative)
+		genBranch(stmt.Alternative)
+		c.releaseOwned(c.context)
+		c.exitBranch(blockMerge)
+		endElse = c.contextBlock
 		c.outOf()
 	}
+	c.contextInit.join(initThen)
 
 	c.contextBlock = blockMerge
 	c.contextCondAfter = c.contextCondAfter[:len(c.contextCondAfter)-1]
@@ -280,24 +486,178 @@ func (c *CodeGen) genIfStatement(stmt *ast.IfStatement) {
 	if len(c.contextCondAfter) > 0 {
 		c.contextBlock.NewBr(c.contextCondAfter[len(c.contextCondAfter)-1])
 	}
+
+	return endThen, endElse
+}
+
+// exitBranch jumps to the merge block if the branch ended in a block created by
+// a nested loop.
+func (c *CodeGen) exitBranch(blockMerge *ir.Block) {
+	if c.contextBlock.Term == nil {
+		c.contextBlock.NewBr(blockMerge)
+	}
+}
+
+// genIfExpression generates an if statement used as a value like
+// 'if x < 0 { -1 } else { 1 }'. The values of the branches meet at a phi in
+// the merge block.
+func (c *CodeGen) genIfExpression(stmt *ast.IfStatement) Value {
+	if stmt.Alternative == nil {
+		errors.ErrorExit(fmt.Sprintf("%s | if expression must have an else branch", stmt.If))
+	}
+
+	var vals []value.Value
+	var escape *Escape
+	var binding *Binding
+	endThen, endElse := c.genIf(stmt, func(block *ast.BlockStatement) {
+		val, e, b := c.genYield(block)
+		vals = append(vals, val)
+		escape = deeperEscape(escape, e)
+		if binding == nil {
+			binding = b
+		}
+	})
+	blockMerge := c.contextBlock
+
+	// nil takes the type of the other branch
+	if !vals[0].Type().Equal(vals[1].Type()) {
+		c.contextBlock = endElse
+		vals[1] = c.convertValue(Value{Value: vals[1]}, vals[0].Type(), stmt.If)
+		if !vals[0].Type().Equal(vals[1].Type()) {
+			c.contextBlock = endThen
+			vals[0] = c.convertValue(Value{Value: vals[0]}, vals[1].Type(), stmt.If)
+		}
+		c.contextBlock = blockMerge
+	}
+	if !vals[0].Type().Equal(vals[1].Type()) {
+		errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", stmt.If, showType(vals[0].Type()), showType(vals[1].Type())))
+	}
+
+	// a branch ending in a return or a '@noreturn' call does not reach the
+	// merge block
+	var incs []*ir.Incoming
+	for i, end := range []*ir.Block{endThen, endElse} {
+		if branchesTo(end, blockMerge) {
+			incs = append(incs, ir.NewIncoming(vals[i], end))
+		}
+	}
+
+	var val value.Value
+	switch len(incs) {
+	case 0:
+		val = constant.NewUndef(vals[0].Type())
+	case 1:
+		val = incs[0].X
+	default:
+		val = blockMerge.NewPhi(incs...)
+	}
+	v := c.genTemporary(val)
+	v.Escape = escape
+	v.IsConstant = binding != nil
+	v.Binding = binding
+	return v
+}
+
+// branchesTo reports if block jumps to target.
+func branchesTo(block *ir.Block, target *ir.Block) bool {
+	if block.Term == nil {
+		return false
+	}
+	for _, succ := range block.Term.Succs() {
+		if succ == target {
+			return true
+		}
+	}
+	return false
+}
+
+// genBlockExpression generates a block used as a value like
+// '{ val t = f(); t * 2 }'.
+func (c *CodeGen) genBlockExpression(block *ast.BlockStatement) Value {
+	c.into()
+	val, escape, binding := c.genYield(block)
+	c.releaseOwned(c.context)
+	c.outOf()
+
+	v := c.genTemporary(val)
+	v.Escape = escape
+	v.IsConstant = binding != nil
+	v.Binding = binding
+	return v
+}
+
+// genYield generates block and returns the value of the last expression in it,
+// with the constant it holds a pointer of if any. The caller receives an owned
+// reference, since the variables of the block are released before the value is
+// used.
+func (c *CodeGen) genYield(block *ast.BlockStatement) (value.Value, *Escape, *Binding) {
+	n := len(block.Statements)
+	if n == 0 {
+		errors.ErrorExit(fmt.Sprintf("%s | block does not yield a value", block.LBrace))
+	}
+
+	for _, s := range block.Statements[:n-1] {
+		c.genStatement(s)
+	}
+
+	var v Value
+	var expr ast.Expression
+	switch last := block.Statements[n-1].(type) {
+	case *ast.ExpressionStatement:
+		expr = last.Expression
+		v = c.genExpression(expr)
+	case *ast.IfStatement:
+		expr = &ast.IfExpression{Stmt: last}
+		v = c.genIfExpression(last)
+	case *ast.BlockStatement:
+		expr = &ast.BlockExpression{Block: last}
+		v = c.genBlockExpression(last)
+	default:
+		errors.ErrorExit(fmt.Sprintf("%s | block does not yield a value", block.LBrace))
+	}
+
+	val := v.Load(c.contextBlock)
+	if val.Type().Equal(types.Void) {
+		errors.ErrorExit(fmt.Sprintf("%s | block does not yield a value", block.LBrace))
+	}
+	if v.Escape != nil && v.Escape.Scope.within(c.context) {
+		c.escapeError(v.Escape, block.RBrace)
+	}
+	c.genMove(v, expr, block.RBrace)
+
+	if c.isManaged(val.Type()) {
+		tmp := c.contextEntryBlock.NewAlloca(val.Type())
+		c.contextBlock.NewStore(val, tmp)
+		c.genRefCount(c.contextBlock, c.runtime.retain, tmp)
+	}
+
+	// a constant declared in block is gone, so its pointer can be modified
+	var binding *Binding
+	if v.IsConstant && v.Binding != nil && c.holdsPointer(val.Type()) {
+		binding = v.Binding
+		if local, ok := c.context.variables[binding.Name]; ok && local.Binding == binding {
+			binding = nil
+		}
+	}
+
+	return val, v.Escape, binding
 }
 
 func (c *CodeGen) genWhileStatement(stmt *ast.WhileStatement) {
 	blockLoop := c.contextFunction.NewBlock(NextLabel("while.loop"))
 	blockExit := c.contextFunction.NewBlock(NextLabel("while.exit"))
 
-	cond := c.genExpression(stmt.Condition).Load(c.contextBlock)
-	result := c.contextBlock.NewICmp(enum.IPredNE, cond, constant.False)
-	c.contextBlock.NewCondBr(result, blockLoop, blockExit)
+	c.genLoopBranch(stmt.Condition, blockLoop, blockExit)
 
 	c.into()
 	c.contextBlock = blockLoop
 
-	c.genBlockStatement(stmt.Body)
+	c.genMaybe(func() {
+		c.genLoopBody(stmt.Body)
+		c.releaseOwned(c.context)
 
-	cond = c.genExpression(stmt.Condition).Load(c.contextBlock)
-	result = c.contextBlock.NewICmp(enum.IPredNE, cond, constant.False)
-	c.contextBlock.NewCondBr(result, blockLoop, blockExit)
+		c.genLoopBranch(stmt.Condition, blockLoop, blockExit)
+	})
 	c.outOf()
 
 	c.contextBlock = blockExit
@@ -311,77 +671,143 @@ func (c *CodeGen) genForStatement(stmt *ast.ForStatement) {
 		c.genStatement(stmt.Init)
 	}
 
-	if stmt.Condition != nil {
-		cond := c.genExpression(stmt.Condition).Load(c.contextBlock)
-		result := c.contextBlock.NewICmp(enum.IPredNE, cond, constant.False)
-		c.contextBlock.NewCondBr(result, blockLoop, blockExit)
-	} else {
-		c.contextBlock.NewBr(blockLoop)
-	}
+	c.genLoopBranch(stmt.Condition, blockLoop, blockExit)
 
 	c.into()
 	c.contextBlock = blockLoop
 
-	c.genBlockStatement(stmt.Body)
+	c.genMaybe(func() {
+		c.genLoopBody(stmt.Body)
+		c.releaseOwned(c.context)
 
-	if stmt.Post != nil {
-		c.genStatement(stmt.Post)
-	}
+		if stmt.Post != nil {
+			c.genStatement(stmt.Post)
+		}
 
-	if stmt.Condition != nil {
-		cond := c.genExpression(stmt.Condition).Load(c.contextBlock)
-		result := c.contextBlock.NewICmp(enum.IPredNE, cond, constant.False)
-		c.contextBlock.NewCondBr(result, blockLoop, blockExit)
-	} else {
-		c.contextBlock.NewBr(blockLoop)
-	}
+		c.genLoopBranch(stmt.Condition, blockLoop, blockExit)
+	})
 
 	c.outOf()
 
 	c.contextBlock = blockExit
 }
 
+// genLoopBranch branches to blockLoop if cond holds, or to blockExit otherwise.
+// A missing or constantly true cond always continues the loop, so that only
+// break reaches blockExit.
+func (c *CodeGen) genLoopBranch(cond ast.Expression, blockLoop, blockExit *ir.Block) {
+	if cond == nil {
+		c.contextBlock.NewBr(blockLoop)
+		return
+	}
+	if v, bad := c.evalConst(cond); bad == nil && v == true {
+		c.contextBlock.NewBr(blockLoop)
+		return
+	}
+
+	v := c.genExpression(cond).Load(c.contextBlock)
+	c.releaseTemporaries()
+	result := c.contextBlock.NewICmp(enum.IPredNE, v, constant.False)
+	c.contextBlock.NewCondBr(result, blockLoop, blockExit)
+}
+
 // TODO rewrite
+// genForRangeStatement generates 'for i in from..to step s { ... }', which
+// counts down if the step is negative. i is a copy of a hidden counter, so that
+// the body cannot change the iteration.
 func (c *CodeGen) genForRangeStatement(stmt *ast.ForRangeStatement) {
 	blockLoop := c.contextFunction.NewBlock(NextLabel("for.loop"))
 	blockExit := c.contextFunction.NewBlock(NextLabel("for.exit"))
 
-	c.into()
 	from := c.genExpression(stmt.From).Load(c.contextBlock)
 	to := c.genExpression(stmt.To).Load(c.contextBlock)
 
 	if !from.Type().Equal(to.Type()) {
-		errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", stmt.For, from.Type(), to.Type()))
+		errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", stmt.For, showType(from.Type()), showType(to.Type())))
 	}
+	typ, ok := from.Type().(*types.IntType)
+	if !ok || typ.BitSize == 1 {
+		errors.ErrorExit(fmt.Sprintf("%s | range must be integers, got '%s'", stmt.In, typeName(from.Type())))
+	}
+
+	var step value.Value = constant.NewInt(typ, 1)
+	if stmt.Step != nil {
+		step = c.convertValue(c.genExpression(stmt.Step), typ, stmt.In)
+		if !step.Type().Equal(typ) {
+			errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", stmt.In, showType(typ), showType(step.Type())))
+		}
+		if v, bad := c.evalConst(stmt.Step); bad == nil && v == int32(0) {
+			errors.ErrorExit(fmt.Sprintf("%s | step of range cannot be zero", stmt.In))
+		}
+	}
+	c.releaseTemporaries()
+
+	counter := c.contextEntryBlock.NewAlloca(typ)
+	counter.SetName(NextForNum("for.counter"))
+	c.contextBlock.NewStore(from, counter)
+	c.genRangeCond(stmt, counter, to, step, blockLoop, blockExit)
+
+	c.into()
+	c.contextBlock = blockLoop
 
-	typ := from.Type()
 	namedVar := c.contextEntryBlock.NewAlloca(typ)
 	namedVar.SetName(NextForNum(stmt.VarName.Name))
-	c.contextBlock.NewStore(from, namedVar)
-	c.context.addVariable("i", Value{
+	c.contextBlock.NewStore(c.contextBlock.NewLoad(typ, counter), namedVar)
+	c.context.addVariable(stmt.VarName.Name, Value{
 		Value:      namedVar,
 		IsVariable: true,
 	})
 
-	val := c.contextBlock.NewLoad(typ, namedVar)
-	cond := c.contextBlock.NewICmp(enum.IPredULE, val, to)
-	result := c.contextBlock.NewICmp(enum.IPredNE, cond, constant.False)
-	c.contextBlock.NewCondBr(result, blockLoop, blockExit)
+	c.genMaybe(func() {
+		c.genLoopBody(stmt.Body)
+		c.releaseOwned(c.context)
+	})
+	c.outOf()
 
-	c.contextBlock = blockLoop
-	c.genBlockStatement(stmt.Body)
+	c.genRangeStep(stmt, counter, to, step, blockLoop, blockExit)
 
-	val = c.contextBlock.NewLoad(typ, namedVar)
-	nextVal := c.contextBlock.NewAdd(val, constant.NewInt(types.I32, 1))
-	c.contextBlock.NewStore(nextVal, namedVar)
+	c.contextBlock = blockExit
+}
 
-	val = c.contextBlock.NewLoad(typ, namedVar)
-	cond = c.contextBlock.NewICmp(enum.IPredULE, val, to)
-	result = c.contextBlock.NewICmp(enum.IPredNE, cond, constant.False)
-	c.contextBlock.NewCondBr(result, blockLoop, blockExit)
+// genRangeCond branches to blockLoop if counter has not passed to, which is
+// checked before the first iteration.
+func (c *CodeGen) genRangeCond(stmt *ast.ForRangeStatement, counter *ir.InstAlloca, to, step value.Value, blockLoop, blockExit *ir.Block) {
+	up, down := enum.IPredSLE, enum.IPredSGE
+	if stmt.Exclusive {
+		up, down = enum.IPredSLT, enum.IPredSGT
+	}
 
-	c.outOf()
-	c.contextBlock = blockExit
+	val := c.contextBlock.NewLoad(counter.ElemType, counter)
+	var cond value.Value = c.contextBlock.NewICmp(up, val, to)
+	if stmt.Step != nil {
+		isDown := c.contextBlock.NewICmp(enum.IPredSLT, step, constant.NewInt(counter.ElemType.(*types.IntType), 0))
+		cond = c.contextBlock.NewSelect(isDown, c.contextBlock.NewICmp(down, val, to), cond)
+	}
+	c.contextBlock.NewCondBr(cond, blockLoop, blockExit)
+}
+
+// genRangeStep adds step to counter and branches to blockLoop if the counter
+// does not pass to. The distance to to is compared with step before adding it,
+// so that the counter cannot overflow near the bounds of the type.
+func (c *CodeGen) genRangeStep(stmt *ast.ForRangeStatement, counter *ir.InstAlloca, to, step value.Value, blockLoop, blockExit *ir.Block) {
+	typ := counter.ElemType.(*types.IntType)
+	pred := enum.IPredUGE
+	if stmt.Exclusive {
+		pred = enum.IPredUGT
+	}
+
+	// the counter is between from and to, so the distance fits in unsigned
+	val := c.contextBlock.NewLoad(typ, counter)
+	var cond value.Value = c.contextBlock.NewICmp(pred, c.contextBlock.NewSub(to, val), step)
+	if stmt.Step != nil {
+		zero := constant.NewInt(typ, 0)
+		isDown := c.contextBlock.NewICmp(enum.IPredSLT, step, zero)
+		down := c.contextBlock.NewICmp(pred, c.contextBlock.NewSub(val, to), c.contextBlock.NewSub(zero, step))
+		cond = c.contextBlock.NewSelect(isDown, down, cond)
+	}
+
+	c.contextBlock.NewStore(c.contextBlock.NewAdd(val, step), counter)
+	c.contextBlock.NewCondBr(cond, blockLoop, blockExit)
 }
 
 func (c *CodeGen) genBlockStatement(stmt *ast.BlockStatement) {
@@ -390,31 +816,160 @@ func (c *CodeGen) genBlockStatement(stmt *ast.BlockStatement) {
 	}
 }
 
-func (c *CodeGen) genStructStatement(stmt *ast.StructStatement) {
+// genStructDeclaration registers the struct type so that members of any struct
+// can refer to it, including through pointers to itself.
+func (c *CodeGen) genStructDeclaration(stmt *ast.StructStatement) {
+	if len(stmt.TypeParams) != 0 {
+		c.declareGenericStruct(stmt)
+		return
+	}
+
 	s := &Struct{
 		Name:         stmt.Ident.Name,
+		Type:         types.NewStruct(),
 		IsIncomplete: stmt.IsIncomplete,
 	}
+	s.Type.Opaque = stmt.IsIncomplete
 
-	if stmt.IsIncomplete {
-		s.Type = types.NewStruct()
-		s.Type.Opaque = true
-	} else {
-		var llvmMembers []types.Type
-		for i, m := range stmt.Members {
-			typ := c.llvmType(m.Type)
-			s.Members = append(s.Members, &Member{
-				Name: m.Ident.Name,
-				Id:   i,
-				Type: typ,
-			})
+	c.module.NewTypeDef(s.Name, s.Type)
+	c.context.addStruct(s.Name, s)
+}
 
-			llvmMembers = append(llvmMembers, typ)
-		}
+func (c *CodeGen) genStructBody(stmt *ast.StructStatement) {
+	if stmt.IsIncomplete || len(stmt.TypeParams) != 0 {
+		return
+	}
 
-		s.Type = types.NewStruct(llvmMembers...)
+	s, _ := c.context.findStruct(stmt.Ident.Name)
+	c.genStructMembers(s, stmt)
+}
+
+func (c *CodeGen) genStructMembers(s *Struct, stmt *ast.StructStatement) {
+	var llvmMembers []types.Type
+	for i, m := range stmt.Members {
+		typ := c.llvmType(m.Type)
+		s.Members = append(s.Members, &Member{
+			Name: m.Ident.Name,
+			Id:   i,
+			Type: typ,
+		})
+
+		llvmMembers = append(llvmMembers, typ)
 	}
 
-	c.module.NewTypeDef(s.Name, s.Type)
-	c.context.addStruct(s.Name, s)
+	s.Type.Fields = llvmMembers
+}
+
+func (c *CodeGen) genDeleteStatement(stmt *ast.DeleteStatement) {
+	if c.options.ARC {
+		errors.ErrorExit(fmt.Sprintf("%s | cannot delete with automatic reference counting", stmt.Delete))
+	}
+
+	ptr := c.genExpression(stmt.Value).Load(c.contextBlock)
+	if _, ok := ptr.Type().(*types.PointerType); !ok {
+		errors.ErrorExit(fmt.Sprintf("%s | cannot delete '%s'", stmt.Delete, ptr.Type()))
+	}
+
+	if c.isDroppable(PtrElmType(ptr)) {
+		c.genDrop(c.contextBlock, ptr)
+	}
+
+	c.genFree(ptr)
+}
+
+// deferCall is a call registered by defer. flag is set when the defer
+// statement is reached, and args hold the arguments evaluated at that point.
+type deferCall struct {
+	flag        value.Value
+	function    value.Value
+	args        []value.Value
+	isReference []bool
+
+	// env keeps the environment of a closure alive until the call
+	env value.Value
+}
+
+func (c *CodeGen) genDeferStatement(stmt *ast.DeferStatement) {
+	f := c.genCallee(stmt.Call)
+	d := &deferCall{function: f.fn}
+
+	if f.env != nil {
+		d.env = c.contextEntryBlock.NewAlloca(types.I8Ptr)
+		c.contextBlock.NewStore(f.env, d.env)
+		c.contextBlock.NewCall(c.runtime.retain, f.env)
+	}
+
+	for i, arg := range f.args {
+		isReference := i < len(f.isReference) && f.isReference[i]
+		slot := c.contextEntryBlock.NewAlloca(arg.Type())
+		if isReference {
+			c.contextBlock.NewStore(arg, slot)
+		} else {
+			c.genInit(slot, arg)
+		}
+		d.args = append(d.args, slot)
+		d.isReference = append(d.isReference, isReference)
+	}
+
+	d.flag = c.contextEntryBlock.NewAlloca(types.I1)
+	c.contextEntryBlock.NewStore(constant.False, d.flag)
+	c.contextBlock.NewStore(constant.True, d.flag)
+
+	c.contextDefers = append(c.contextDefers, d)
+}
+
+// genLoopBody generates the body of a loop. The calls deferred in the body run
+// at the end of each iteration, as well as on return from the body.
+func (c *CodeGen) genLoopBody(body *ast.BlockStatement) {
+	n := len(c.contextDefers)
+	moved := c.contextInit.clone().moved
+	c.genBlockStatement(body)
+	c.genDeferredCalls(n)
+	c.contextDefers = c.contextDefers[:n]
+	c.checkMovedInLoop(moved)
+}
+
+// genDeferredCalls runs the reached defers from the index from in reverse
+// order. The flag of a defer is cleared after the call, so that a defer in a
+// loop runs once for each time it is reached.
+func (c *CodeGen) genDeferredCalls(from int) {
+	for i := len(c.contextDefers) - 1; i >= from; i-- {
+		d := c.contextDefers[i]
+
+		blockCall := c.contextFunction.NewBlock(NextLabel("defer.call"))
+		blockNext := c.contextFunction.NewBlock(NextLabel("defer.next"))
+
+		flag := c.contextBlock.NewLoad(types.I1, d.flag)
+		c.contextBlock.NewCondBr(flag, blockCall, blockNext)
+
+		c.contextBlock = blockCall
+		var args []value.Value
+		for _, slot := range d.args {
+			args = append(args, c.contextBlock.NewLoad(PtrElmType(slot), slot))
+		}
+		ret := c.contextBlock.NewCall(d.function, args...)
+		c.contextBlock.NewStore(constant.False, d.flag)
+
+		if c.isManaged(ret.Type()) || c.isDroppable(ret.Type()) {
+			tmp := c.contextEntryBlock.NewAlloca(ret.Type())
+			c.contextBlock.NewStore(ret, tmp)
+			if c.isDroppable(ret.Type()) {
+				c.genDrop(c.contextBlock, tmp)
+			}
+			if c.isManaged(ret.Type()) {
+				c.genRefCount(c.contextBlock, c.runtime.release, tmp)
+			}
+		}
+		for j, slot := range d.args {
+			if !d.isReference[j] && c.isManaged(PtrElmType(slot)) {
+				c.genRefCount(c.contextBlock, c.runtime.release, slot)
+			}
+		}
+		if d.env != nil {
+			c.contextBlock.NewCall(c.runtime.release, c.contextBlock.NewLoad(types.I8Ptr, d.env))
+		}
+		c.contextBlock.NewBr(blockNext)
+
+		c.contextBlock = blockNext
+	}
 }
@@ -9,6 +9,7 @@ import (
 func (c *CodeGen) genStdlib() {
 	c.genGlibcFunc()
 	c.genString()
+	c.genRuntime()
 }
 
 func (c CodeGen) genGlibcFunc() {
@@ -29,6 +30,22 @@ func (c CodeGen) genGlibcFunc() {
 			IsReference: []bool{false, true},
 		})
 	}
+
+	{
+		malloc := c.module.NewFunc("malloc", types.I8Ptr, ir.NewParam("", types.I64))
+		c.context.addFunction("malloc", &Func{
+			Func:        malloc,
+			IsReference: []bool{false},
+		})
+	}
+
+	{
+		free := c.module.NewFunc("free", types.Void, ir.NewParam("", types.I8Ptr))
+		c.context.addFunction("free", &Func{
+			Func:        free,
+			IsReference: []bool{false},
+		})
+	}
 }
 
 func (c *CodeGen) genString() {
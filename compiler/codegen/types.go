@@ -3,18 +3,46 @@ package codegen
 import (
 	"fmt"
 	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/codegen/internal"
 	"github.com/arata-nvm/visket/compiler/errors"
+	"github.com/arata-nvm/visket/compiler/token"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
 	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
 )
 
 func (c *CodeGen) llvmType(t *ast.Type) types.Type {
-	typ, ok := c.context.findType(t.Name)
-	if !ok {
-		errors.ErrorExit(fmt.Sprintf("%s | unknown type '%s'", t.NamePos, t.Name))
+	if t.Typeof != nil {
+		return c.typeOf(t.Typeof)
 	}
 
 	if t.IsArray {
-		typ = types.NewArray(t.Len, typ)
+		return types.NewArray(c.constLength(t.Len, t.NamePos), c.llvmType(t.Elem))
+	}
+
+	if t.IsPointer {
+		return types.NewPointer(c.llvmType(t.Elem))
+	}
+
+	if t.IsFunc {
+		var params []types.Type
+		for _, p := range t.Params {
+			params = append(params, c.llvmType(p))
+		}
+		return funcValueType(c.llvmType(t.RetType), params...)
+	}
+
+	if len(t.Args) != 0 {
+		return c.genericStructType(t)
+	}
+
+	typ, ok := c.context.findType(t.Name)
+	if !ok {
+		if _, ok := c.context.findGenericStruct(t.Name); ok {
+			errors.ErrorExit(fmt.Sprintf("%s | missing type arguments for '%s'", t.NamePos, t.Name))
+		}
+		errors.ErrorExit(fmt.Sprintf("%s | unknown type '%s'", t.NamePos, t.Name))
 	}
 
 	return typ
@@ -24,8 +52,20 @@ type Struct struct {
 	Name    string
 	Members []*Member
 	Type    *types.StructType
+	Drop    *ir.Func
+	// operator functions like 'operator+', keyed by the operator
+	Operators map[string][]*Func
 
 	IsIncomplete bool
+
+	// instances of generic structs remember their type arguments
+	Generic  string
+	TypeArgs []types.Type
+
+	// slices of variadic parameters remember their element type
+	Slice types.Type
+	// distinct types like 'type UserId int' wrap the underlying type
+	Underlying types.Type
 }
 
 type Member struct {
@@ -43,3 +83,54 @@ func (s *Struct) findMember(name string) int {
 
 	return -1
 }
+
+// convertValue loads v and applies the implicit conversions into typ.
+func (c *CodeGen) convertValue(v Value, typ types.Type, pos token.Position) value.Value {
+	if iface, ok := c.findInterfaceType(typ); ok {
+		if val, ok := c.convertInterface(v, iface, pos); ok {
+			return val
+		}
+	}
+
+	val := v.Load(c.contextBlock)
+
+	if _, ok := val.(*constant.Null); ok {
+		if ptrTyp, ok := typ.(*types.PointerType); ok {
+			return constant.NewNull(ptrTyp)
+		}
+		if _, ok := funcSig(typ); ok {
+			return constant.NewZeroInitializer(typ)
+		}
+	}
+
+	return val
+}
+
+// valueType returns the type of the value v holds.
+func valueType(v Value) types.Type {
+	if v.IsVariable {
+		return internal.PtrElmType(v.Value)
+	}
+	return v.Value.Type()
+}
+
+// derefPointer returns a pointer to the aggregate v refers to. A variable
+// holding a pointer is dereferenced once, so that members and elements can be
+// accessed through pointers.
+func (c *CodeGen) derefPointer(v Value) value.Value {
+	if v.IsVariable {
+		elmTyp := internal.PtrElmType(v.Value)
+		if _, ok := elmTyp.(*types.PointerType); ok {
+			return c.contextBlock.NewLoad(elmTyp, v.Value)
+		}
+		return v.Value
+	}
+
+	if _, ok := v.Value.Type().(*types.PointerType); ok {
+		return v.Value
+	}
+
+	tmp := c.contextEntryBlock.NewAlloca(v.Value.Type())
+	c.contextBlock.NewStore(v.Value, tmp)
+	return tmp
+}
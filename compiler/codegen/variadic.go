@@ -0,0 +1,223 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/errors"
+	"github.com/arata-nvm/visket/compiler/token"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// isVariadic reports whether the last parameter of stmt is variadic.
+func isVariadic(stmt *ast.FunctionStatement) bool {
+	params := stmt.Sig.Params
+	return len(params) != 0 && params[len(params)-1].IsVariadic
+}
+
+// sliceType returns the type of slices of elem, which is a pair of a pointer to
+// the first element and the number of elements. Slices borrow the elements, so
+// they are valid only during the call they are passed to.
+func (c *CodeGen) sliceType(elem types.Type) types.Type {
+	name := "slice" + mangleTypeArgs([]types.Type{elem})
+
+	root := c.context.root()
+	if s, ok := root.findStruct(name); ok {
+		return s.Type
+	}
+
+	s := &Struct{
+		Name: name,
+		Type: types.NewStruct(types.NewPointer(elem), types.I32),
+		Members: []*Member{
+			{Name: "len", Id: 1, Type: types.I32},
+		},
+		Slice: elem,
+	}
+	c.module.NewTypeDef(s.Name, s.Type)
+	root.addStruct(s.Name, s)
+
+	return s.Type
+}
+
+// sliceElem returns the element type of typ if typ is a slice.
+func (c *CodeGen) sliceElem(typ types.Type) (types.Type, bool) {
+	if _, ok := typ.(*types.StructType); !ok {
+		return nil, false
+	}
+
+	s, ok := c.context.root().findStruct(typ.Name())
+	if !ok || s.Slice == nil {
+		return nil, false
+	}
+	return s.Slice, true
+}
+
+// spreadElem returns the element type of typ if a value of typ can be spread.
+func (c *CodeGen) spreadElem(typ types.Type) (types.Type, bool) {
+	if arrTyp, ok := typ.(*types.ArrayType); ok {
+		return arrTyp.ElemType, true
+	}
+	return c.sliceElem(typ)
+}
+
+func (c *CodeGen) genSliceIndexing(left value.Value, leftTyp types.Type, expr *ast.IndexExpression) Value {
+	elem, _ := c.sliceElem(leftTyp)
+	zero := constant.NewInt(types.I32, 0)
+	dataAddr := c.contextBlock.NewGetElementPtr(leftTyp, left, zero, zero)
+	data := c.contextBlock.NewLoad(types.NewPointer(elem), dataAddr)
+
+	index := c.genExpression(expr.Index).Load(c.contextBlock)
+	val := c.contextBlock.NewGetElementPtr(elem, data, index)
+	val.InBounds = true
+	return Value{
+		Value:      val,
+		IsVariable: true,
+		IsConstant: true,
+	}
+}
+
+// checkVariadic returns why the arguments of expr cannot be passed to a
+// variadic function taking params, or an empty string.
+func checkVariadic(expr *ast.CallExpression, params []*ast.Param) string {
+	name := calleeName(expr)
+	for i, arg := range expr.Args {
+		switch arg.(type) {
+		case *ast.NamedArgument:
+			return fmt.Sprintf("cannot use named arguments in call to variadic function '%s'", name)
+		case *ast.SpreadArgument:
+			if i != len(params)-1 || i != len(expr.Args)-1 {
+				return fmt.Sprintf("spread argument must be the only variadic argument in call to '%s'", name)
+			}
+		}
+	}
+	return ""
+}
+
+// matchVariadic is matchArgs for variadic functions. The trailing arguments
+// are compared with the element type of the variadic parameter.
+func (c *CodeGen) matchVariadic(f *Func, expr *ast.CallExpression, args []Value, skip int) int {
+	if checkVariadic(expr, f.Stmt.Sig.Params) != "" {
+		return -1
+	}
+
+	n := len(f.Func.Params) - 1
+	score := 0
+	for i := skip; i < n; i++ {
+		if i >= len(args) {
+			if f.Stmt.Sig.Params[i].Default == nil {
+				return -1
+			}
+			continue
+		}
+
+		s := c.matchArg(f, i, args[i])
+		if s < 0 {
+			return -1
+		}
+		score += s
+	}
+
+	elem, _ := c.sliceElem(f.Func.Params[n].Typ)
+	for i := n; i < len(args); i++ {
+		typ := valueType(args[i])
+		if _, ok := expr.Args[i].(*ast.SpreadArgument); ok {
+			if typ, ok := c.spreadElem(typ); !ok || !typ.Equal(elem) {
+				return -1
+			}
+			score++
+			continue
+		}
+
+		if typ.Equal(elem) {
+			score++
+		} else if !c.isConvertible(args[i], elem) {
+			return -1
+		}
+	}
+
+	return score
+}
+
+// packVariadic packs the trailing arguments of a call to the variadic function
+// f into a slice, which is passed to the variadic parameter by name so that the
+// default values of the other parameters are still used.
+func (c *CodeGen) packVariadic(expr *ast.CallExpression, f *Func, args []Value) (*ast.CallExpression, []Value) {
+	if reason := checkVariadic(expr, f.Stmt.Sig.Params); reason != "" {
+		errors.ErrorExit(fmt.Sprintf("%s | %s", expr.LParen, reason))
+	}
+
+	n := len(f.Stmt.Sig.Params) - 1
+	if n > len(args) {
+		n = len(args)
+	}
+	for i := n; i < len(args); i++ {
+		c.checkArg(argValue(expr.Args[i]), args[i], false)
+	}
+	param := f.Stmt.Sig.Params[len(f.Stmt.Sig.Params)-1]
+	slice := c.genSlice(f.Func.Params[len(f.Func.Params)-1].Typ, expr.Args[n:], args[n:], expr.LParen)
+
+	call := *expr
+	call.Args = append(append([]ast.Expression{}, expr.Args[:n]...), &ast.NamedArgument{
+		Name:  param.Ident,
+		Value: param.Ident,
+	})
+
+	return &call, append(append([]Value{}, args[:n]...), slice)
+}
+
+// genSlice creates a slice of sliceTyp from the arguments args. A spread
+// argument is passed as is if it is a slice, or as a view of the array.
+func (c *CodeGen) genSlice(sliceTyp types.Type, exprs []ast.Expression, args []Value, pos token.Position) Value {
+	elem, _ := c.sliceElem(sliceTyp)
+	zero := constant.NewInt(types.I32, 0)
+
+	if len(exprs) == 1 {
+		if spread, ok := exprs[0].(*ast.SpreadArgument); ok {
+			arg := args[0]
+			typ := valueType(arg)
+			spreadTyp, ok := c.spreadElem(typ)
+			if !ok {
+				errors.ErrorExit(fmt.Sprintf("%s | cannot spread '%s'", spread.Ellipsis, ast.Show(spread.Value)))
+			}
+			if !spreadTyp.Equal(elem) {
+				errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", spread.Ellipsis, showType(spreadTyp), showType(elem)))
+			}
+
+			if typ.Equal(sliceTyp) {
+				return Value{Value: arg.Load(c.contextBlock)}
+			}
+
+			arr := c.derefPointer(arg)
+			data := c.contextBlock.NewGetElementPtr(typ, arr, zero, zero)
+			length := constant.NewInt(types.I32, int64(typ.(*types.ArrayType).Len))
+			return Value{Value: c.newSlice(sliceTyp, data, length)}
+		}
+	}
+
+	if len(args) == 0 {
+		return Value{Value: c.newSlice(sliceTyp, constant.NewNull(types.NewPointer(elem)), zero)}
+	}
+
+	arrTyp := types.NewArray(uint64(len(args)), elem)
+	arr := c.contextEntryBlock.NewAlloca(arrTyp)
+	for i, arg := range args {
+		v := c.convertValue(arg, elem, pos)
+		if !v.Type().Equal(elem) {
+			errors.ErrorExit(fmt.Sprintf("%s | type mismatch '%s' and '%s'", pos, showType(v.Type()), showType(elem)))
+		}
+		addr := c.contextBlock.NewGetElementPtr(arrTyp, arr, zero, constant.NewInt(types.I32, int64(i)))
+		c.contextBlock.NewStore(v, addr)
+	}
+
+	data := c.contextBlock.NewGetElementPtr(arrTyp, arr, zero, zero)
+	length := constant.NewInt(types.I32, int64(len(args)))
+	return Value{Value: c.newSlice(sliceTyp, data, length)}
+}
+
+func (c *CodeGen) newSlice(sliceTyp types.Type, data value.Value, length value.Value) value.Value {
+	var v value.Value = constant.NewUndef(sliceTyp)
+	v = c.contextBlock.NewInsertValue(v, data, 0)
+	return c.contextBlock.NewInsertValue(v, length, 1)
+}
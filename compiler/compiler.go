@@ -8,12 +8,27 @@ import (
 	"github.com/arata-nvm/visket/compiler/lexer"
 	"github.com/arata-nvm/visket/compiler/optimizer"
 	"github.com/arata-nvm/visket/compiler/parser"
+	"github.com/arata-nvm/visket/compiler/target"
 	"log"
+	"strconv"
 )
 
 type Compiler struct {
 	Filename string
 	Program  *ast.Program
+
+	// ARC enables automatic reference counting of heap objects
+	ARC bool
+	// MemCheck reports heap objects that are still alive at exit
+	MemCheck bool
+	// Optimized is referred by 'when' as 'optimize' and '!debug'
+	Optimized bool
+	// Target is the target triple, or empty for the host
+	Target string
+	// Defines holds the values of '-D name=value' referred by 'when'
+	Defines map[string]string
+	// StrictInit reports reads of possibly unassigned variables as errors
+	StrictInit bool
 }
 
 func New() *Compiler {
@@ -29,6 +44,7 @@ func (c *Compiler) Compile(filename string) errors.ErrorList {
 	}
 
 	p := parser.New(l)
+	c.define(p.Defines)
 	c.Program = p.ParseProgram()
 	return p.Errors
 }
@@ -40,7 +56,12 @@ func (c *Compiler) Optimize() {
 
 func (c *Compiler) GenIR() string {
 	var b bytes.Buffer
-	cg := codegen.New(c.Program, &b)
+	cg := codegen.New(c.Program, &b, codegen.Options{
+		ARC:        c.ARC,
+		MemCheck:   c.MemCheck,
+		Target:     c.Target,
+		StrictInit: c.StrictInit,
+	})
 	cg.GenerateCode()
 	return b.String()
 }
@@ -52,3 +73,38 @@ func (c *Compiler) IncludeFiles() []string {
 	}
 	return filenames
 }
+
+// define sets the names that the conditions of 'when' blocks can refer to.
+func (c *Compiler) define(defines map[string]interface{}) {
+	triple := c.Target
+	if triple == "" {
+		triple = target.Host()
+	}
+	t := target.Parse(triple)
+
+	defines["target"] = triple
+	defines["arch"] = t.Arch
+	defines["os"] = t.OS
+	defines["optimize"] = c.Optimized
+	defines["debug"] = !c.Optimized
+
+	for name, value := range c.Defines {
+		defines[name] = parseDefine(value)
+	}
+}
+
+// parseDefine converts the value of '-D name=value' into a bool, an int or a
+// string.
+func parseDefine(value string) interface{} {
+	switch value {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	if n, err := strconv.Atoi(value); err == nil {
+		return n
+	}
+	return value
+}
@@ -12,8 +12,10 @@ type ErrorList []string
 var UseColors = false
 
 const (
-	errPrefix        = "error: "
-	coloredErrPrefix = "\x1b[31merror\x1b[0m: "
+	errPrefix            = "error: "
+	coloredErrPrefix     = "\x1b[31merror\x1b[0m: "
+	warningPrefix        = "warning: "
+	coloredWarningPrefix = "\x1b[33mwarning\x1b[0m: "
 )
 
 func (el ErrorList) ShowExit(verbose bool) {
@@ -35,19 +37,44 @@ func (el ErrorList) ShowExit(verbose bool) {
 }
 
 func Error(msg string) {
-	var prefix string
 	if UseColors {
-		prefix = coloredErrPrefix
+		report(coloredErrPrefix, msg)
 	} else {
-		prefix = errPrefix
+		report(errPrefix, msg)
 	}
+}
+
+// Warning reports msg without stopping the compilation.
+func Warning(msg string) {
+	if UseColors {
+		report(coloredWarningPrefix, msg)
+	} else {
+		report(warningPrefix, msg)
+	}
+}
 
+func report(prefix string, msg string) {
 	msg = strings.ReplaceAll(msg, "\n", "\n"+prefix)
 	fmt.Fprint(os.Stderr, prefix)
 	fmt.Fprintln(os.Stderr, msg)
 }
 
+// contexts describe where the code that is being compiled came from, such as
+// the call site of an instantiated generic function.
+var contexts []string
+
+func PushContext(msg string) {
+	contexts = append(contexts, msg)
+}
+
+func PopContext() {
+	contexts = contexts[:len(contexts)-1]
+}
+
 func ErrorExit(msg string) {
+	for _, c := range contexts {
+		Error(c)
+	}
 	Error(msg)
 	os.Exit(1)
 }
@@ -108,6 +108,22 @@ func (l *Lexer) NextToken() token.Token {
 		} else {
 			tok = l.newToken(token.REM, "%")
 		}
+	case '&':
+		if l.peekChar() == '&' {
+			l.readChar()
+			tok = l.newToken(token.LAND, "&&")
+		} else {
+			tok = l.newToken(token.AND, "&")
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			l.readChar()
+			tok = l.newToken(token.LOR, "||")
+		} else {
+			errors.ErrorExit(fmt.Sprintf("%s | illegal charactor '%c'", l.getCurrentPos(), l.ch))
+		}
+	case '@':
+		tok = l.newToken(token.AT, "@")
 	case ',':
 		tok = l.newToken(token.COMMA, ",")
 	case ':':
@@ -142,6 +158,8 @@ func (l *Lexer) NextToken() token.Token {
 		if l.peekChar() == '=' {
 			l.readChar()
 			tok = l.newToken(token.NEQ, "!=")
+		} else {
+			tok = l.newToken(token.NOT, "!")
 		}
 	case '<':
 		switch l.peekChar() {
@@ -178,7 +196,12 @@ func (l *Lexer) NextToken() token.Token {
 	case '.':
 		if l.peekChar() == '.' {
 			l.readChar()
-			tok = l.newToken(token.RANGE, "..")
+			if l.peekChar() == '.' {
+				l.readChar()
+				tok = l.newToken(token.ELLIPSIS, "...")
+			} else {
+				tok = l.newToken(token.RANGE, "..")
+			}
 		} else {
 			tok = l.newToken(token.PERIOD, ".")
 		}
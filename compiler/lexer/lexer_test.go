@@ -60,6 +60,18 @@ Math::cos()
 include "math.c"
 'a'
 '\n'
+*p = &a
+delete p
+nil
+defer f()
+interface
+sum(xs...)
+type
+const comptime
+sizeof alignof offsetof typeof static_assert
+when !a && b || c
+@link_name("f")
+macro m!(x)
 `
 
 	tests := []struct {
@@ -306,6 +318,62 @@ include "math.c"
 		{token.CHAR, "a"},
 		{token.CHAR, "\n"},
 
+		{token.MUL, "*"},
+		{token.IDENT, "p"},
+		{token.ASSIGN, "="},
+		{token.AND, "&"},
+		{token.IDENT, "a"},
+
+		{token.DELETE, "delete"},
+		{token.IDENT, "p"},
+
+		{token.NIL, "nil"},
+
+		{token.DEFER, "defer"},
+		{token.IDENT, "f"},
+		{token.LPAREN, "("},
+		{token.RPAREN, ")"},
+
+		{token.INTERFACE, "interface"},
+
+		{token.IDENT, "sum"},
+		{token.LPAREN, "("},
+		{token.IDENT, "xs"},
+		{token.ELLIPSIS, "..."},
+		{token.RPAREN, ")"},
+
+		{token.TYPE, "type"},
+
+		{token.CONST, "const"},
+		{token.COMPTIME, "comptime"},
+
+		{token.SIZEOF, "sizeof"},
+		{token.ALIGNOF, "alignof"},
+		{token.OFFSETOF, "offsetof"},
+		{token.TYPEOF, "typeof"},
+		{token.STATIC_ASSERT, "static_assert"},
+
+		{token.WHEN, "when"},
+		{token.NOT, "!"},
+		{token.IDENT, "a"},
+		{token.LAND, "&&"},
+		{token.IDENT, "b"},
+		{token.LOR, "||"},
+		{token.IDENT, "c"},
+
+		{token.AT, "@"},
+		{token.IDENT, "link_name"},
+		{token.LPAREN, "("},
+		{token.STRING, "f"},
+		{token.RPAREN, ")"},
+
+		{token.MACRO, "macro"},
+		{token.IDENT, "m"},
+		{token.NOT, "!"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.RPAREN, ")"},
+
 		{token.EOF, ""},
 	}
 
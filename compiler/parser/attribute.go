@@ -0,0 +1,123 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/token"
+)
+
+type attributeSpec struct {
+	forFunc  bool
+	forVar   bool
+	hasValue bool
+}
+
+var attributeSpecs = map[string]attributeSpec{
+	"inline":    {forFunc: true},
+	"noinline":  {forFunc: true},
+	"cold":      {forFunc: true},
+	"noreturn":  {forFunc: true},
+	"export":    {forFunc: true, forVar: true},
+	"link_name": {forFunc: true, forVar: true, hasValue: true},
+}
+
+// parseAttributedStatement parses a function or a global variable following
+// attributes like '@inline'.
+func (p *Parser) parseAttributedStatement() ast.Statement {
+	attrs := p.parseAttributes()
+
+	switch p.curToken.Type {
+	case token.FUNCTION, token.CONST:
+		var stmt *ast.FunctionStatement
+		if p.curTokenIs(token.CONST) {
+			stmt = p.parseConstFunctionStatement()
+		} else {
+			stmt = p.parseFunctionStatement()
+		}
+		if stmt == nil {
+			return nil
+		}
+		p.checkAttributes(attrs, true)
+		stmt.Attributes = attrs
+		return stmt
+	case token.VAR, token.VAL:
+		stmt := p.parseVarStatement()
+		if stmt == nil {
+			return nil
+		}
+		p.checkAttributes(attrs, false)
+		stmt.Attributes = attrs
+		return stmt
+	}
+
+	p.error(fmt.Sprintf("%s | attributes must be followed by a function or a global variable, got %s instead", p.curPos, p.curToken.Type))
+	return nil
+}
+
+// parseAttributes parses attributes like '@inline @link_name("foo")', and
+// moves to the token after them.
+func (p *Parser) parseAttributes() []*ast.Attribute {
+	var attrs []*ast.Attribute
+
+	for p.curTokenIs(token.AT) {
+		attr := &ast.Attribute{At: p.curPos}
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		attr.Name = p.curLiteral
+
+		if p.peekTokenIs(token.LPAREN) {
+			p.nextToken()
+			if !p.expectPeek(token.STRING) {
+				return nil
+			}
+			attr.Value = p.parseStringLiteral()
+			if !p.expectPeek(token.RPAREN) {
+				return nil
+			}
+		}
+
+		attrs = append(attrs, attr)
+		p.nextToken()
+	}
+
+	return attrs
+}
+
+func (p *Parser) checkAttributes(attrs []*ast.Attribute, isFunc bool) {
+	seen := make(map[string]bool)
+
+	for _, attr := range attrs {
+		spec, ok := attributeSpecs[attr.Name]
+		if !ok {
+			p.error(fmt.Sprintf("%s | unknown attribute '@%s'", attr.At, attr.Name))
+			continue
+		}
+
+		if isFunc && !spec.forFunc || !isFunc && !spec.forVar {
+			kind := "functions"
+			if !isFunc {
+				kind = "variables"
+			}
+			p.error(fmt.Sprintf("%s | attribute '@%s' cannot be applied to %s", attr.At, attr.Name, kind))
+		}
+
+		if spec.hasValue && attr.Value == nil {
+			p.error(fmt.Sprintf("%s | attribute '@%s' takes a string argument", attr.At, attr.Name))
+		} else if !spec.hasValue && attr.Value != nil {
+			p.error(fmt.Sprintf("%s | attribute '@%s' takes no arguments", attr.At, attr.Name))
+		} else if attr.Value != nil && attr.Value.Value == "" {
+			p.error(fmt.Sprintf("%s | argument of '@%s' cannot be empty", attr.At, attr.Name))
+		}
+
+		if seen[attr.Name] {
+			p.error(fmt.Sprintf("%s | duplicate attribute '@%s'", attr.At, attr.Name))
+		}
+		seen[attr.Name] = true
+	}
+
+	if seen["inline"] && seen["noinline"] {
+		attr, _ := ast.FindAttribute(attrs, "noinline")
+		p.error(fmt.Sprintf("%s | conflicting attributes '@inline' and '@noinline'", attr.At))
+	}
+}
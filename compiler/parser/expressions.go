@@ -11,7 +11,7 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	left := p.parsePrefixExpression()
 
 	// TODO rewrite
-	for !p.peekTokenIs(token.SEMICOLON) && (isAssign(p.peekToken) || precedence < p.peekPrecedence()) {
+	for !p.peekTokenIs(token.SEMICOLON) && !p.peekIsDereference() && (isAssign(p.peekToken) && precedence == LOWEST || precedence < p.peekPrecedence()) {
 		p.nextToken()
 		left = p.parseInfixExpression(left)
 	}
@@ -19,6 +19,12 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	return left
 }
 
+// peekIsDereference reports whether the next token is a '*' beginning a new
+// line, which starts a dereference rather than continuing a multiplication.
+func (p *Parser) peekIsDereference() bool {
+	return p.peekTokenIs(token.MUL) && p.peekToken.Pos.Line > p.curPos.Line
+}
+
 func isAssign(tok token.Token) bool {
 	switch tok.Type {
 	case
@@ -46,15 +52,149 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 	case token.LPAREN:
 		return p.parseGroupedExpression()
 	case token.IDENT:
-		return p.parseIdentifier()
+		if p.isMacroCall() {
+			return p.expandExpression()
+		}
+		return p.parseName()
 	case token.NEW:
 		return p.parseNewExpression()
+	case token.NIL:
+		return p.parseNilLiteral()
+	case token.MUL, token.AND, token.NOT:
+		return p.parsePrefixOperator()
+	case token.FUNCTION:
+		return p.parseFunctionLiteral()
+	case token.COMPTIME:
+		return p.parseComptimeExpression()
+	case token.IF:
+		return p.parseIfExpression()
+	case token.LBRACE:
+		return &ast.BlockExpression{Block: p.parseBlockStatement()}
+	case token.SIZEOF:
+		return p.parseSizeofExpression()
+	case token.ALIGNOF:
+		return p.parseAlignofExpression()
+	case token.OFFSETOF:
+		return p.parseOffsetofExpression()
 	}
 
 	p.error(fmt.Sprintf("%s | no prefix parse function for %s found", p.curToken.Pos, p.curToken.Type))
 	return nil
 }
 
+func (p *Parser) parseFunctionLiteral() *ast.FunctionLiteral {
+	lit := &ast.FunctionLiteral{
+		Func: p.curPos,
+		Sig:  &ast.FunctionSignature{},
+	}
+
+	// variables captured by copy
+	if p.peekTokenIs(token.LBRACKET) {
+		p.nextToken()
+		for !p.peekTokenIs(token.RBRACKET) {
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			ident := p.parseIdentifier()
+			p.resolve(ident)
+			lit.Copies = append(lit.Copies, ident)
+			if !p.peekTokenIs(token.COMMA) {
+				break
+			}
+			p.nextToken()
+		}
+		if !p.expectPeek(token.RBRACKET) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	lit.Sig.Params = p.parseFunctionParameters(nil)
+
+	lit.Sig.RetType = &ast.Type{Name: "void"}
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		p.nextToken()
+		lit.Sig.RetType = p.parseType()
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	lit.Body = p.parseBlockStatement()
+
+	return lit
+}
+
+func (p *Parser) parseComptimeExpression() *ast.ComptimeExpression {
+	expr := &ast.ComptimeExpression{Comptime: p.curPos}
+
+	p.nextToken()
+	expr.Value = p.parseExpression(PREFIX)
+
+	return expr
+}
+
+func (p *Parser) parseIfExpression() ast.Expression {
+	stmt := p.parseIfStatement()
+	if stmt == nil {
+		return nil
+	}
+	return &ast.IfExpression{Stmt: stmt}
+}
+
+func (p *Parser) parseSizeofExpression() *ast.SizeofExpression {
+	expr := &ast.SizeofExpression{Sizeof: p.curPos}
+	expr.Type = p.parseTypeArgument()
+	return expr
+}
+
+func (p *Parser) parseAlignofExpression() *ast.AlignofExpression {
+	expr := &ast.AlignofExpression{Alignof: p.curPos}
+	expr.Type = p.parseTypeArgument()
+	return expr
+}
+
+func (p *Parser) parseOffsetofExpression() *ast.OffsetofExpression {
+	expr := &ast.OffsetofExpression{Offsetof: p.curPos}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	expr.Type = p.parseType()
+
+	if !p.expectPeek(token.COMMA) {
+		return nil
+	}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	expr.Member = p.parseIdentifier()
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	return expr
+}
+
+// parseTypeArgument parses '(T)' of 'sizeof(T)'.
+func (p *Parser) parseTypeArgument() *ast.Type {
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	typ := p.parseType()
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	return typ
+}
+
 func (p *Parser) parseMinusPrefix() *ast.InfixExpression {
 	expr := &ast.InfixExpression{
 		Left:  &ast.IntegerLiteral{Value: 0},
@@ -68,6 +208,18 @@ func (p *Parser) parseMinusPrefix() *ast.InfixExpression {
 	return expr
 }
 
+func (p *Parser) parsePrefixOperator() *ast.PrefixExpression {
+	expr := &ast.PrefixExpression{
+		OpPos: p.curPos,
+		Op:    p.curLiteral,
+	}
+
+	p.nextToken()
+	expr.Right = p.parseExpression(PREFIX)
+
+	return expr
+}
+
 func (p *Parser) parseIntegerLiteral() *ast.IntegerLiteral {
 	lit := &ast.IntegerLiteral{Pos: p.curPos}
 
@@ -112,6 +264,10 @@ func (p *Parser) parseCharLiteral() *ast.CharLiteral {
 	return lit
 }
 
+func (p *Parser) parseNilLiteral() *ast.NilLiteral {
+	return &ast.NilLiteral{Pos: p.curPos}
+}
+
 func (p *Parser) parseGroupedExpression() ast.Expression {
 	p.nextToken()
 
@@ -124,6 +280,11 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 }
 
 func (p *Parser) parseIdentifier() *ast.Identifier {
+	if arg, ok := p.expansion.arg(p.curLiteral).(*ast.Identifier); ok {
+		ident := *arg
+		return &ident
+	}
+
 	return &ast.Identifier{
 		Pos:  p.curPos,
 		Name: p.curLiteral,
@@ -177,7 +338,7 @@ func (p *Parser) parseCallModFuncExpression(left ast.Expression) *ast.CallExpres
 		return nil
 	}
 
-	if !p.expectPeek(token.IDENT) {
+	if !p.expectMethodName() {
 		return nil
 	}
 	funcName := p.parseIdentifier()
@@ -188,19 +349,18 @@ func (p *Parser) parseCallModFuncExpression(left ast.Expression) *ast.CallExpres
 		return nil
 	}
 
-	expr.Function.Name = fmt.Sprintf("%s_%s", modName.Name, expr.Function.Name)
+	expr.Function.Name = fmt.Sprintf("%s::%s", modName.Name, expr.Function.Name)
 
 	return expr
 }
 
 func (p *Parser) parseCallExpression(left ast.Expression) *ast.CallExpression {
-	function, ok := left.(*ast.Identifier)
-	if !ok {
-		return nil
-	}
-
 	expr := &ast.CallExpression{LParen: p.peekToken.Pos}
-	expr.Function = function
+	if function, ok := left.(*ast.Identifier); ok {
+		expr.Function = function
+	} else {
+		expr.Callee = left
+	}
 	expr.Args = p.parseCallArguments()
 	expr.RParen = p.curPos
 
@@ -217,12 +377,12 @@ func (p *Parser) parseCallArguments() []ast.Expression {
 
 	p.nextToken()
 
-	params = append(params, p.parseExpression(LOWEST))
+	params = append(params, p.parseCallArgument())
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		params = append(params, p.parseExpression(LOWEST))
+		params = append(params, p.parseCallArgument())
 	}
 
 	if !p.expectPeek(token.RPAREN) {
@@ -232,6 +392,26 @@ func (p *Parser) parseCallArguments() []ast.Expression {
 	return params
 }
 
+// parseCallArgument parses an argument, which may be named like 'x: 1' or
+// spread like 'xs...'.
+func (p *Parser) parseCallArgument() ast.Expression {
+	if !p.curTokenIs(token.IDENT) || !p.peekTokenIs(token.COLON) {
+		arg := p.parseExpression(LOWEST)
+		if !p.peekTokenIs(token.ELLIPSIS) {
+			return arg
+		}
+		p.nextToken()
+		return &ast.SpreadArgument{Value: arg, Ellipsis: p.curPos}
+	}
+
+	arg := &ast.NamedArgument{Name: p.parseIdentifier()}
+	p.nextToken()
+	p.nextToken()
+	arg.Value = p.parseExpression(LOWEST)
+
+	return arg
+}
+
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp := &ast.IndexExpression{
 		Left:   left,
@@ -263,6 +443,7 @@ func (p *Parser) parseDotExpression(left ast.Expression) ast.Expression {
 
 	expr := &ast.CallExpression{
 		Function: ident,
+		IsMethod: true,
 	}
 	p.nextToken()
 
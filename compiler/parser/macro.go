@@ -0,0 +1,430 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/token"
+)
+
+// maxMacroDepth limits nested expansions, so that a recursive macro is
+// reported instead of expanding forever.
+const maxMacroDepth = 100
+
+var macroParamKinds = map[string]bool{
+	"expr":  true,
+	"ident": true,
+	"type":  true,
+}
+
+type macro struct {
+	ident  *ast.Identifier
+	params []*macroParam
+	// tokens of the body including the braces
+	body []token.Token
+}
+
+type macroParam struct {
+	ident *ast.Identifier
+	kind  string
+}
+
+// macroTable is shared by the parsers of the expansions.
+type macroTable struct {
+	macros     map[string]*macro
+	expansions int
+}
+
+// expansion holds the arguments of a macro call while the body is parsed.
+type expansion struct {
+	pos   *token.Expansion
+	id    int
+	depth int
+	// ast.Expression, *ast.Identifier or *ast.Type
+	args map[string]interface{}
+	// local names declared in the body and their new names
+	renames map[string]string
+}
+
+func (e *expansion) arg(name string) interface{} {
+	if e == nil {
+		return nil
+	}
+	return e.args[name]
+}
+
+// replay reads the body of a macro. The tokens are marked as expanded from the
+// call so that errors are reported at both places.
+type replay struct {
+	tokens    []token.Token
+	expansion *token.Expansion
+	filename  string
+	last      token.Position
+}
+
+func (r *replay) NextToken() token.Token {
+	if len(r.tokens) == 0 {
+		return token.New(token.EOF, "", r.last)
+	}
+
+	tok := r.tokens[0]
+	r.tokens = r.tokens[1:]
+	tok.Pos.Expansion = r.expansion
+	r.last = tok.Pos
+	return tok
+}
+
+func (r *replay) Filename() string {
+	return r.filename
+}
+
+// parseMacro parses 'macro name(x: expr, f: ident, T: type) { ... }'. The body
+// is kept as tokens, and parsed again at each call with the arguments.
+func (p *Parser) parseMacro(enabled bool) {
+	m := &macro{}
+
+	if !p.expectPeek(token.IDENT) {
+		return
+	}
+	m.ident = p.parseIdentifier()
+
+	if !p.expectPeek(token.LPAREN) {
+		return
+	}
+
+	seen := make(map[string]bool)
+	for !p.peekTokenIs(token.RPAREN) {
+		if !p.expectPeek(token.IDENT) {
+			return
+		}
+		param := &macroParam{ident: p.parseIdentifier()}
+		if seen[param.ident.Name] {
+			p.error(fmt.Sprintf("%s | duplicate macro parameter '%s'", param.ident.Pos, param.ident.Name))
+		}
+		seen[param.ident.Name] = true
+
+		if !p.expectPeek(token.COLON) {
+			return
+		}
+		// 'type' is a keyword
+		p.nextToken()
+		param.kind = p.curLiteral
+		if !macroParamKinds[param.kind] {
+			p.error(fmt.Sprintf("%s | unknown kind of macro parameter '%s', expected expr, ident or type", p.curPos, param.kind))
+		}
+		m.params = append(m.params, param)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return
+	}
+	m.body = p.readMacroBody()
+	if m.body == nil || !enabled {
+		return
+	}
+
+	if _, ok := p.macros.macros[m.ident.Name]; ok {
+		p.error(fmt.Sprintf("%s | already declared macro '%s'", m.ident.Pos, m.ident.Name))
+		return
+	}
+	p.macros.macros[m.ident.Name] = m
+}
+
+// readMacroBody reads the tokens from '{' to the matching '}'.
+func (p *Parser) readMacroBody() []token.Token {
+	body := []token.Token{p.curToken}
+	depth := 0
+
+	for {
+		p.nextToken()
+		body = append(body, p.curToken)
+
+		switch p.curToken.Type {
+		case token.EOF:
+			p.error(fmt.Sprintf("%s | unterminated macro body", body[0].Pos))
+			return nil
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth == 0 {
+				return body
+			}
+			depth--
+		}
+	}
+}
+
+// isMacroCall reports whether the current token begins a call like 'name!(...)'.
+func (p *Parser) isMacroCall() bool {
+	return p.curTokenIs(token.IDENT) && p.peekTokenIs(token.NOT)
+}
+
+// expandTopLevel expands a macro call at the top level into declarations.
+func (p *Parser) expandTopLevel(program *ast.Program, enabled bool) {
+	if !enabled {
+		// the macro may be declared only when the branch is taken
+		p.skipMacroCall()
+		return
+	}
+
+	sub := p.parseMacroCall()
+	if sub == nil {
+		return
+	}
+
+	// skip '{'
+	sub.nextToken()
+	for !sub.curTokenIs(token.RBRACE) && !sub.curTokenIs(token.EOF) {
+		sub.parseTopLevel(program, enabled)
+		sub.nextToken()
+	}
+	p.Errors = append(p.Errors, sub.Errors...)
+}
+
+// expandStatements expands a macro call in a block into statements, which are
+// put in the block as is so that the variables named by the arguments can be
+// used after the call.
+func (p *Parser) expandStatements() []ast.Statement {
+	block := p.expandBlock()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	if block == nil {
+		return nil
+	}
+	return block.Statements
+}
+
+// expandExpression expands a macro call in an expression. The body of the
+// macro must consist of a single expression.
+func (p *Parser) expandExpression() ast.Expression {
+	ident := p.parseIdentifier()
+
+	block := p.expandBlock()
+	if block == nil {
+		return nil
+	}
+
+	if len(block.Statements) == 1 {
+		if stmt, ok := block.Statements[0].(*ast.ExpressionStatement); ok {
+			return stmt.Expression
+		}
+	}
+
+	p.error(fmt.Sprintf("%s | macro '%s' cannot be used as an expression", ident.Pos, ident.Name))
+	return nil
+}
+
+func (p *Parser) expandBlock() *ast.BlockStatement {
+	sub := p.parseMacroCall()
+	if sub == nil {
+		return nil
+	}
+
+	block := sub.parseBlockStatement()
+	p.Errors = append(p.Errors, sub.Errors...)
+	return block
+}
+
+// parseMacroCall parses the arguments of a macro call, and returns the parser
+// of the body of the macro.
+func (p *Parser) parseMacroCall() *Parser {
+	ident := p.parseIdentifier()
+
+	m, ok := p.macros.macros[ident.Name]
+	if !ok {
+		p.error(fmt.Sprintf("%s | undefined macro '%s'", ident.Pos, ident.Name))
+		p.skipMacroCall()
+		return nil
+	}
+
+	depth := 1
+	if p.expansion != nil {
+		depth = p.expansion.depth + 1
+	}
+	if depth > maxMacroDepth {
+		// report at the outermost call instead of listing all the expansions
+		pos := ident.Pos
+		for pos.Expansion != nil {
+			pos = pos.Expansion.Pos
+		}
+		p.error(fmt.Sprintf("%s | macro expansion of '%s' is too deep", pos, ident.Name))
+		p.skipMacroCall()
+		return nil
+	}
+
+	p.nextToken()
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	args := make(map[string]interface{})
+	for i, param := range m.params {
+		if p.peekTokenIs(token.RPAREN) {
+			p.error(fmt.Sprintf("%s | not enough arguments in call to macro '%s'", p.curPos, ident.Name))
+			p.nextToken()
+			return nil
+		}
+		if i != 0 && !p.expectPeek(token.COMMA) {
+			p.skipArguments()
+			return nil
+		}
+		p.nextToken()
+
+		arg := p.parseMacroArgument(param, ident)
+		if arg == nil {
+			p.skipArguments()
+			return nil
+		}
+		args[param.ident.Name] = arg
+	}
+
+	if p.peekTokenIs(token.COMMA) {
+		p.error(fmt.Sprintf("%s | too many arguments in call to macro '%s'", p.peekToken.Pos, ident.Name))
+		p.skipArguments()
+		return nil
+	}
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	p.macros.expansions++
+	sub := &Parser{
+		l: []tokenReader{&replay{
+			tokens:    m.body,
+			expansion: &token.Expansion{Macro: ident.Name, Pos: ident.Pos},
+			filename:  m.ident.Pos.Filename,
+		}},
+		importedFiles: p.importedFiles,
+		Defines:       p.Defines,
+		macros:        p.macros,
+	}
+	sub.nextToken()
+	sub.nextToken()
+
+	sub.expansion = &expansion{
+		pos:     sub.curPos.Expansion,
+		id:      p.macros.expansions,
+		depth:   depth,
+		args:    args,
+		renames: make(map[string]string),
+	}
+	return sub
+}
+
+func (p *Parser) parseMacroArgument(param *macroParam, macro *ast.Identifier) interface{} {
+	switch param.kind {
+	case "ident":
+		if !p.curTokenIs(token.IDENT) {
+			p.error(fmt.Sprintf("%s | argument '%s' of macro '%s' must be an identifier", p.curPos, param.ident.Name, macro.Name))
+			return nil
+		}
+		return p.parseIdentifier()
+	case "type":
+		if typ := p.parseType(); typ != nil {
+			return typ
+		}
+		return nil
+	}
+
+	return p.parseExpression(LOWEST)
+}
+
+// skipMacroCall moves to the ')' closing the call without parsing the
+// arguments.
+func (p *Parser) skipMacroCall() {
+	p.nextToken()
+	if !p.expectPeek(token.LPAREN) {
+		return
+	}
+	p.skipArguments()
+}
+
+// skipArguments moves to the ')' closing the arguments being parsed, so that
+// an error in a macro call does not cascade.
+func (p *Parser) skipArguments() {
+	if p.curTokenIs(token.RPAREN) {
+		return
+	}
+
+	depth := 0
+	for {
+		p.nextToken()
+		switch p.curToken.Type {
+		case token.EOF:
+			return
+		case token.LPAREN:
+			depth++
+		case token.RPAREN:
+			if depth == 0 {
+				return
+			}
+			depth--
+		}
+	}
+}
+
+// bind renames a local name declared in the body of a macro, so that it cannot
+// clash with the names at the call site. The names given by the arguments are
+// kept as is.
+func (p *Parser) bind(ident *ast.Identifier) {
+	e := p.expansion
+	if e == nil || ident == nil || ident.Pos.Expansion != e.pos || ident.Name == "self" {
+		return
+	}
+
+	name := fmt.Sprintf("%s#%d", ident.Name, e.id)
+	e.renames[ident.Name] = name
+	ident.Name = name
+}
+
+// resolve renames ident if it refers to a name declared in the body of the
+// macro being expanded.
+func (p *Parser) resolve(ident *ast.Identifier) {
+	e := p.expansion
+	if e == nil || ident.Pos.Expansion != e.pos {
+		return
+	}
+
+	if name, ok := e.renames[ident.Name]; ok {
+		ident.Name = name
+	}
+}
+
+// parseName parses an identifier in an expression, which is replaced with the
+// argument in the body of a macro.
+func (p *Parser) parseName() ast.Expression {
+	if arg, ok := p.expansion.arg(p.curLiteral).(ast.Expression); ok {
+		return arg
+	}
+
+	ident := p.parseIdentifier()
+	p.resolve(ident)
+	p.markGlobal(ident)
+	return ident
+}
+
+// markGlobal marks ident if it is a free name in the body of the macro being
+// expanded, so that the variables at the call site cannot capture it.
+func (p *Parser) markGlobal(ident *ast.Identifier) {
+	e := p.expansion
+	if e == nil || ident.Pos.Expansion != e.pos || ident.Name == "self" {
+		return
+	}
+
+	for _, name := range e.renames {
+		if name == ident.Name {
+			return
+		}
+	}
+	ident.IsGlobal = true
+}
@@ -11,6 +11,8 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	OR
+	AND
 	RELATIONAL
 	SHIFT
 	SUM
@@ -21,6 +23,8 @@ const (
 )
 
 var precedences = map[token.TokenType]int{
+	token.LOR:      OR,
+	token.LAND:     AND,
 	token.EQ:       RELATIONAL,
 	token.NEQ:      RELATIONAL,
 	token.LT:       RELATIONAL,
@@ -41,7 +45,7 @@ var precedences = map[token.TokenType]int{
 }
 
 type Parser struct {
-	l          []*lexer.Lexer
+	l          []tokenReader
 	curToken   token.Token
 	curPos     token.Position
 	curLiteral string
@@ -50,12 +54,27 @@ type Parser struct {
 	Errors errors.ErrorList
 
 	importedFiles map[string]bool
+
+	// Defines holds the names that the conditions of 'when' blocks refer to.
+	// The values are bools, ints or strings.
+	Defines map[string]interface{}
+
+	macros    *macroTable
+	expansion *expansion
+}
+
+// tokenReader is a lexer, or the body of a macro being expanded.
+type tokenReader interface {
+	NextToken() token.Token
+	Filename() string
 }
 
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
-		l:             []*lexer.Lexer{l},
+		l:             []tokenReader{l},
 		importedFiles: make(map[string]bool),
+		Defines:       make(map[string]interface{}),
+		macros:        &macroTable{macros: make(map[string]*macro)},
 	}
 
 	p.nextToken()
@@ -67,31 +86,64 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{}
 
 	for !p.curTokenIs(token.EOF) {
-		stmt := p.parseTopLevelStatement()
-		switch stmt := stmt.(type) {
-		case *ast.ModuleStatement:
-			program.Modules = append(program.Modules, stmt)
-		case *ast.FunctionStatement:
-			program.Functions = append(program.Functions, stmt)
-		case *ast.StructStatement:
-			program.Structs = append(program.Structs, stmt)
-		case *ast.VarStatement:
-			program.Globals = append(program.Globals, stmt)
-		case *ast.IncludeStatement:
-			program.Includes = append(program.Includes, stmt)
-		case *ast.ImportStatement:
-			if ok := p.importFile(stmt.File.Name); !ok {
-				errors.ErrorExit(fmt.Sprintf("%s | cannot import '%s'", stmt.Import, stmt.File.Name))
-			}
-		default:
-			p.error(fmt.Sprintf("unexpected statement: %s", ast.Show(stmt)))
-		}
+		p.parseTopLevel(program, true)
 		p.nextToken()
 	}
 
 	return program
 }
 
+// parseTopLevel parses a top-level statement and adds it to program unless it
+// is disabled by 'when'.
+func (p *Parser) parseTopLevel(program *ast.Program, enabled bool) {
+	if p.curTokenIs(token.WHEN) {
+		p.parseWhen(program, enabled)
+		return
+	}
+	if p.curTokenIs(token.MACRO) {
+		p.parseMacro(enabled)
+		return
+	}
+	if p.isMacroCall() {
+		p.expandTopLevel(program, enabled)
+		return
+	}
+
+	stmt := p.parseTopLevelStatement()
+	if !enabled {
+		if _, ok := stmt.(*ast.ImportStatement); ok {
+			// skip the file name, which is consumed by importFile otherwise
+			p.nextToken()
+		}
+		return
+	}
+
+	switch stmt := stmt.(type) {
+	case *ast.ModuleStatement:
+		program.Modules = append(program.Modules, stmt)
+	case *ast.FunctionStatement:
+		program.Functions = append(program.Functions, stmt)
+	case *ast.StructStatement:
+		program.Structs = append(program.Structs, stmt)
+	case *ast.InterfaceStatement:
+		program.Interfaces = append(program.Interfaces, stmt)
+	case *ast.TypeStatement:
+		program.Types = append(program.Types, stmt)
+	case *ast.VarStatement:
+		program.Globals = append(program.Globals, stmt)
+	case *ast.IncludeStatement:
+		program.Includes = append(program.Includes, stmt)
+	case *ast.StaticAssertStatement:
+		program.Asserts = append(program.Asserts, stmt)
+	case *ast.ImportStatement:
+		if ok := p.importFile(stmt.File.Name); !ok {
+			errors.ErrorExit(fmt.Sprintf("%s | cannot import '%s'", stmt.Import, stmt.File.Name))
+		}
+	default:
+		p.error(fmt.Sprintf("unexpected statement: %s", ast.Show(stmt)))
+	}
+}
+
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.curPos = p.curToken.Pos
@@ -138,6 +190,17 @@ func (p *Parser) expectPeek(tokenType token.TokenType) bool {
 	return false
 }
 
+// expectMethodName is like expectPeek(token.IDENT), but also accepts 'new',
+// which is the usual name of the associated function creating a struct.
+func (p *Parser) expectMethodName() bool {
+	if p.peekTokenIs(token.NEW) {
+		p.nextToken()
+		return true
+	}
+
+	return p.expectPeek(token.IDENT)
+}
+
 func (p *Parser) peekPrecedence() int {
 	if p, ok := precedences[p.peekToken.Type]; ok {
 		return p
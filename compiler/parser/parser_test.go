@@ -18,9 +18,18 @@ func TestParseProgram(t *testing.T) {
 		{"fun add(n: int): int {return n + 2} fun main(): int {return num(1)}", "(def-func add(n: int): int ((return (n + 2))))(def-func main(): int ((return (func-call num(1)))))"},
 		{"fun add(a: int, b: int): int {return a + b} fun main(): int {return num(1, 2)}", "(def-func add(a: int, b: int): int ((return (a + b))))(def-func main(): int ((return (func-call num(1, 2)))))"},
 		{"fun f(a, b, c, d: int): int {return 1}", "(def-func f(a: int, b: int, c: int, d: int): int ((return 1)))"},
+		{"fun sum(sep: string, xs: ...int): int {return 0}", "(def-func sum(sep: string, xs: ...int): int ((return 0)))"},
+		{"fun f(a: int, b: int = 2, c, d: float = 1.5) {}", "(def-func f(a: int, b: int = 2, c: float, d: float = 1.500000): void ())"},
 
 		{"struct Foo { X: int Y: float }", "(struct Foo(X: int, Y: float))"},
 		{"struct Bar", "(struct Bar())"},
+		{"interface Shape { fun area(): int fun scale(ref n: int) }", "(interface Shape(area(): int, scale(ref n: int): void))"},
+		{"type Meters = float64", "(type Meters = float64)"},
+		{"type UserId int type Ids = [3]UserId", "(type UserId int)(type Ids = [3]UserId)"},
+		{"struct Pair<A, B> { first: A second: B }", "(struct Pair<A, B>(first: A, second: B))"},
+		{"const fun sq(n: int): int { return n * n }", "(def-const-func sq(n: int): int ((return (n * n))))"},
+		{"fun max<T>(a, b: T): T { return a }", "(def-func max<T>(a: T, b: T): T ((return a)))"},
+		{"fun f(p: Pair<int, Pair<int, *int>>) {}", "(def-func f(p: Pair<int, Pair<int, *int>>): void ())"},
 
 		{"var i:int", "(var i: int)"},
 		{"var i = 10", "(var i = 10)"},
@@ -29,6 +38,27 @@ func TestParseProgram(t *testing.T) {
 		{"module Lib { func a() {} func b() {} }", "(module Lib (def-func a(): void ())(def-func b(): void ()))"},
 
 		{"include \"math.c\"", "(include \"math.c\")"},
+
+		{"@inline @link_name(\"c_add\") fun add(a, b: int): int { return a + b }", "(def-func @inline @link_name(\"c_add\") add(a: int, b: int): int ((return (a + b))))"},
+		{"@export val VERSION = 3 @cold const fun f() {}", "(var @export VERSION = 3)(def-const-func @cold f(): void ())"},
+
+		{"when true { fun a() {} } else { fun b() {} }", "(def-func a(): void ())"},
+		{"when false { fun a() {} } else when !false && 1 < 2 { fun b() {} } else { fun c() {} }", "(def-func b(): void ())"},
+		{"when false { when undefined { import \"x\" } } fun d() {}", "(def-func d(): void ())"},
+
+		{"struct Node { next: *Node }", "(struct Node(next: *Node))"},
+		{"struct File { fd: int fun drop(ref self: File) {} }", "(struct File(fd: int)(def-func drop(ref self: File): void ()))"},
+		{"var a: [3]*int", "(var a: [3]*int)"},
+		{"var a: [N + 1]int", "(var a: [(N + 1)]int)"},
+		{"var b: typeof(a[0])", "(var b: typeof((a[0])))"},
+		{"static_assert(sizeof(int) == 4, \"int must be 32 bits\")", "(static_assert ((sizeof int) == 4) \"int must be 32 bits\")"},
+		{"var f: fun(int, *int): int", "(var f: fun(int, *int): int)"},
+		{"fun f(cb: fun()): fun(int): int {}", "(def-func f(cb: fun(): void): fun(int): int ())"},
+		{"fun Person.rename(ref self, n: string) {}", "(def-func Person.rename(ref self: Person, n: string): void ())"},
+		{"fun Person.new(): Person {}", "(def-func Person.new(): Person ())"},
+		{"fun Person.age(self): int {}", "(def-func Person.age(self: Person): int ())"},
+		{"fun Complex.operator+(self, o: Complex): Complex {}", "(def-func Complex.operator+(self: Complex, o: Complex): Complex ())"},
+		{"fun Vec.operator[](ref self, i: int): int {}", "(def-func Vec.operator[](ref self: Vec, i: int): int ())"},
 	}
 
 	for i, test := range tests {
@@ -94,12 +124,18 @@ func TestParseStatement(t *testing.T) {
 
 		{"if 1 { 1 } else { 0 }", "(if 1(1)(0))"},
 		{"if 1 { 1 } else if 0 { 2 } else { 3 }", "(if 1(1)((if 0(2)(3))))"},
+		{"var s = if x < 0 { -1 } else { 1 }", "(var s = (if (x < 0)((0 - 1))(1)))"},
+		{"var y = { val t = f(); t * 2 }", "(var y = {(var t = (func-call f()))(t * 2)})"},
+		{"{ var a = 1 }", "(var a = 1)"},
 
 		{"while 1 { 1 }", "(while 1(1))"},
 
 		{"a = a + 1", "(a = (a + 1))"},
 
 		{"for i in 0..10 {1}", "(for i in 0..10(1))"},
+		{"for x in list.iter() {f(x)}", "(for x in (func-call iter(list))((func-call f(x))))"},
+		{"for i in 0..<n step 2 {1}", "(for i in 0..<n step 2(1))"},
+		{"for i, c in s {1}", "(for i, c in s(1))"},
 		{"for var i = 0; i < 10; i = i + 1 {1}", "(for (var i = 0); (i < 10); (i = (i + 1))(1))"},
 
 		{"array[1]", "(array[1])"},
@@ -113,8 +149,34 @@ func TestParseStatement(t *testing.T) {
 		{"foo.m1().m2()", "(func-call m2((func-call m1(foo))))"},
 
 		{"fun f(ref a: int): int {return 1}", "(def-func f(ref a: int): int ((return 1)))"},
+		{"fun f(in p: Point, ref q: Point) {}", "(def-func f(in p: Point, ref q: Point): void ())"},
 
-		{"Math::cos()", "(func-call Math_cos())"},
+		{"Math::cos()", "(func-call Math::cos())"},
+		{"f(1)(2)", "(func-call (func-call f(1))(2))"},
+		{"fs[0](x)", "(func-call (fs[0])(x))"},
+		{"Person::new(\"Bob\")", "(func-call Person::new(\"Bob\"))"},
+		{"f(1, y: 2, z: x + 1)", "(func-call f(1, y: 2, z: (x + 1)))"},
+		{"sum(1, xs...)", "(func-call sum(1, xs...))"},
+		{"comptime fib(20) + 1", "((comptime (func-call fib(20))) + 1)"},
+		{"p.move(dx: 1)", "(func-call move(p, dx: 1))"},
+
+		{"*p", "(*p)"},
+		{"&a", "(&a)"},
+		{"*p = 1", "((*p) = 1)"},
+		{"**p", "(*(*p))"},
+		{"!a && b || c == d", "(((!a) && b) || (c == d))"},
+		{"a || b && !c", "(a || (b && (!c)))"},
+		{"p == nil", "(p == nil)"},
+		{"delete p", "(delete p)"},
+		{"defer f(x)", "(defer (func-call f(x)))"},
+		{"sizeof([4]*int) + alignof(Pair<int, float>)", "((sizeof [4]*int) + (alignof Pair<int, float>))"},
+		{"offsetof(Point, y)", "(offsetof Point y)"},
+		{"static_assert(N > 0, \"N must be positive\")", "(static_assert (N > 0) \"N must be positive\")"},
+		{"var f = fun (x: int): int { return x + k }", "(var f = (fun (x: int): int ((return (x + k)))))"},
+		{"apply(xs, fun [k, n](x: int) {})", "(func-call apply(xs, (fun [k, n](x: int): void ())))"},
+		{"fun (){}()", "(func-call (fun (): void ())())"},
+		{"fun helper(x: int): int { return x }", "(def-func helper(x: int): int ((return x)))"},
+		{"fun f(p: *int): *int {return p}", "(def-func f(p: *int): *int ((return p)))"},
 	}
 
 	for i, test := range tests {
@@ -130,6 +192,58 @@ func TestParseStatement(t *testing.T) {
 	}
 }
 
+func TestParseWhen(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"when os == \"linux\" && debug { fun a() {} }", "(def-func a(): void ())"},
+		{"when os != \"linux\" || !debug { fun a() {} } else { fun b() {} }", "(def-func b(): void ())"},
+		{"when level >= 2 { var V = 1 }", "(var V = 1)"},
+		{"when feature { fun a() {} } else when !feature { fun b() {} }", "(def-func b(): void ())"},
+	}
+
+	for i, test := range tests {
+		l := lexer.NewFromString(test.input)
+		p := New(l)
+		p.Defines["os"] = "linux"
+		p.Defines["debug"] = true
+		p.Defines["level"] = 2
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		actual := ast.Show(program)
+
+		if actual != test.expected {
+			t.Fatalf("tests[%d] - expected=%q, got=%q", i, test.expected, actual)
+		}
+	}
+}
+
+func TestParseMacro(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"macro sq(x: expr) { x * x } fun f(): int { return sq!(1 + 2) }", "(def-func f(): int ((return ((1 + 2) * (1 + 2)))))"},
+		{"macro swap(a: ident, b: ident) { val tmp = a a = b b = tmp } fun f() { var tmp = 1 var x = 2 swap!(tmp, x) }", "(def-func f(): void ((var tmp = 1)(var x = 2)(var tmp#1 = tmp)(tmp = x)(x = tmp#1)))"},
+		{"macro getter(name: ident, T: type) { fun name(v: T): T { return v } } getter!(id, int) getter!(idp, *int)", "(def-func id(v#1: int): int ((return v#1)))(def-func idp(v#2: *int): *int ((return v#2)))"},
+		{"macro loop(n: expr, body: expr) { for var i = 0; i < n; i += 1 { body } } fun f(i: int) { loop!(3, g(i)) }", "(def-func f(i: int): void ((for (var i#1 = 0); (i#1 < 3); (i#1 = (i#1 + 1))((func-call g(i))))))"},
+		{"when false { macro m() { fun a() {} } m!() } fun b() {}", "(def-func b(): void ())"},
+	}
+
+	for i, test := range tests {
+		l := lexer.NewFromString(test.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		actual := ast.Show(program)
+
+		if actual != test.expected {
+			t.Fatalf("tests[%d] - expected=%q, got=%q", i, test.expected, actual)
+		}
+	}
+}
+
 func checkParserErrors(t *testing.T, p *Parser) {
 	if len(p.Errors) == 0 {
 		return
@@ -11,8 +11,14 @@ func (p *Parser) parseTopLevelStatement() ast.Statement {
 	switch p.curToken.Type {
 	case token.FUNCTION:
 		return p.parseFunctionStatement()
+	case token.CONST:
+		return p.parseConstFunctionStatement()
 	case token.STRUCT:
 		return p.parseStructStatement()
+	case token.INTERFACE:
+		return p.parseInterfaceStatement()
+	case token.TYPE:
+		return p.parseTypeStatement()
 	case token.VAR, token.VAL:
 		return p.parseVarStatement()
 	case token.IMPORT:
@@ -21,6 +27,10 @@ func (p *Parser) parseTopLevelStatement() ast.Statement {
 		return p.parseIncludeStatement()
 	case token.MODULE:
 		return p.parseModuleStatement()
+	case token.STATIC_ASSERT:
+		return p.parseStaticAssertStatement()
+	case token.AT:
+		return p.parseAttributedStatement()
 	}
 
 	p.error(fmt.Sprintf("%s | unexpected token '%s'", p.curToken.Pos, p.curToken.Literal))
@@ -30,17 +40,33 @@ func (p *Parser) parseTopLevelStatement() ast.Statement {
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
 	case token.VAR, token.VAL:
-		return p.parseVarStatement()
+		stmt := p.parseVarStatement()
+		if stmt != nil {
+			p.bind(stmt.Ident)
+		}
+		return stmt
 	case token.RETURN:
 		return p.parseReturnStatement()
 	case token.FUNCTION:
+		if !p.peekTokenIs(token.IDENT) {
+			// function literal
+			return p.parseExpressionStatement()
+		}
 		return p.parseFunctionStatement()
 	case token.IF:
 		return p.parseIfStatement()
+	case token.LBRACE:
+		return p.parseBlockStatement()
 	case token.WHILE:
 		return p.parseWhileStatement()
 	case token.FOR:
 		return p.parseFor()
+	case token.DELETE:
+		return p.parseDeleteStatement()
+	case token.DEFER:
+		return p.parseDeferStatement()
+	case token.STATIC_ASSERT:
+		return p.parseStaticAssertStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -85,6 +111,11 @@ func (p *Parser) parseStructStatement() *ast.StructStatement {
 	}
 	stmt.Ident = p.parseIdentifier()
 
+	if p.peekTokenIs(token.LT) {
+		p.nextToken()
+		stmt.TypeParams = p.parseTypeParameters()
+	}
+
 	if !p.peekTokenIs(token.LBRACE) {
 		stmt.IsIncomplete = true
 		return stmt
@@ -92,7 +123,13 @@ func (p *Parser) parseStructStatement() *ast.StructStatement {
 	p.nextToken()
 	stmt.LBrace = p.curPos
 
-	for p.peekTokenIs(token.IDENT) {
+	for p.peekTokenIs(token.IDENT) || p.peekTokenIs(token.FUNCTION) {
+		if p.peekTokenIs(token.FUNCTION) {
+			p.nextToken()
+			stmt.Functions = append(stmt.Functions, p.parseFunctionStatement())
+			continue
+		}
+
 		m := &ast.MemberDecl{}
 
 		if !p.expectPeek(token.IDENT) {
@@ -117,6 +154,65 @@ func (p *Parser) parseStructStatement() *ast.StructStatement {
 	return stmt
 }
 
+func (p *Parser) parseInterfaceStatement() *ast.InterfaceStatement {
+	stmt := &ast.InterfaceStatement{
+		Interface: p.curPos,
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Ident = p.parseIdentifier()
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.LBrace = p.curPos
+
+	for p.peekTokenIs(token.FUNCTION) {
+		p.nextToken()
+		m := p.parseFunctionStatement()
+		if m == nil {
+			return nil
+		}
+		if m.Body != nil {
+			p.error(fmt.Sprintf("%s | interface method '%s' cannot have a body", m.Func, m.Ident.Name))
+		}
+		stmt.Methods = append(stmt.Methods, m)
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	stmt.RBrace = p.curPos
+
+	return stmt
+}
+
+func (p *Parser) parseTypeStatement() *ast.TypeStatement {
+	stmt := &ast.TypeStatement{
+		TypePos: p.curPos,
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Ident = p.parseIdentifier()
+
+	if p.peekTokenIs(token.ASSIGN) {
+		p.nextToken()
+		stmt.IsAlias = true
+	}
+
+	p.nextToken()
+	stmt.Target = p.parseType()
+	if stmt.Target == nil {
+		return nil
+	}
+
+	return stmt
+}
+
 // TODO rewrite
 func (p *Parser) parseImportStatement() *ast.ImportStatement {
 	stmt := &ast.ImportStatement{Import: p.curPos}
@@ -210,6 +306,81 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseDeleteStatement() *ast.DeleteStatement {
+	stmt := &ast.DeleteStatement{Delete: p.curPos}
+
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseStaticAssertStatement() *ast.StaticAssertStatement {
+	stmt := &ast.StaticAssertStatement{StaticAssert: p.curPos}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.COMMA) {
+		return nil
+	}
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	stmt.Message = p.parseStringLiteral()
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseDeferStatement() *ast.DeferStatement {
+	stmt := &ast.DeferStatement{Defer: p.curPos}
+
+	p.nextToken()
+	expr := p.parseExpression(LOWEST)
+	call, ok := expr.(*ast.CallExpression)
+	if !ok {
+		p.error(fmt.Sprintf("%s | expression in defer must be function call", stmt.Defer))
+	}
+	stmt.Call = call
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseConstFunctionStatement() *ast.FunctionStatement {
+	pos := p.curPos
+	if !p.expectPeek(token.FUNCTION) {
+		return nil
+	}
+
+	stmt := p.parseFunctionStatement()
+	if stmt == nil {
+		return nil
+	}
+	stmt.Func = pos
+	stmt.IsConst = true
+
+	return stmt
+}
+
 func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
 	stmt := &ast.FunctionStatement{
 		Func: p.curPos,
@@ -219,11 +390,31 @@ func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
 	p.nextToken()
 	stmt.Ident = p.parseIdentifier()
 
+	if p.peekTokenIs(token.PERIOD) {
+		p.nextToken()
+		if !p.expectMethodName() {
+			return nil
+		}
+		stmt.Receiver = stmt.Ident
+		stmt.Ident = p.parseIdentifier()
+
+		if stmt.Ident.Name == "operator" && !p.peekTokenIs(token.LPAREN) {
+			if !p.parseOperatorName(stmt.Ident) {
+				return nil
+			}
+		}
+	}
+
+	if p.peekTokenIs(token.LT) {
+		p.nextToken()
+		stmt.TypeParams = p.parseTypeParameters()
+	}
+
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
 
-	stmt.Sig.Params = p.parseFunctionParameters()
+	stmt.Sig.Params = p.parseFunctionParameters(stmt.Receiver)
 
 	retType := &ast.Type{
 		Name: "void",
@@ -247,68 +438,66 @@ func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
 	return stmt
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Param {
-	var params []*ast.Param
+// parseOperatorName parses the operator following 'operator' and appends it
+// to the name of ident, like 'operator+' and 'operator[]'.
+func (p *Parser) parseOperatorName(ident *ast.Identifier) bool {
+	p.nextToken()
 
-	if p.peekTokenIs(token.RPAREN) {
-		p.nextToken()
-		return params
+	switch p.curToken.Type {
+	case token.ADD, token.SUB, token.MUL, token.QUO, token.REM, token.EQ, token.LT:
+		ident.Name += p.curLiteral
+	case token.LBRACKET:
+		if !p.expectPeek(token.RBRACKET) {
+			return false
+		}
+		ident.Name += "[]"
+	default:
+		// keep parsing the declaration to avoid cascading errors
+		p.error(fmt.Sprintf("%s | cannot overload operator '%s'", p.curPos, p.curLiteral))
+		ident.Name += p.curLiteral
 	}
 
-	isReference := p.peekTokenIs(token.REF)
-	if isReference {
-		p.nextToken()
-	}
-	p.nextToken()
+	return true
+}
 
-	var curTyp *ast.Type
+// parseFunctionParameters parses the parameters of a function. The type of
+// 'self' can be omitted in methods of receiver.
+func (p *Parser) parseFunctionParameters(receiver *ast.Identifier) []*ast.Param {
+	var params []*ast.Param
 
-	ident := p.parseIdentifier()
-	if p.peekTokenIs(token.COLON) {
-		p.nextToken()
+	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		curTyp = p.parseType()
+		return params
 	}
 
-	params = append(params, &ast.Param{
-		Ident:       ident,
-		Type:        curTyp,
-		IsReference: isReference,
-	})
+	param := p.parseFunctionParameter()
+	if param.Type == nil && receiver != nil && param.Ident.Name == "self" {
+		param.Type = &ast.Type{NamePos: param.Ident.Pos, Name: receiver.Name}
+	}
+	params = append(params, param)
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
-		isReference = p.peekTokenIs(token.REF)
-		if isReference {
-			p.nextToken()
-		}
-		p.nextToken()
-		ident = p.parseIdentifier()
-
-		curTyp = nil
-		if p.peekTokenIs(token.COLON) {
-			p.nextToken()
-			p.nextToken()
-			curTyp = p.parseType()
-		}
-
-		params = append(params, &ast.Param{
-			Ident:       ident,
-			Type:        curTyp,
-			IsReference: isReference,
-		})
+		params = append(params, p.parseFunctionParameter())
 	}
 
 	if !p.expectPeek(token.RPAREN) {
 		return nil
 	}
 
-	if curTyp == nil {
+	if params[len(params)-1].Type == nil {
 		p.error(fmt.Sprintf("%s | type specification is needed", p.curPos))
 		return nil
 	}
 
+	for _, param := range params[:len(params)-1] {
+		if param.IsVariadic {
+			p.error(fmt.Sprintf("%s | only the last parameter can be variadic", param.Ident.Pos))
+		}
+	}
+
 	// resolve types
+	var curTyp *ast.Type
 	for i := len(params) - 1; i >= 0; i-- {
 		if params[i].Type != nil {
 			curTyp = params[i].Type
@@ -320,6 +509,40 @@ func (p *Parser) parseFunctionParameters() []*ast.Param {
 	return params
 }
 
+// parseFunctionParameter parses a parameter like 'ref x: int = 0' or
+// 'in x: int'. The type may be omitted to share the type of the following
+// parameter.
+func (p *Parser) parseFunctionParameter() *ast.Param {
+	param := &ast.Param{}
+
+	param.IsReadOnly = p.peekTokenIs(token.IN)
+	param.IsReference = param.IsReadOnly || p.peekTokenIs(token.REF)
+	if param.IsReference {
+		p.nextToken()
+	}
+	p.nextToken()
+	param.Ident = p.parseIdentifier()
+	p.bind(param.Ident)
+
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		if p.peekTokenIs(token.ELLIPSIS) {
+			p.nextToken()
+			param.IsVariadic = true
+		}
+		p.nextToken()
+		param.Type = p.parseType()
+	}
+
+	if p.peekTokenIs(token.ASSIGN) {
+		p.nextToken()
+		p.nextToken()
+		param.Default = p.parseExpression(LOWEST)
+	}
+
+	return param
+}
+
 func (p *Parser) parseIfStatement() *ast.IfStatement {
 	stmt := &ast.IfStatement{If: p.curPos}
 
@@ -373,7 +596,7 @@ func (p *Parser) parseFor() ast.Statement {
 	p.nextToken()
 
 	var stmt ast.Statement
-	if p.peekTokenIs(token.IN) {
+	if p.peekTokenIs(token.IN) || p.peekTokenIs(token.COMMA) {
 		stmt = p.parseForRangeStatement(pos)
 	} else {
 		stmt = p.parseForStatement(pos)
@@ -406,10 +629,23 @@ func (p *Parser) parseForStatement(pos token.Position) *ast.ForStatement {
 	return stmt
 }
 
-func (p *Parser) parseForRangeStatement(pos token.Position) *ast.ForRangeStatement {
+// parseForRangeStatement parses 'for i in 0..<10 step 2 { ... }', or
+// 'for i, x in xs { ... }' if the range is not given.
+func (p *Parser) parseForRangeStatement(pos token.Position) ast.Statement {
 	stmt := &ast.ForRangeStatement{For: pos}
 
+	var index *ast.Identifier
+	if p.peekTokenIs(token.COMMA) {
+		index = p.parseIdentifier()
+		p.bind(index)
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+	}
+
 	stmt.VarName = p.parseIdentifier()
+	p.bind(stmt.VarName)
 
 	if !p.expectPeek(token.IN) {
 		return nil
@@ -419,14 +655,50 @@ func (p *Parser) parseForRangeStatement(pos token.Position) *ast.ForRangeStateme
 
 	stmt.From = p.parseExpression(LOWEST)
 
-	if !p.expectPeek(token.RANGE) {
-		return nil
+	if !p.peekTokenIs(token.RANGE) {
+		return p.parseForInStatement(stmt, index)
+	}
+	if index != nil {
+		p.error(fmt.Sprintf("%s | cannot use an index variable with a range", index.Pos))
+	}
+	p.nextToken()
+
+	// '..<' excludes the end
+	if p.peekTokenIs(token.LT) {
+		p.nextToken()
+		stmt.Exclusive = true
 	}
 	p.nextToken()
 
 	stmt.To = p.parseExpression(LOWEST)
 
-	p.nextToken()
+	// 'step' is not a keyword so that it can still be used as a name
+	if p.peekTokenIs(token.IDENT) && p.peekToken.Literal == "step" {
+		p.nextToken()
+		p.nextToken()
+		stmt.Step = p.parseExpression(LOWEST)
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+
+	return stmt
+}
+
+func (p *Parser) parseForInStatement(rangeStmt *ast.ForRangeStatement, index *ast.Identifier) ast.Statement {
+	stmt := &ast.ForInStatement{
+		For:       rangeStmt.For,
+		IndexName: index,
+		VarName:   rangeStmt.VarName,
+		In:        rangeStmt.In,
+		Value:     rangeStmt.From,
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
 	stmt.Body = p.parseBlockStatement()
 
 	return stmt
@@ -449,8 +721,9 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 
 	p.nextToken()
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
-		if stmt != nil {
+		if p.isMacroCall() {
+			block.Statements = append(block.Statements, p.expandStatements()...)
+		} else if stmt := p.parseStatement(); stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
 		p.nextToken()
@@ -462,19 +735,115 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 }
 
 func (p *Parser) parseType() *ast.Type {
-	typ := &ast.Type{}
+	typ := &ast.Type{NamePos: p.curPos}
 
-	if p.curTokenIs(token.LBRACKET) {
+	switch p.curToken.Type {
+	case token.LBRACKET:
 		// 配列
 		typ.IsArray = true
 		p.nextToken()
-		length := p.parseIntegerLiteral().Value
-		typ.Len = uint64(length)
+		typ.Len = p.parseExpression(LOWEST)
 		p.expectPeek(token.RBRACKET)
 		p.nextToken()
+		typ.Elem = p.parseType()
+	case token.MUL:
+		// ポインタ
+		typ.IsPointer = true
+		p.nextToken()
+		typ.Elem = p.parseType()
+	case token.TYPEOF:
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+		p.nextToken()
+		typ.Typeof = p.parseExpression(LOWEST)
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+	case token.FUNCTION:
+		// 関数
+		typ.IsFunc = true
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+		for !p.peekTokenIs(token.RPAREN) {
+			p.nextToken()
+			typ.Params = append(typ.Params, p.parseType())
+			if !p.peekTokenIs(token.COMMA) {
+				break
+			}
+			p.nextToken()
+		}
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+
+		typ.RetType = &ast.Type{Name: "void"}
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+			p.nextToken()
+			typ.RetType = p.parseType()
+		}
+	default:
+		if arg, ok := p.expansion.arg(p.curLiteral).(*ast.Type); ok {
+			return arg
+		}
+		typ.Name = p.parseIdentifier().Name
+		if p.peekTokenIs(token.LT) {
+			p.nextToken()
+			typ.Args = p.parseTypeArguments()
+		}
 	}
 
-	typ.Name = p.curLiteral
-	typ.NamePos = p.curPos
 	return typ
 }
+
+// parseTypeParameters parses '<T, U>'.
+func (p *Parser) parseTypeParameters() []*ast.Identifier {
+	var params []*ast.Identifier
+
+	for {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		params = append(params, p.parseIdentifier())
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.GT) {
+		return nil
+	}
+
+	return params
+}
+
+// parseTypeArguments parses '<int, *Foo>'.
+func (p *Parser) parseTypeArguments() []*ast.Type {
+	var args []*ast.Type
+
+	for {
+		p.nextToken()
+		args = append(args, p.parseType())
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	// '>>' closes two type argument lists
+	if p.peekTokenIs(token.SHR) {
+		p.peekToken = token.Token{Type: token.GT, Literal: ">", Pos: p.peekToken.Pos}
+		return args
+	}
+
+	if !p.expectPeek(token.GT) {
+		return nil
+	}
+
+	return args
+}
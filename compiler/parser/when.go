@@ -0,0 +1,177 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/arata-nvm/visket/compiler/ast"
+	"github.com/arata-nvm/visket/compiler/token"
+)
+
+// parseWhen parses 'when cond { ... } else { ... }' and adds the statements of
+// the branch taken to program. The condition is evaluated with Defines, and the
+// statements of the other branches are parsed but thrown away.
+func (p *Parser) parseWhen(program *ast.Program, enabled bool) {
+	p.nextToken()
+	cond := p.parseExpression(LOWEST)
+
+	taken := false
+	if enabled {
+		taken, _ = p.evalWhenBool(cond)
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return
+	}
+	p.parseWhenBlock(program, enabled && taken)
+
+	if !p.peekTokenIs(token.ELSE) {
+		return
+	}
+	p.nextToken()
+	p.nextToken()
+
+	if p.curTokenIs(token.WHEN) {
+		p.parseWhen(program, enabled && !taken)
+		return
+	}
+
+	if !p.curTokenIs(token.LBRACE) {
+		p.error(fmt.Sprintf("%s | expected current token is %s, got %s instead", p.curPos, token.LBRACE, p.curToken.Type))
+		return
+	}
+	p.parseWhenBlock(program, enabled && !taken)
+}
+
+func (p *Parser) parseWhenBlock(program *ast.Program, enabled bool) {
+	p.nextToken()
+	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+		p.parseTopLevel(program, enabled)
+		p.nextToken()
+	}
+}
+
+func (p *Parser) evalWhenBool(expr ast.Expression) (bool, bool) {
+	v, ok := p.evalWhen(expr)
+	if !ok {
+		return false, false
+	}
+
+	b, ok := v.(bool)
+	if !ok {
+		p.error(fmt.Sprintf("%s | condition of when must be bool", whenPos(expr)))
+	}
+	return b, ok
+}
+
+// evalWhen evaluates the condition of 'when' into a bool, an int or a string.
+// Undefined names are false. It returns false if the condition has an error.
+func (p *Parser) evalWhen(expr ast.Expression) (interface{}, bool) {
+	switch expr := expr.(type) {
+	case *ast.IntegerLiteral:
+		return expr.Value, true
+	case *ast.StringLiteral:
+		return expr.Value, true
+	case *ast.Identifier:
+		if v, ok := p.Defines[expr.Name]; ok {
+			return v, true
+		}
+		switch expr.Name {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		}
+		// names not given by -D are false, so that 'when feature' is off by default
+		return false, true
+	case *ast.PrefixExpression:
+		if expr.Op == token.NOT {
+			b, ok := p.evalWhenBool(expr.Right)
+			return !b, ok
+		}
+	case *ast.InfixExpression:
+		if expr.Op == token.LAND || expr.Op == token.LOR {
+			lhs, ok := p.evalWhenBool(expr.Left)
+			if !ok || expr.Op == token.LAND && !lhs || expr.Op == token.LOR && lhs {
+				return lhs, ok
+			}
+			return p.evalWhenBool(expr.Right)
+		}
+
+		lhs, ok := p.evalWhen(expr.Left)
+		if !ok {
+			return nil, false
+		}
+		rhs, ok := p.evalWhen(expr.Right)
+		if !ok {
+			return nil, false
+		}
+
+		if v, ok := compareWhen(expr.Op, lhs, rhs); ok {
+			return v, true
+		}
+		p.error(fmt.Sprintf("%s | invalid operation in condition of when: %v %s %v", expr.OpPos, lhs, expr.Op, rhs))
+		return nil, false
+	}
+
+	p.error(fmt.Sprintf("%s | cannot evaluate '%s' in condition of when", whenPos(expr), ast.Show(expr)))
+	return nil, false
+}
+
+func compareWhen(op string, lhs, rhs interface{}) (bool, bool) {
+	if l, ok := lhs.(int); ok {
+		r, ok := rhs.(int)
+		if !ok {
+			return false, false
+		}
+
+		switch op {
+		case token.EQ:
+			return l == r, true
+		case token.NEQ:
+			return l != r, true
+		case token.LT:
+			return l < r, true
+		case token.LTE:
+			return l <= r, true
+		case token.GT:
+			return l > r, true
+		case token.GTE:
+			return l >= r, true
+		}
+		return false, false
+	}
+
+	switch lhs.(type) {
+	case bool:
+		if _, ok := rhs.(bool); !ok {
+			return false, false
+		}
+	case string:
+		if _, ok := rhs.(string); !ok {
+			return false, false
+		}
+	}
+
+	switch op {
+	case token.EQ:
+		return lhs == rhs, true
+	case token.NEQ:
+		return lhs != rhs, true
+	}
+	return false, false
+}
+
+func whenPos(expr ast.Expression) token.Position {
+	switch expr := expr.(type) {
+	case *ast.Identifier:
+		return expr.Pos
+	case *ast.IntegerLiteral:
+		return expr.Pos
+	case *ast.StringLiteral:
+		return expr.Token.Pos
+	case *ast.PrefixExpression:
+		return expr.OpPos
+	case *ast.InfixExpression:
+		return expr.OpPos
+	}
+	return token.Position{}
+}
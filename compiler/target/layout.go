@@ -0,0 +1,199 @@
+package target
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DataLayout holds the ABI alignments of an LLVM data layout, from which the
+// sizes and the alignments of types are derived like LLVM does.
+type DataLayout struct {
+	// PointerSize and PointerAlign are in bytes, like the other sizes
+	PointerSize  uint64
+	PointerAlign uint64
+	// IntAligns and FloatAligns map bit widths to alignments
+	IntAligns      map[uint64]uint64
+	FloatAligns    map[uint64]uint64
+	AggregateAlign uint64
+}
+
+// layouts are the data layouts of LLVM for the targets, which are selected by
+// the arch and the kind of the OS.
+var layouts = map[string]string{
+	"x86_64":          "e-m:e-p270:32:32-p271:32:32-p272:64:64-i64:64-f80:128-n8:16:32:64-S128",
+	"x86_64-darwin":   "e-m:o-p270:32:32-p271:32:32-p272:64:64-i64:64-f80:128-n8:16:32:64-S128",
+	"x86_64-windows":  "e-m:w-p270:32:32-p271:32:32-p272:64:64-i64:64-f80:128-n8:16:32:64-S128",
+	"x86":             "e-m:e-p:32:32-p270:32:32-p271:32:32-p272:64:64-f64:32:64-f80:32-n8:16:32-S128",
+	"x86-darwin":      "e-m:o-p:32:32-p270:32:32-p271:32:32-p272:64:64-f64:32:64-f80:128-n8:16:32-S128",
+	"x86-windows":     "e-m:x-p:32:32-p270:32:32-p271:32:32-p272:64:64-i64:64-f80:128-n8:16:32-a:0:32-S32",
+	"x86-windows-gnu": "e-m:x-p:32:32-p270:32:32-p271:32:32-p272:64:64-i64:64-f80:32-n8:16:32-a:0:32-S32",
+	"aarch64":         "e-m:e-i8:8:32-i16:16:32-i64:64-i128:128-n32:64-S128",
+	"aarch64-darwin":  "e-m:o-i64:64-i128:128-n32:64-S128",
+	"arm":             "e-m:e-p:32:32-Fi8-i64:64-v128:64:128-a:0:32-n32-S64",
+	"riscv32":         "e-m:e-p:32:32-i64:64-n32-S128",
+	"riscv64":         "e-m:e-p:64:64-i64:64-i128:128-n64-S128",
+	"wasm32":          "e-m:e-p:32:32-i64:64-n32:64-S128",
+	"wasm64":          "e-m:e-p:64:64-i64:64-n32:64-S128",
+}
+
+// Layout returns the data layout of the target triple. It reports false if
+// the layout of the target is unknown.
+func Layout(triple string) (*DataLayout, bool) {
+	t := Parse(triple)
+
+	var arch string
+	switch {
+	case t.Arch == "x86_64" || t.Arch == "amd64":
+		arch = "x86_64"
+	case len(t.Arch) == 4 && t.Arch[0] == 'i' && strings.HasSuffix(t.Arch, "86"):
+		arch = "x86"
+	case t.Arch == "aarch64" || t.Arch == "arm64":
+		arch = "aarch64"
+	case (strings.HasPrefix(t.Arch, "arm") || strings.HasPrefix(t.Arch, "thumb")) && !strings.HasSuffix(t.Arch, "eb"):
+		// the older ABI of 32-bit ARM on Darwin is not modeled
+		if t.isDarwin() {
+			return nil, false
+		}
+		arch = "arm"
+	default:
+		arch = t.Arch
+	}
+
+	var s string
+	ok := false
+	for _, key := range []string{arch + "-" + t.osKind() + "-" + t.Env, arch + "-" + t.osKind(), arch} {
+		if s, ok = layouts[key]; ok {
+			break
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+
+	l, err := ParseLayout(s)
+	if err != nil {
+		panic(err)
+	}
+	return l, true
+}
+
+func (t Triple) isDarwin() bool {
+	switch t.OS {
+	case "darwin", "macos", "macosx", "ios", "tvos", "watchos":
+		return true
+	}
+	return false
+}
+
+// osKind returns the kind of the OS that changes the data layout.
+func (t Triple) osKind() string {
+	if t.isDarwin() {
+		return "darwin"
+	}
+	if t.OS == "windows" {
+		return "windows"
+	}
+	return ""
+}
+
+// ParseLayout parses the specifications of an LLVM data layout string like
+// 'e-p:32:32-i64:64'. The ones not affecting the ABI alignments are ignored.
+func ParseLayout(s string) (*DataLayout, error) {
+	l := &DataLayout{
+		PointerSize:    8,
+		PointerAlign:   8,
+		IntAligns:      map[uint64]uint64{1: 1, 8: 1, 16: 2, 32: 4, 64: 4},
+		FloatAligns:    map[uint64]uint64{16: 2, 32: 4, 64: 8, 128: 16},
+		AggregateAlign: 1,
+	}
+
+	for _, spec := range strings.Split(s, "-") {
+		if spec == "" {
+			continue
+		}
+
+		fields := strings.Split(spec[1:], ":")
+		var nums []uint64
+		for _, f := range fields[1:] {
+			n, err := strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				// like 'm:e' or 'Fi8'
+				nums = nil
+				break
+			}
+			nums = append(nums, n)
+		}
+
+		switch spec[0] {
+		case 'p':
+			// only the default address space
+			if fields[0] != "" && fields[0] != "0" {
+				continue
+			}
+			if len(nums) < 2 {
+				return nil, fmt.Errorf("invalid data layout '%s'", spec)
+			}
+			l.PointerSize = nums[0] / 8
+			l.PointerAlign = nums[1] / 8
+		case 'i', 'f':
+			bits, err := strconv.ParseUint(fields[0], 10, 64)
+			if err != nil || len(nums) < 1 {
+				return nil, fmt.Errorf("invalid data layout '%s'", spec)
+			}
+			if spec[0] == 'i' {
+				l.IntAligns[bits] = nums[0] / 8
+			} else {
+				l.FloatAligns[bits] = nums[0] / 8
+			}
+		case 'a':
+			if len(nums) < 1 {
+				return nil, fmt.Errorf("invalid data layout '%s'", spec)
+			}
+			// an ABI alignment of 0 means 1
+			l.AggregateAlign = nums[0] / 8
+			if l.AggregateAlign == 0 {
+				l.AggregateAlign = 1
+			}
+		}
+	}
+
+	return l, nil
+}
+
+// IntAlign returns the alignment of an integer of bits. An integer without its
+// own alignment takes the one of the next wider integer, or the widest one.
+func (l *DataLayout) IntAlign(bits uint64) uint64 {
+	if a, ok := l.IntAligns[bits]; ok {
+		return a
+	}
+
+	best, widest := uint64(0), uint64(0)
+	for b := range l.IntAligns {
+		if b > bits && (best == 0 || b < best) {
+			best = b
+		}
+		if b > widest {
+			widest = b
+		}
+	}
+	if best == 0 {
+		best = widest
+	}
+	return l.IntAligns[best]
+}
+
+// FloatAlign returns the alignment of a floating-point number of bits. A
+// width without its own alignment is aligned to its size rounded up to a power
+// of two.
+func (l *DataLayout) FloatAlign(bits uint64) uint64 {
+	if a, ok := l.FloatAligns[bits]; ok {
+		return a
+	}
+
+	a := uint64(1)
+	for a < (bits+7)/8 {
+		a *= 2
+	}
+	return a
+}
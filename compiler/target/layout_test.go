@@ -0,0 +1,54 @@
+package target
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Triple
+	}{
+		{"x86_64-unknown-linux-gnu", Triple{"x86_64", "unknown", "linux", "gnu"}},
+		{"x86_64-linux-gnu", Triple{"x86_64", "", "linux", "gnu"}},
+		{"x86_64-apple-darwin19.0.0", Triple{"x86_64", "apple", "darwin", ""}},
+		{"aarch64-none-linux-gnu", Triple{"aarch64", "none", "linux", "gnu"}},
+		{"riscv32-unknown-elf", Triple{"riscv32", "unknown", "elf", ""}},
+		{"wasm32-wasi", Triple{"wasm32", "", "wasi", ""}},
+	}
+
+	for _, tt := range tests {
+		if actual := Parse(tt.input); actual != tt.expected {
+			t.Fatalf("%s: expected=%+v, got=%+v", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestLayout(t *testing.T) {
+	tests := []struct {
+		input        string
+		pointerSize  uint64
+		int64Align   uint64
+		float64Align uint64
+	}{
+		{"x86_64-pc-linux-gnu", 8, 8, 8},
+		{"i386-pc-linux-gnu", 4, 4, 4},
+		{"i686-pc-windows-msvc", 4, 8, 8},
+		{"armv7-linux-gnueabihf", 4, 8, 8},
+		{"wasm32-unknown-unknown", 4, 8, 8},
+	}
+
+	for _, tt := range tests {
+		l, ok := Layout(tt.input)
+		if !ok {
+			t.Fatalf("%s: unknown data layout", tt.input)
+		}
+		if l.PointerSize != tt.pointerSize || l.IntAlign(64) != tt.int64Align || l.FloatAlign(64) != tt.float64Align {
+			t.Fatalf("%s: expected=%d/%d/%d, got=%d/%d/%d", tt.input,
+				tt.pointerSize, tt.int64Align, tt.float64Align,
+				l.PointerSize, l.IntAlign(64), l.FloatAlign(64))
+		}
+	}
+
+	if _, ok := Layout("mips-unknown-linux-gnu"); ok {
+		t.Fatalf("mips-unknown-linux-gnu: expected an unknown data layout")
+	}
+}
@@ -0,0 +1,71 @@
+package target
+
+import (
+	"runtime"
+	"strings"
+)
+
+// Triple is a target triple like 'x86_64-unknown-linux-gnu'. The vendor can be
+// omitted before a known OS, like 'x86_64-linux-gnu'.
+type Triple struct {
+	Arch   string
+	Vendor string
+	// OS is the name of the OS without the version, like 'darwin' of 'darwin19.0.0'
+	OS  string
+	Env string
+}
+
+// knownOS lists the OS names that can follow the arch without a vendor.
+var knownOS = []string{
+	"linux", "darwin", "macos", "ios", "tvos", "watchos", "windows", "freebsd",
+	"netbsd", "openbsd", "dragonfly", "solaris", "haiku", "fuchsia", "wasi",
+	"emscripten", "none",
+}
+
+// Parse splits the target triple s into its fields.
+func Parse(s string) Triple {
+	parts := strings.Split(s, "-")
+	t := Triple{Arch: parts[0]}
+	parts = parts[1:]
+
+	// a triple of four fields always has the vendor, like 'aarch64-none-linux-gnu'
+	if len(parts) >= 3 || len(parts) >= 1 && !isOS(parts[0]) {
+		t.Vendor, parts = parts[0], parts[1:]
+	}
+	if len(parts) > 0 {
+		t.OS, parts = strings.TrimRight(parts[0], "0123456789."), parts[1:]
+	}
+	t.Env = strings.Join(parts, "-")
+
+	return t
+}
+
+func isOS(s string) bool {
+	for _, os := range knownOS {
+		if strings.HasPrefix(s, os) {
+			return true
+		}
+	}
+	return false
+}
+
+// Host returns the target triple of the host.
+func Host() string {
+	arch := runtime.GOARCH
+	switch arch {
+	case "amd64":
+		arch = "x86_64"
+	case "arm64":
+		arch = "aarch64"
+	case "386":
+		arch = "i386"
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		return arch + "-apple-darwin"
+	case "windows":
+		return arch + "-pc-windows-msvc"
+	}
+	return arch + "-unknown-" + runtime.GOOS + "-gnu"
+}
@@ -5,8 +5,22 @@ import "fmt"
 type Position struct {
 	Filename string
 	Line     int
+
+	// Expansion is set for the tokens in the body of a macro, and points to
+	// the call of the macro.
+	Expansion *Expansion
+}
+
+type Expansion struct {
+	Macro string
+	Pos   Position
 }
 
 func (p Position) String() string {
-	return fmt.Sprintf("%s:%d", p.Filename, p.Line)
+	pos := fmt.Sprintf("%s:%d", p.Filename, p.Line)
+	if p.Expansion != nil {
+		// the call site is shown as a line of its own like the other contexts
+		return fmt.Sprintf("%s | in expansion of macro '%s'\n%s", p.Expansion.Pos, p.Expansion.Macro, pos)
+	}
+	return pos
 }
@@ -22,8 +22,16 @@ const (
 	SHL = "<<"
 	SHR = ">>"
 
-	RANGE  = ".."
-	MODSEP = "::"
+	AND = "&"
+	AT  = "@"
+
+	LAND = "&&"
+	LOR  = "||"
+	NOT  = "!"
+
+	RANGE    = ".."
+	ELLIPSIS = "..."
+	MODSEP   = "::"
 
 	ADD_ASSIGN = "+="
 	SUB_ASSIGN = "-="
@@ -55,39 +63,69 @@ const (
 	LBRACKET = "["
 	RBRACKET = "]"
 
-	VAR      = "var"
-	RETURN   = "return"
-	FUNCTION = "fun"
-	IF       = "if"
-	ELSE     = "else"
-	WHILE    = "while"
-	FOR      = "for"
-	IN       = "in"
-	STRUCT   = "struct"
-	NEW      = "new"
-	IMPORT   = "import"
-	REF      = "ref"
-	VAL      = "val"
-	MODULE   = "module"
-	INCLUDE  = "include"
+	VAR       = "var"
+	RETURN    = "return"
+	FUNCTION  = "fun"
+	IF        = "if"
+	ELSE      = "else"
+	WHILE     = "while"
+	FOR       = "for"
+	IN        = "in"
+	STRUCT    = "struct"
+	NEW       = "new"
+	IMPORT    = "import"
+	REF       = "ref"
+	VAL       = "val"
+	MODULE    = "module"
+	INCLUDE   = "include"
+	DELETE    = "delete"
+	NIL       = "nil"
+	DEFER     = "defer"
+	INTERFACE = "interface"
+	TYPE      = "type"
+	CONST     = "const"
+	COMPTIME  = "comptime"
+
+	SIZEOF        = "sizeof"
+	ALIGNOF       = "alignof"
+	OFFSETOF      = "offsetof"
+	TYPEOF        = "typeof"
+	STATIC_ASSERT = "static_assert"
+	WHEN          = "when"
+	MACRO         = "macro"
 )
 
 var keywords = map[string]TokenType{
-	"var":     VAR,
-	"return":  RETURN,
-	"fun":     FUNCTION,
-	"if":      IF,
-	"else":    ELSE,
-	"while":   WHILE,
-	"for":     FOR,
-	"in":      IN,
-	"struct":  STRUCT,
-	"new":     NEW,
-	"import":  IMPORT,
-	"ref":     REF,
-	"val":     VAL,
-	"module":  MODULE,
-	"include": INCLUDE,
+	"var":       VAR,
+	"return":    RETURN,
+	"fun":       FUNCTION,
+	"if":        IF,
+	"else":      ELSE,
+	"while":     WHILE,
+	"for":       FOR,
+	"in":        IN,
+	"struct":    STRUCT,
+	"new":       NEW,
+	"import":    IMPORT,
+	"ref":       REF,
+	"val":       VAL,
+	"module":    MODULE,
+	"include":   INCLUDE,
+	"delete":    DELETE,
+	"nil":       NIL,
+	"defer":     DEFER,
+	"interface": INTERFACE,
+	"type":      TYPE,
+	"const":     CONST,
+	"comptime":  COMPTIME,
+
+	"sizeof":        SIZEOF,
+	"alignof":       ALIGNOF,
+	"offsetof":      OFFSETOF,
+	"typeof":        TYPEOF,
+	"static_assert": STATIC_ASSERT,
+	"when":          WHEN,
+	"macro":         MACRO,
 }
 
 type Token struct {
@@ -24,6 +24,36 @@ try() {
   fi
 }
 
+try_opt() {
+  tmp_opt="$OPT"
+  OPT="$OPT $1"
+  shift
+  try "$@"
+  OPT="$tmp_opt"
+}
+
+try_memcheck() {
+  expected="$1"
+  opt="$2"
+  input="$3"
+
+  echo 'import "lib/std"' > tmp.sl
+  echo "$input" >> tmp.sl
+  cat tmp.sl
+  $TARGET $OPT $opt -memcheck -o tmp tmp.sl > /dev/null
+  if [ "$?" != "0" ]; then
+    exit 1
+  fi
+  actual=`./tmp 2>&1 > /dev/null`
+
+  if [ "$actual" == "$expected" ]; then
+    echo "=> $actual"
+  else
+    echo "=> $expected expected, but got $actual"
+    exit 1
+  fi
+}
+
 try 0 "fun main() { printi(0) }"
 try 42 "fun main() { printi(42) }"
 
@@ -351,7 +381,7 @@ try 20 \
   X: int
 }
 struct Bar {
-  A: Foo
+  A: *Foo
 }
 fun main() {
   var bar = new Bar
@@ -438,6 +468,25 @@ try 9 \
 }
 fun main() { printi(Lib::test()) }"
 
+try "$(printf "%s\n" 5 7 2 1)" \
+"struct Point {
+  x: int
+}
+fun Point.len(self): int { return self.x }
+fun Point_len(p: Point): int { return 7 }
+module Lib {
+  fun test(): int { return 2 }
+}
+fun Lib_test(): int { return 1 }
+fun main() {
+  var p: Point
+  p.x = 5
+  printi(p.len())
+  printi(Point_len(p))
+  printi(Lib::test())
+  printi(Lib_test())
+}"
+
 try 4 \
 "module Lib {
   fun test(): int { return 3 }
@@ -457,5 +506,1538 @@ try 1 \
   printi(0)
 }"
 
+try 7 \
+"fun main() {
+  var i = 3
+  var p = &i
+  *p = 7;
+  printi(i)
+}"
+
+try 5 \
+"fun set(p: *int, v: int) {
+  *p = v
+}
+fun main() {
+  var i: int
+  set(&i, 5)
+  printi(*p2(&i))
+}
+fun p2(p: *int): *int { return p }"
+
+try 6 \
+"struct Node {
+  value: int
+  left: *Node
+  right: *Node
+}
+fun insert(node: *Node, value: int): *Node {
+  if node == nil {
+    var n = new Node
+    n.value = value
+    return n
+  }
+  if value < node.value {
+    node.left = insert(node.left, value)
+  } else {
+    node.right = insert(node.right, value)
+  }
+  return node
+}
+fun sum(node: *Node): int {
+  if node == nil {
+    return 0
+  }
+  return node.value + sum(node.left) + sum(node.right)
+}
+fun freeTree(node: *Node) {
+  if node != nil {
+    freeTree(node.left)
+    freeTree(node.right)
+    delete node
+  }
+}
+fun main() {
+  var root: *Node = nil
+  for i in 1..3 {
+    root = insert(root, i)
+  }
+  printi(sum(root))
+  freeTree(root)
+}"
+
+try 4950 \
+"struct Foo {
+  X: int
+}
+fun main() {
+  var sum = 0
+  for i in 0..99 {
+    var foo = new Foo
+    foo.X = i
+    sum += foo.X
+    delete foo
+  }
+  printi(sum)
+}"
+
+try "100
+5
+1" \
+"fun f(n: int): int {
+  defer printi(n)
+  n = 100
+  defer printi(n)
+  if n == 100 {
+    var i = 0
+    while i < 3 {
+      if i == 1 {
+        return i
+      }
+      i += 1
+    }
+  }
+  return 0
+}
+fun main() {
+  printi(f(5))
+}"
+
+try "8
+7
+8" \
+"fun g(flag: bool) {
+  if flag {
+    defer printi(7)
+  }
+  printi(8)
+}
+fun main() {
+  g(true)
+  g(false)
+}"
+
+try "$(printf "%s\n" 9 10 0 11 1 12 20 0 21 1 2 -1 3)" \
+"fun f(): int {
+  defer printi(-1)
+  for i in 0..2 {
+    defer printi(i)
+    printi(10 + i)
+    if i == 2 {
+      for j in 0..1 {
+        defer printi(j)
+        printi(20 + j)
+        if j == 1 {
+          return 3
+        }
+      }
+    }
+  }
+  return 0
+}
+fun main() {
+  var n = 0
+  while n < 2 {
+    n += 1
+    if n == 2 {
+      defer printi(9)
+    }
+  }
+  printi(f())
+}"
+
+try 3 \
+"fun main() {
+  if true {
+    var i = 0
+    while i < 3 { i += 1 }
+    printi(i)
+  }
+}"
+
+try "$(printf "%s\n" 103 3 2 10 11 20 1 5 5 0 30 31 4 99)" \
+"struct Res {
+  id: int
+  fun drop(ref self: Res) {
+    printi(self.id)
+  }
+}
+struct Pair {
+  a: Res
+  b: Res
+}
+fun make(id: int): Res {
+  var r: Res
+  r.id = id
+  return r
+}
+fun consume(r: Res) {
+  printi(100 + r.id)
+}
+var g = make(99)
+fun main() {
+  var a = make(1)
+  if true {
+    var b = make(2)
+    var c = make(3)
+    consume(c)
+  }
+  var i = 0
+  while i < 2 {
+    var l = make(10 + i)
+    i += 1
+  }
+  make(20)
+  var p: Pair
+  p.a = make(30)
+  p.b = make(31)
+  var q = p
+  a = make(4)
+  a = a
+  printi(make(5).id)
+  printi(0)
+}"
+
+try "$(printf "%s\n" 5 100 1 0 7 6)" \
+"struct Res {
+  id: int
+  fun drop(ref self: Res) {
+    printi(self.id)
+  }
+}
+struct Pair {
+  a: Res
+  b: Res
+}
+fun make(id: int): Res {
+  var r: Res
+  r.id = id
+  return r
+}
+fun take(r: Res) {}
+fun main() {
+  var unused: Res
+  var p: Pair
+  p.a = make(5)
+  p.a = make(6)
+  var rs: [3]Res
+  var i = 1
+  rs[i] = make(7)
+  printi(100)
+  var r = make(1)
+  take(r)
+  r = make(0)
+}"
+
+try 7 \
+"struct Res {
+  id: int
+  fun drop(ref self: Res) {
+    printi(self.id)
+  }
+}
+fun main() {
+  var p = new Res
+  p.id = 7
+  delete p
+}"
+
+try "$(printf "%s\n" 7 3 1 2 1)" \
+"fun max<T>(a, b: T): T {
+  if a > b {
+    return a
+  }
+  return b
+}
+fun min<T>(a, b: T): T {
+  if a < b {
+    return a
+  }
+  return b
+}
+fun swap<T>(ref a, ref b: T) {
+  val tmp = a
+  a = b
+  b = tmp
+}
+fun main() {
+  printi(max(3, 7))
+  printi(min(3, 7))
+  if max(2.5, 1.5) == 2.5 {
+    printi(1)
+  }
+  var a = 1
+  var b = 2
+  swap(a, b)
+  printi(a)
+  printi(b)
+}"
+
+try "$(printf "%s\n" 42 5 3 -1)" \
+"struct Pair<A, B> {
+  first: A
+  second: B
+}
+struct List<T> {
+  value: T
+  next: *List<T>
+}
+struct Box<T> {
+  value: T
+  fun drop(ref self: Box<T>) {
+    printi(-1)
+  }
+}
+fun first<A, B>(p: Pair<A, B>): A {
+  return p.first
+}
+fun push<T>(l: *List<T>, v: T): *List<T> {
+  var n = new List<T>
+  n.value = v
+  n.next = l
+  return n
+}
+fun sum<T>(l: *List<T>): T {
+  var zero: T
+  if l == nil {
+    return zero
+  }
+  return l.value + sum(l.next)
+}
+fun main() {
+  var p: Pair<int, Pair<int, int>>
+  p.first = 42
+  p.second.first = 5
+  printi(first(p))
+  printi(first(p.second))
+  var l: *List<int> = nil
+  l = push(l, 1)
+  l = l.push(2)
+  printi(sum(l))
+  var box: Box<int>
+  box.value = 1
+}"
+
+try "$(printf "%s\n" 6 12 12 4 25 7)" \
+"interface Shape {
+  fun area(): int
+  fun scale(k: int)
+}
+interface Named {
+  fun id(): int
+}
+struct Rect {
+  w: int
+  h: int
+}
+struct Circle {
+  r: int
+}
+fun area(ref r: Rect): int {
+  return r.w * r.h
+}
+fun scale(ref r: Rect, k: int) {
+  r.w *= k
+}
+fun id(c: Circle): int {
+  return c.r
+}
+fun show(s: Shape) {
+  printi(s.area())
+}
+fun main() {
+  var r: Rect
+  r.w = 2
+  r.h = 3
+  var s: Shape = r
+  show(s)
+  s.scale(2)
+  show(s)
+  show(r)
+  printi(r.w)
+  var p = new Rect
+  p.w = 5
+  p.h = 5
+  show(p)
+  var c: Circle
+  c.r = 7
+  var n: Named = c
+  printi(n.id())
+  var none: Shape = nil
+}"
+
+try_opt "-arc" "$(printf "%s\n" 10 6 7)" \
+"interface Shape {
+  fun area(): int
+}
+struct Rect {
+  w: int
+  h: int
+}
+fun Rect.area(ref self): int {
+  return self.w * self.h
+}
+fun mk(w: int): Shape {
+  var r: Rect
+  r.w = w
+  r.h = 2
+  return r
+}
+fun mkp(): Shape {
+  var r = new Rect
+  r.w = 7
+  r.h = 1
+  return r
+}
+fun main() {
+  printi(mk(5).area())
+  var shapes: [2]Shape
+  for i in 0..1 {
+    var r: Rect
+    r.w = i + 1
+    r.h = 2
+    shapes[i] = r
+  }
+  printi(shapes[0].area() + shapes[1].area())
+  val s = mkp()
+  var o = new Rect
+  o.w = 1234
+  printi(s.area())
+}"
+
+try "$(printf "%s\n" Alice 42 -1 42 1 19)" \
+"interface Shape {
+  fun area(): int
+}
+struct Person {
+  name: string
+  age: int
+}
+fun Person.new(name: string, age: int): Person {
+  var p: Person
+  p.name = name
+  p.age = age
+  return p
+}
+fun Person.rename(ref self, name: string) {
+  self.name = name
+}
+fun Person.birthday(ref self) {
+  self.age += 1
+}
+fun Person.getAge(self): int {
+  return self.age
+}
+fun getAge(p: Person): int {
+  return -1
+}
+struct Square {
+  side: int
+}
+struct Rect {
+  w: int
+  h: int
+}
+fun Square.area(self): int {
+  return self.side * self.side
+}
+fun Rect.area(ref self): int {
+  return self.w * self.h
+}
+fun total(a, b: Shape): int {
+  return a.area() + b.area()
+}
+fun main() {
+  var p = Person::new(\"Bob\", 41)
+  p.rename(\"Alice\")
+  println(p.name)
+  p.birthday()
+  printi(p.getAge())
+  printi(getAge(p))
+  printi(Person::getAge(p))
+  var q = new Person
+  q.birthday()
+  printi(q.getAge())
+  var s: Square
+  s.side = 3
+  var r: Rect
+  r.w = 2
+  r.h = 5
+  printi(total(s, r))
+}"
+
+try "$(printf "%s\n" -4 12 1 8 8 2 2)" \
+"struct Complex {
+  re: int
+  im: int
+}
+fun Complex.new(re, im: int): Complex {
+  var c: Complex
+  c.re = re
+  c.im = im
+  return c
+}
+fun Complex.operator+(self, o: Complex): Complex {
+  return Complex::new(self.re + o.re, self.im + o.im)
+}
+fun Complex.operator*(self, o: Complex): Complex {
+  return Complex::new(self.re * o.re - self.im * o.im, self.re * o.im + self.im * o.re)
+}
+fun Complex.operator==(ref self, o: Complex): bool {
+  return self.re == o.re
+}
+struct Vec {
+  data: [4]int
+}
+fun Vec.operator[](ref self, i: int): *int {
+  return &self.data[i]
+}
+struct Money {
+  cents: int
+}
+fun Money.operator<(self, o: Money): bool {
+  return self.cents < o.cents
+}
+fun max<T>(a, b: T): T {
+  if a > b {
+    return a
+  }
+  return b
+}
+fun main() {
+  var a = Complex::new(1, 2)
+  var b = Complex::new(3, 4)
+  var c = a * b + a
+  printi(c.re)
+  printi(c.im)
+  if a == a {
+    if a != b {
+      printi(1)
+    }
+  }
+  var v: Vec
+  v[2] = 7
+  v[2] += 1
+  printi(v[2])
+  var p = &v
+  printi(p[2])
+  var m: Money
+  m.cents = 1
+  var n: Money
+  n.cents = 2
+  if m < n {
+    if n > m {
+      if m <= n {
+        if n >= m {
+          printi(2)
+        }
+      }
+    }
+  }
+  printi(max(m, n).cents)
+}"
+
+try "$(printf "%s\n" 42 25 8 14 14 0 hi)" \
+"struct Button {
+  onClick: fun(int): int
+}
+fun double(x: int): int {
+  return x * 2
+}
+fun square(x: int): int {
+  return x * x
+}
+fun apply<T>(xs: [3]T, f: fun(T): T): [3]T {
+  var ys: [3]T
+  for var i = 0; i < 3; i += 1 {
+    ys[i] = f(xs[i])
+  }
+  return ys
+}
+fun pick(n: int): fun(int): int {
+  if n == 0 {
+    return double
+  }
+  return square
+}
+fun main() {
+  var f: fun(int): int = double
+  printi(f(21))
+  f = square
+  printi(f(5))
+  printi(pick(0)(4))
+  var xs: [3]int
+  xs[0] = 1
+  xs[1] = 2
+  xs[2] = 3
+  val ys = apply(xs, square)
+  printi(ys[0] + ys[1] + ys[2])
+  var b: Button
+  b.onClick = double
+  printi((b.onClick)(7))
+  var g: fun(int): int = nil
+  if g == nil {
+    printi(0)
+  }
+  var p: fun(string) = println
+  p(\"hi\")
+}"
+
+try "$(printf "%s\n" 2 12 5)" \
+"struct Calc {
+  op: fun(int, int): int
+  name: string
+}
+fun add(a: int, b: int): int {
+  return a + b
+}
+fun mul(a: int, b: int): int {
+  return a * b
+}
+fun Calc.twice(ref self, x: int): int {
+  return self.op(x, x)
+}
+fun main() {
+  var s: Calc
+  s.op = add
+  printi(s.op(1, 1))
+  var p = new Calc
+  p.op = mul
+  printi(p.op(3, 4))
+  printi(s.twice(5) / 2)
+}"
+
+try "$(printf "%s\n" 2 15 6 120 21 8)" \
+"fun makeAdder(n: int): fun(int): int {
+  return fun [n](x: int): int { return x + n }
+}
+fun apply(f: fun(int): int, x: int): int {
+  return f(x)
+}
+fun main() {
+  var count = 0
+  val inc = fun () { count += 1 }
+  inc()
+  inc()
+  printi(count)
+  val add5 = makeAdder(5)
+  printi(add5(10))
+  printi(apply(add5, 1))
+  fun fact(n: int): int {
+    if n == 0 {
+      return 1
+    }
+    return n * fact(n - 1)
+  }
+  printi(fact(5))
+  var k = 3
+  printi(apply(fun (x: int): int { return x * k }, 7))
+  var total = 0
+  fun outer(n: int) {
+    val inner = fun () { total += n }
+    inner()
+    inner()
+  }
+  outer(4)
+  printi(total)
+}"
+
+try_memcheck "" "-arc" \
+"struct Box {
+  v: int
+}
+fun counter(): fun(): int {
+  var b = new Box
+  return fun [b](): int {
+    b.v += 1
+    return b.v
+  }
+}
+fun main() {
+  var c = counter()
+  c()
+  printi(c())
+  defer c()
+  var s = \"hi\"
+  val p = fun [s]() { println(s) }
+  p()
+}"
+
+try_memcheck "" "" \
+"fun apply(f: fun(int): int, x: int): int {
+  return f(x)
+}
+fun main() {
+  for n in 1..3 {
+    val f = fun [n](x: int): int { return x + n }
+    printi(f(10) + apply(fun [n](x: int): int { return x * n }, 2))
+  }
+}"
+
+try "$(printf "%s\n" "42 ok" 5 4 3 4 2 3 1)" \
+"struct V {
+  x: int
+}
+fun V.add(self, n: int): int { return self.x + n }
+fun V.add(self, o: V): int { return self.x + o.x }
+fun V.operator+(self, n: int): V {
+  var r: V
+  r.x = self.x + n
+  return r
+}
+fun V.operator+(self, o: V): V {
+  var r: V
+  r.x = self.x + o.x
+  return r
+}
+fun print(i: int) {
+  printf(\"%d\".cstring(), i)
+}
+fun show(x: int): int { return 1 }
+fun show(p: *int): int { return 2 }
+fun show(s: string): int { return 3 }
+fun main() {
+  print(42)
+  println(\" ok\")
+  var v: V
+  v.x = 2
+  printi(v.add(3))
+  printi(v.add(v))
+  printi((v + 1).x)
+  printi((v + v).x)
+  printi(show(nil))
+  printi(\"s\".show())
+  printi(5.show())
+}"
+
+try "$(printf "%s\n" 101 3 1 5 3 4)" \
+"val base = 100
+struct P {
+  x: int
+  y: int
+}
+fun P.move(ref self, dx: int = 0, dy: int = 0) {
+  self.x += dx
+  self.y += dy
+}
+fun offset(n: int, by: int = base): int { return n + by }
+fun pick<T>(a: T, b: T, first: int = 1): T {
+  if first == 1 {
+    return a
+  }
+  return b
+}
+fun main() {
+  printi(offset(1))
+  printi(offset(by: 2, n: 1))
+  var p: P
+  p.move(dy: 5)
+  p.move(1)
+  printi(p.x)
+  printi(p.y)
+  printi(pick(3, 4))
+  printi(pick(3, 4, first: 0))
+}"
+
+try "$(printf "%s\n" 0 6 15 15 a,b,c 2 1 2 10 2)" \
+"fun sum(xs: ...int): int {
+  var s = 0
+  for var i = 0; i < xs.len; i += 1 {
+    s += xs[i]
+  }
+  return s
+}
+fun forward(xs: ...int): int { return sum(xs...) }
+fun show(sep: string, parts: ...string) {
+  for var i = 0; i < parts.len; i += 1 {
+    if i != 0 {
+      print(sep)
+    }
+    print(parts[i])
+  }
+  println(\"\")
+}
+fun count<T>(xs: ...T): int { return xs.len }
+fun f(a: int): int { return 1 }
+fun f(a: int, rest: ...int): int { return 2 }
+fun g(base: int = 10, xs: ...int): int { return base + xs.len }
+fun main() {
+  printi(sum())
+  printi(sum(1, 2, 3))
+  var a: [3]int
+  a[0] = 4
+  a[1] = 5
+  a[2] = 6
+  printi(sum(a...))
+  printi(forward(7, 8))
+  show(\",\", \"a\", \"b\", \"c\")
+  printi(count(1.5, 2.5))
+  printi(f(1))
+  printi(f(1, 2))
+  printi(g())
+  printi(g(1, 2))
+}"
+
+try "$(printf "%s\n" 42 9 visket 1 2)" \
+"val N = M * 2
+val M = 3
+val NAME = \"visket\"
+val HALF = 0.5
+val BIG = N > 5
+struct Buf {
+  data: [N + 1]int
+}
+fun main() {
+  var b: Buf
+  b.data[N] = 42
+  printi(b.data[6])
+  val K = N - 4
+  var xs: [K]int
+  xs[1] = 7
+  printi(xs[1] + K)
+  println(NAME)
+  if BIG == true {
+    printi(1)
+  }
+  if HALF < 1.0 {
+    printi(2)
+  }
+}"
+
+try "$(printf "%s\n" 120 25 9 720 4 3)" \
+"const fun fact(n: int): int {
+  var r = 1
+  for var i = 1; i <= n; i += 1 {
+    r *= i
+  }
+  return r
+}
+const fun squares(): [5]int {
+  var a: [5]int
+  for i in 0..4 {
+    a[i] = i * i
+  }
+  return a
+}
+const fun clamp(x: int, lo: int = 0, hi: int = 10): int {
+  if x < lo { return lo }
+  if x > hi { return hi }
+  return x
+}
+val F5 = fact(5)
+val SQ = squares()
+fun main() {
+  var buf: [fact(3)]int
+  buf[5] = 1
+  printi(F5)
+  printi(comptime fact(4) + 1)
+  printi(SQ[3])
+  var n = 6
+  printi(fact(n))
+  printi(comptime clamp(hi: 4, x: 7))
+  printi(comptime squares()[2] - 1)
+}"
+
+try "$(printf "%s\n" 24 8 4 16 6 8 7 4 16)" \
+"struct Header {
+  tag: int8
+  len: int
+  next: *Header
+  flag: bool
+}
+static_assert(sizeof(Header) == 24, \"Header must match the C layout\")
+val N = sizeof(int) * 2
+fun f(): int {
+  printi(99)
+  return 1
+}
+fun main() {
+  printi(sizeof(Header))
+  printi(alignof(Header))
+  printi(offsetof(Header, len))
+  printi(offsetof(Header, flag))
+  printi(sizeof([3]int16))
+  printi(N)
+  var x = 1.5
+  var y: typeof(x) = 2.5
+  var h: Header
+  var p: typeof(&h) = &h
+  var k: typeof(f()) = 7
+  p.len = k
+  printi(h.len)
+  printi(sizeof(typeof(y)))
+  static_assert(sizeof(typeof(p)) == 8, \"pointers must be 64 bits\")
+  printi(sizeof(string))
+}"
+
+try "$(printf "%s\n" 0 1 20 0 0 30 2 1)" \
+"fun t(n: int): bool {
+  printi(n)
+  return n > 0
+}
+fun main() {
+  if t(0) && t(1) {
+    printi(10)
+  }
+  if t(1) || t(2) {
+    printi(20)
+  }
+  if !(t(0) || t(0)) {
+    printi(30)
+  }
+  var i = 0
+  while i < 3 && !(i == 2) {
+    i += 1
+  }
+  printi(i)
+  val N = 0
+  val B = N == 0 || 10 / N > 1
+  if B && !false {
+    printi(1)
+  }
+}"
+
+try_opt "-D level=2 -D name=demo" "$(printf "%s\n" 2 1)" \
+"when level >= 2 && name == \"demo\" {
+  fun mode(): int { return 2 }
+} else when level == 1 {
+  fun mode(): int { return 1 }
+} else {
+  fun mode(): int { return 0 }
+}
+when optimize == !debug && target != \"\" {
+  val CONSISTENT = 1
+}
+fun main() {
+  printi(mode())
+  printi(CONSISTENT)
+}"
+
+try_opt "-target x86_64-linux-gnu" 1 \
+"when os == \"linux\" && arch == \"x86_64\" {
+  val OS = 1
+} else {
+  val OS = 0
+}
+fun main() {
+  printi(OS)
+}"
+
+try "$(printf "%s\n" 21 42 49 6 42 -1)" \
+"@link_name(\"abs\") fun absolute(x: int): int
+@export @link_name(\"vk_add\") fun add(a, b: int): int { return a + b }
+@link_name(\"visket_counter\") var counter = 5
+@inline fun sq(x: int): int { return x * x }
+@noinline @cold fun fail(): int { return 0 - 1 }
+@export val ANSWER = 42
+fun main() {
+  printi(absolute(0 - 21))
+  printi(add(20, 22))
+  printi(sq(7))
+  counter += 1
+  printi(counter)
+  printi(ANSWER)
+  printi(fail())
+}"
+
+try "$(printf "%s\n" 10 3)" \
+"@noreturn fun exit(code: int)
+@noreturn fun fail(code: int) {
+  printi(code)
+  exit(0)
+}
+fun pick(n: int): int {
+  if n == 1 {
+    return 10
+  }
+  fail(n)
+}
+fun main() {
+  printi(pick(1))
+  printi(pick(3))
+  printi(0)
+}"
+
+try "$(printf "%s\n" 1 2 3)" \
+"@noreturn fun exit(code: int)
+@noreturn fun spin() {
+  while true {}
+}
+@noreturn fun serve(n: int) {
+  var i = 0
+  while true {
+    i += 1
+    printi(i)
+    if i == n {
+      exit(0)
+    }
+  }
+}
+fun main() {
+  if 1 == 0 {
+    spin()
+  }
+  serve(3)
+}"
+
+try "$(printf "%s\n" 3 9 4)" \
+"@noreturn fun exit(code: int)
+@noreturn fun die() {
+  exit(0)
+}
+fun f(x: int): int {
+  val v = if x > 0 { x } else { die()
+    0 }
+  val w = if x > 5 { return 9
+    0 } else { v + 1 }
+  return w - 1
+}
+fun main() {
+  printi(f(3))
+  printi(f(7))
+  printi(if f(4) > 3 { f(4) } else { die()
+    0 })
+  printi(f(0))
+  printi(1)
+}"
+
+try "$(printf "%s\n" 2147483645 2147483646 2147483647 3 -2147483646 -2147483647 -2147483648 0 3 6 9 3 3 2 3 3)" \
+"const fun count(from, to, by: int): int {
+  var c = 0
+  for i in from..to step by {
+    c += 1
+  }
+  return c
+}
+fun main() {
+  val n = 2147483647
+  for i in n - 2..n { printi(i) }
+  var c = 0
+  for i in 0..2000000000 step 1000000000 { c += 1 }
+  printi(c)
+  val m = 0 - 2147483647 - 1
+  for i in m + 2..m step 0 - 1 { printi(i) }
+  for i in 0..<10 step 3 { printi(i) }
+  c = 0
+  for i in 0..<9 step 3 { c += 1 }
+  printi(c)
+  for i in 3..<1 step 0 - 1 { printi(i) }
+  c = 0
+  for i in 0 - 2000000000..2000000000 step 2000000000 { c += 1 }
+  printi(c)
+  static_assert(count(0, 2000000000, 1000000000) == 3, \"count must stop at the bound\")
+  printi(count(n - 2, n, 1))
+}"
+
+try "$(printf "%s\n" 1 2 3 0 1 2 10 6 2 0 5 10 3 2 1 -2 -1 0 5 16 27 h other 6 42 22)" \
+"fun sum(xs: ...int): int {
+  var total = 0
+  for x in xs {
+    total += x
+  }
+  return total
+}
+const fun down(): int {
+  var s = 0
+  for i in 10..1 step 0 - 3 {
+    s += i
+  }
+  return s
+}
+fun main() {
+  for i in 1..3 { printi(i) }
+  for j in 0..<3 { printi(j) }
+  for k in 10..1 step 0 - 4 { printi(k) }
+  for k in 0..10 step 5 { printi(k) }
+  for k in 3..<0 step 0 - 1 { printi(k) }
+  var n = 0 - 2
+  for k in n..0 { printi(k) }
+  var arr: [3]int
+  arr[0] = 5
+  arr[1] = 6
+  arr[2] = 7
+  for i, x in arr {
+    printi(i * 10 + x)
+  }
+  for c in \"hi\" {
+    if c == 'h' { println(\"h\") } else { println(\"other\") }
+  }
+  printi(sum(1, 2, 3))
+  var i = 42
+  for i in 1..2 { i += 100 }
+  printi(i)
+  printi(comptime down())
+}"
+
+try "$(printf "%s\n" 25 25 6 0 5)" \
+"struct Point {
+  x: int
+  y: int
+}
+fun Point.move(ref self, dx: int) {
+  self.x += dx
+}
+fun len2(in p: Point): int {
+  return p.x * p.x + p.y * p.y
+}
+fun sum(in xs: [3]int): int {
+  var s = 0
+  for x in xs { s += x }
+  return s
+}
+fun main() {
+  var p: Point
+  p.x = 3
+  p.y = 4
+  printi(len2(p))
+  val q = p
+  printi(len2(q))
+  var a: [3]int
+  a[0] = 1
+  a[1] = 2
+  a[2] = 3
+  val arr = a
+  printi(sum(arr))
+  printi(len2(*new Point))
+  var m = new Point
+  m.move(5)
+  printi(m.x)
+}"
+
+try "$(printf "%s\n" 4 8 4)" \
+"struct P { x: int }
+fun show(in q: *P) { printi(q.x) }
+fun main() {
+  var r = new P
+  r.x = 4
+  val p = r
+  val q = p
+  show(q)
+  printi(p.x + q.x)
+  var x = p.x
+  printi(x)
+}"
+
+try_opt "-strict-init" "$(printf "%s\n" 3 7 4 1)" \
+"fun read(ref v: int) {
+  v = 4
+}
+fun pick(c: bool): int {
+  var x: int
+  if c {
+    x = 3
+  } else if 1 < 2 {
+    x = 5
+  } else {
+    return 0
+  }
+  return x
+}
+fun main() {
+  printi(pick(true))
+  var y: int
+  var i = 0
+  while i < 2 {
+    i += 1
+  }
+  y = 7
+  printi(y)
+  var z: int
+  read(z)
+  printi(z)
+  var s: string
+  if z == 4 && { s = \"a\"; true } {
+    s = \"b\"
+  }
+  var p: *int
+  p = &z
+  printi(*p - 3)
+}"
+
+try_opt "-strict-init" "$(printf "%s\n" 6 3 5)" \
+"struct P {
+  x: int
+  y: int
+}
+fun P.init(ref self) {
+  self.x = 1
+  self.y = 2
+}
+fun show(in p: P) {
+  printi(p.x + p.y)
+}
+fun main() {
+  var p: P
+  p.x = 3
+  p.y = p.x
+  printi(p.x + p.y)
+  var q: P
+  q.init()
+  show(q)
+  var a: [2]int
+  a[0] = 1
+  a[a[0]] = 5
+  printi(a[1])
+}"
+
+try "$(printf "%s\n" -1 0 1 6 212 4 2)" \
+"fun sign(x: int): int {
+  return if x == 0 { 0 } else if x > 100 { 0 - 1 } else { 1 }
+}
+fun main() {
+  printi(sign(0 - 5))
+  printi(sign(0))
+  printi(sign(7))
+  val y = { val t = 3; t * 2 }
+  printi(y)
+  var total = 0
+  for i in 0..2 {
+    total += if i == 1 { 10 } else { { val k = i; k + 100 } }
+  }
+  printi(total)
+  {
+    val z = 4
+    printi(z)
+  }
+  var p = if y > 5 { new int } else { nil }
+  *p = 2
+  printi(*p)
+}"
+
+try "$(printf "%s\n" 30 20 10 303 302 301 203 202 201 103 102 101 3)" \
+"struct Node {
+  value: int
+  next: *Node
+}
+struct NodeIter {
+  cur: *Node
+}
+fun NodeIter.next(ref self): Option<int> {
+  var none: Option<int>
+  if self.cur == nil {
+    return none
+  }
+  val v = self.cur.value
+  self.cur = self.cur.next
+  return some(v)
+}
+struct Countdown {
+  n: int
+}
+fun Countdown.next(ref self): Option<int> {
+  var none: Option<int>
+  if self.n == 0 {
+    return none
+  }
+  self.n -= 1
+  return some(self.n + 1)
+}
+fun main() {
+  var list: *Node = nil
+  for i in 1..3 {
+    var n = new Node
+    n.value = i * 10
+    n.next = list
+    list = n
+  }
+  var it: NodeIter
+  it.cur = list
+  for x in it {
+    printi(x)
+  }
+  var c: Countdown
+  c.n = 3
+  for x in c {
+    for y in c {
+      printi(x * 100 + y)
+    }
+  }
+  printi(c.n)
+}"
+
+try "$(printf "%s\n" 0 1 end)" \
+"struct Range {
+  cur: int
+  end: int
+}
+fun Range.next(ref self): Option<int> {
+  var none: Option<int>
+  if self.cur >= self.end {
+    return none
+  }
+  self.cur += 1
+  return some(self.cur - 1)
+}
+fun main() {
+  var p = new Range
+  p.end = 2
+  for x in p { printi(x) }
+  for x in p { printi(x) }
+  println(\"end\")
+}"
+
+try "$(printf "%s\n" 49 2 1 30 100 100 100)" \
+"macro square(x: expr) {
+  x * x
+}
+macro swap_vals(a: ident, b: ident) {
+  val tmp = a
+  a = b
+  b = tmp
+}
+macro getter(name: ident, S: type, field: ident) {
+  fun name(s: *S): int {
+    return s.field
+  }
+}
+macro repeat(n: expr, body: expr) {
+  for var i = 0; i < n; i += 1 {
+    body
+  }
+}
+struct Point {
+  x: int
+  y: int
+}
+getter!(get_x, Point, x)
+getter!(get_y, Point, y)
+fun main() {
+  printi(square!(3 + 4))
+  var tmp = 1
+  var other = 2
+  swap_vals!(tmp, other)
+  printi(tmp)
+  printi(other)
+  var p = new Point
+  p.x = 10
+  p.y = 20
+  printi(get_x(p) + get_y(p))
+  var i = 100
+  repeat!(3, printi(i))
+}"
+
+try "$(printf "%s\n" 15 25 11 21 1)" \
+"val k = 10
+var g = 20
+macro addk(e: expr) {
+  e + k
+}
+macro addg(e: expr) {
+  e + g
+}
+macro show_sum(e: expr) {
+  val k = e
+  printi(k + g)
+}
+fun main() {
+  val k = 1
+  var g = 2
+  printi(addk!(5))
+  printi(addg!(5))
+  printi(addk!(k))
+  show_sum!(k)
+  printi(k)
+}"
+
+try "$(printf "%s\n" 1 user#42 1 52)" \
+"type Meters = float
+type UserId int
+type Ids = [2]UserId
+struct User {
+  id: UserId
+  height: Meters
+}
+fun UserId.show(self) {
+  print(\"user#\")
+  printi(int(self))
+}
+fun next(id: UserId): UserId { return id + UserId(1) }
+fun main() {
+  var u: User
+  u.id = UserId(41)
+  u.height = 1.5 + 0.25
+  var h: float = u.height
+  if h > 1.7 {
+    printi(1)
+  }
+  next(u.id).show()
+  var ids: Ids
+  ids[0] = u.id
+  ids[1] = next(ids[0])
+  if ids[0] < ids[1] {
+    printi(int(ids[1]) - int(ids[0]))
+  }
+  var k = next(u.id)
+  k += UserId(10)
+  printi(int(k))
+}"
+
+try_memcheck "memcheck: leaked object allocated at tmp.sl:7
+memcheck: 1 objects leaked" "" \
+"struct Foo {
+  X: int
+}
+fun main() {
+  var a = new Foo
+  var b = new Foo
+  delete a
+}"
+
+try_memcheck "" "" \
+"fun main() {
+  var a = new int
+  delete a
+}"
+
+try_memcheck "" "-arc" \
+"struct Node {
+  value: int
+  left: *Node
+  right: *Node
+}
+fun insert(node: *Node, value: int): *Node {
+  if node == nil {
+    var n = new Node
+    n.value = value
+    return n
+  }
+  if value < node.value {
+    node.left = insert(node.left, value)
+  } else {
+    node.right = insert(node.right, value)
+  }
+  return node
+}
+var root: *Node
+fun main() {
+  for i in 1..5 {
+    root = insert(root, i)
+  }
+  var tmp = root
+  root = insert(nil, 3)
+}"
+
+try_memcheck "" "-arc" \
+"struct Foo {
+  A: [2]*int
+}
+fun pass(foo: Foo): Foo { return foo }
+fun main() {
+  var foo: Foo
+  foo.A[0] = new int
+  foo.A[1] = foo.A[0]
+  var bar = pass(foo)
+  bar.A[0] = new int
+  new Foo
+}"
+
+try_memcheck "" "-arc" \
+"struct Foo {
+  X: int
+}
+fun show(foo: *Foo) {
+  printi(foo.X)
+}
+fun main() {
+  var foo = new Foo
+  defer show(foo)
+  foo = new Foo
+}"
+
+try_memcheck "" "-arc" \
+"struct Node {
+  v: int
+}
+fun make(v: int): *Node {
+  var n = new Node
+  n.v = v
+  return n
+}
+fun main() {
+  for i in 0..3 {
+    var n = if i % 2 == 0 { make(i) } else { val m = make(i); m }
+    var v = { val t = make(1); t }.v
+    if (if i == 3 { make(7) } else { nil }) == nil {
+      n.v = v
+    }
+  }
+}"
+
+try_memcheck "memcheck: leaked object allocated at tmp.sl:9
+memcheck: leaked object allocated at tmp.sl:8
+memcheck: 2 objects leaked" "-arc" \
+"struct Node {
+  next: *Node
+}
+fun main() {
+  var i = 0
+  while i < 2 {
+    var a = new Node
+    var b = new Node
+    if i == 0 {
+      a.next = b
+      b.next = a
+    }
+    i += 1
+  }
+}"
+
+try_memcheck "" "-arc" \
+"interface Shape {
+  fun area(): int
+}
+struct Rect {
+  w: int
+  next: *Rect
+}
+fun Rect.area(ref self): int {
+  return self.w
+}
+fun mk(): Shape {
+  var r = new Rect
+  r.w = 7
+  return r
+}
+fun box(w: int): Shape {
+  var r: Rect
+  r.w = w
+  r.next = new Rect
+  return r
+}
+fun main() {
+  var shapes: [3]Shape
+  shapes[0] = mk()
+  shapes[1] = box(2)
+  shapes[2] = shapes[1]
+  shapes[1] = mk()
+  printi(shapes[0].area() + shapes[2].area())
+}"
+
+try_memcheck "" "" \
+"interface Shape {
+  fun area(): int
+}
+struct Rect {
+  w: int
+}
+fun Rect.area(ref self): int {
+  return self.w
+}
+fun make(w: int): Rect {
+  var r: Rect
+  r.w = w
+  return r
+}
+fun disp(s: Shape) {
+  printi(s.area())
+}
+fun main() {
+  var q: Rect
+  q.w = 3
+  for i in 0..2 {
+    disp(q)
+    disp(make(i))
+  }
+}"
 
 echo "all tests passed"
\ No newline at end of file
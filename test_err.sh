@@ -19,6 +19,28 @@ try() {
   fi
 }
 
+try_arc() {
+  tmp_opt="$OPT"
+  OPT="$OPT -arc"
+  try "$@"
+  OPT="$tmp_opt"
+}
+
+try_strict() {
+  tmp_opt="$OPT"
+  OPT="$OPT -strict-init"
+  try "$@"
+  OPT="$tmp_opt"
+}
+
+try_target() {
+  tmp_opt="$OPT"
+  OPT="$OPT -target $1"
+  shift
+  try "$@"
+  OPT="$tmp_opt"
+}
+
 #
 try "tmp.sl:3 | type mismatch 'i32' and 'float'" \
 "fun main() {
@@ -96,6 +118,334 @@ try "tmp.sl:2 | already declared function 'test'" \
 "fun test() {}
 fun test() {}"
 
+try "tmp.sl:3 | no matching function for 'f(i32, i32)' (candidates: 'f(i32): i32', 'f(string): i32')" \
+"fun f(x: int): int { return 1 }
+fun f(s: string): int { return 2 }
+fun main() { f(1, 2) }"
+
+try "tmp.sl:3 | ambiguous call to 'f(nil)' (candidates: 'f(i32*): i32', 'f(float*): i32')" \
+"fun f(p: *int): int { return 1 }
+fun f(p: *float): int { return 2 }
+fun main() { f(nil) }"
+
+try "tmp.sl:2 | missing argument 'a' in call to 'f'" \
+"fun f(a: int, b: int = 1): int { return a + b }
+fun main() { f(b: 2) }"
+
+try "tmp.sl:2 | unknown parameter 'c' in call to 'f'" \
+"fun f(a: int, b: int = 1): int { return a + b }
+fun main() { f(1, c: 2) }"
+
+try "tmp.sl:2 | duplicate argument 'a' in call to 'f'" \
+"fun f(a: int, b: int = 1): int { return a + b }
+fun main() { f(1, a: 2) }"
+
+try "tmp.sl:2 | 'n' is not a constant expression" \
+"fun main() { var n = 3
+  var a: [n]int }"
+
+try "tmp.sl:1 | invalid array length '(0 - 1)'" \
+"fun main() { var a: [0 - 1]int }"
+
+try "tmp.sl:1 | initialization cycle for 'A'" \
+"val A = B
+val B = A
+fun main() {}"
+
+try "tmp.sl:4 | in compile-time evaluation of '(func-call f(0))'
+error: tmp.sl:2 | division by zero in constant expression" \
+"const fun f(n: int): int {
+  return 10 / n
+}
+val X = f(0)"
+
+try "tmp.sl:5 | in compile-time evaluation of '(func-call f())'
+error: tmp.sl:3 | cannot call non-const function 'g' at compile time" \
+"fun g(): int { return 1 }
+const fun f(): int {
+  return g()
+}
+val X = f()"
+
+try "tmp.sl:4 | in compile-time evaluation of '(func-call f(0))'
+error: tmp.sl:2 | compile-time evaluation exceeded the maximum call depth 1000" \
+"const fun f(n: int): int {
+  return f(n + 1)
+}
+fun main() { printi(comptime f(0)) }"
+
+try "tmp.sl:7 | in compile-time evaluation of '(func-call f(0))'
+error: tmp.sl:2 | compile-time evaluation exceeded 10000000 steps" \
+"const fun f(n: int): int {
+  while n == n {
+    n += 1
+  }
+  return n
+}
+val X = f(0)"
+
+try "tmp.sl:5 | in compile-time evaluation of '(func-call f())'
+error: tmp.sl:3 | index 3 out of range for '[3 x i32]'" \
+"const fun f(): int {
+  var a: [3]int
+  return a[3]
+}
+val X = f()"
+
+try "tmp.sl:2 | 'x' is not a constant expression" \
+"fun main() { var x = 1
+  printi(comptime x + 1) }"
+
+try "tmp.sl:2 | 'x' is not a constant expression" \
+"fun f(): int { return 1 }
+val x = f()"
+
+try "tmp.sl:1 | type mismatch 'double' and 'i32'" \
+"val x: float64 = 1"
+
+try "tmp.sl:1 | parameter 'p' of const function 'f' must be passed by value" \
+"const fun f(ref p: int): int { return p }"
+
+try "tmp.sl:1 | static assertion failed: int must be 64 bits" \
+"static_assert(sizeof(int) == 8, \"int must be 64 bits\")"
+
+try "tmp.sl:3 | 'n' is not a constant expression" \
+"fun main() {
+  var n = 4
+  static_assert(n == 4, \"n must be 4\")
+}"
+
+try "tmp.sl:2 | unresolved member 'y'" \
+"struct P { x: int }
+val X = offsetof(P, y)"
+
+try "tmp.sl:2 | incomplete type 'Opaque'" \
+"struct Opaque
+val X = sizeof(Opaque)"
+
+try_target i386-pc-linux-gnu "tmp.sl:4 | static assertion failed: d must follow the x86_64 layout" \
+"struct A { c: bool  d: float64 }
+static_assert(offsetof(A, d) == 4, \"d must be aligned to 4\")
+static_assert(sizeof(A) == 12, \"A must be 12 bytes\")
+static_assert(offsetof(A, d) == 8, \"d must follow the x86_64 layout\")"
+
+try_target x86_64-pc-linux-gnu "tmp.sl:3 | static assertion failed: d must not follow the i386 layout" \
+"struct A { c: bool  d: float64 }
+static_assert(sizeof(A) == 16, \"A must be 16 bytes\")
+static_assert(offsetof(A, d) == 4, \"d must not follow the i386 layout\")"
+
+try_target mips-unknown-linux-gnu "tmp.sl:1 | unknown data layout of target 'mips-unknown-linux-gnu'" \
+"val N = sizeof(int)"
+
+try "tmp.sl:1 | invalid operation in condition of when: false == linux" \
+"when platform == \"linux\" { fun f() {} }
+fun main() {}"
+
+try "tmp.sl:1 | invalid operation in condition of when: a == 1" \
+"when \"a\" == 1 { fun f() {} }
+fun main() {}"
+
+try "tmp.sl:1 | type mismatch 'i32' and 'i1'" \
+"fun main() { var b = 1 && 2 == 2 }"
+
+try "tmp.sl:2 | range must be integers, got 'float'" \
+"fun main() {
+  for x in 1.0..2.0 {}
+}"
+
+try "tmp.sl:2 | step of range cannot be zero" \
+"fun main() {
+  for x in 1..2 step 0 {}
+}"
+
+try "tmp.sl:2 | cannot use an index variable with a range" \
+"fun main() {
+  for i, x in 1..2 {}
+}"
+
+try "tmp.sl:2 | if expression must have an else branch" \
+"fun main() {
+  var x = if 1 == 1 { 1 }
+}"
+
+try "tmp.sl:2 | type mismatch 'i32' and 'float'" \
+"fun main() {
+  var x = if 1 == 1 { 1 } else { 1.0 }
+}"
+
+try "tmp.sl:2 | block does not yield a value" \
+"fun main() {
+  var x = if 1 == 1 { 1 } else {
+    var y = 2
+  }
+}"
+
+try "tmp.sl:5 | closure cannot outlive 'k' it captures by reference" \
+"fun main() {
+  var f = {
+    var k = 1
+    fun (): int { return k }
+  }
+}"
+
+try "tmp.sl:2 | cannot iterate over 'i32' (missing method 'next')" \
+"fun main() {
+  for x in 5 { printi(x) }
+}"
+
+try "tmp.sl:6 | next() must return a struct with 'value' and 'ok: bool' like Option<T>, got 'i32'" \
+"struct Once {
+  n: int
+}
+fun Once.next(ref self): int { return 0 }
+fun main() {
+  for x in new Once { printi(x) }
+}"
+
+try "tmp.sl:8 | in expansion of macro 'outer'
+error: tmp.sl:5 | in expansion of macro 'twice'
+error: tmp.sl:2 | unexpected operator: %string + %string" \
+"macro twice(x: expr) {
+  x + x
+}
+macro outer(v: expr) {
+  printi(twice!(v))
+}
+fun main() {
+  outer!(\"a\")
+}"
+
+try "tmp.sl:6 | unresolved variable 'y'" \
+"macro m(x: expr) {
+  var y = x
+}
+fun main() {
+  m!(1)
+  printi(y)
+}"
+
+try "tmp.sl:6 | in expansion of macro 'usex'
+error: tmp.sl:2 | unresolved variable 'x'" \
+"macro usex() {
+  x + 1
+}
+fun main() {
+  var x = 1
+  val y = usex!()
+}"
+
+try "tmp.sl:2 | undefined macro 'm'" \
+"fun main() {
+  m!(1)
+}"
+
+try "tmp.sl:3 | not enough arguments in call to macro 'm'" \
+"macro m(x: expr, y: expr) { x }
+fun main() {
+  m!(1)
+}"
+
+try "tmp.sl:3 | macro 'm' cannot be used as an expression" \
+"macro m(x: expr) { var y = x }
+fun main() {
+  printi(m!(1))
+}"
+
+try "tmp.sl:3 | macro expansion of 'r' is too deep" \
+"macro r() { r!() }
+fun main() {
+  r!()
+}"
+
+try "tmp.sl:1 | unknown attribute '@fast'" \
+"@fast fun f() {}"
+
+try "tmp.sl:1 | attribute '@inline' cannot be applied to variables" \
+"@inline var x = 1"
+
+try "tmp.sl:1 | attribute '@link_name' takes a string argument" \
+"@link_name fun f() {}"
+
+try "tmp.sl:1 | conflicting attributes '@inline' and '@noinline'" \
+"@inline @noinline fun f() {}"
+
+try "tmp.sl:2 | cannot overload function 'f' with a fixed symbol name" \
+"@export fun f(x: int) {}
+fun f(x: float) {}"
+
+try "tmp.sl:2 | already declared symbol 'f'" \
+"fun f() {}
+@link_name(\"f\") fun g() {}"
+
+try "tmp.sl:2 | already declared symbol 'zz'" \
+"fun main() {}
+@link_name(\"zz\") fun a() {}
+fun zz() {}"
+
+try "tmp.sl:1 | already declared symbol 'counter'" \
+"@link_name(\"counter\") fun a() {}
+var counter = 1
+fun main() {}"
+
+try "tmp.sl:1 | function 'f' with '@noreturn' cannot have a return type" \
+"@noreturn fun f(): int { return 1 }
+fun main() {}"
+
+try "tmp.sl:3 | cannot return from '@noreturn' function" \
+"@noreturn fun f(n: int) {
+  if n == 1 {
+    return;
+  }
+  while 1 == 1 {}
+}
+fun main() {}"
+
+try "tmp.sl:3 | end of '@noreturn' function is reachable" \
+"@noreturn fun f(n: int) {
+  while n == 1 {}
+}
+fun main() {}"
+
+try "tmp.sl:2 | type mismatch '%UserId' and 'i32'" \
+"type UserId int
+fun main() { var id: UserId = 1 }"
+
+try "tmp.sl:2 | type mismatch '%UserId' and 'i32'" \
+"type UserId int
+fun main() { var id = UserId(1) + 1 }"
+
+try "tmp.sl:2 | cannot convert 'float' to 'UserId'" \
+"type UserId int
+fun main() { var id = UserId(1.5) }"
+
+try "tmp.sl:2 | already declared type 'A'" \
+"struct A {}
+type A = int
+fun main() {}"
+
+try "tmp.sl:1 | only the last parameter can be variadic" \
+"fun f(xs: ...int, y: int) {}
+fun main() {}"
+
+try "tmp.sl:3 | cannot spread 'x'" \
+"fun sum(xs: ...int): int { return xs.len }
+fun main() { var x = 1
+  sum(x...) }"
+
+try "tmp.sl:3 | cannot spread arguments in call to non-variadic function 'f'" \
+"fun f(a: int, b: int): int { return a + b }
+fun main() { var a: [2]int
+  f(a...) }"
+
+try "tmp.sl:1 | constant '(xs[0])' cannot be reassigned" \
+"fun f(xs: ...int) { xs[0] = 1 }
+fun main() { f(1) }"
+
+try "tmp.sl:3 | cannot use overloaded function 'f' as a value" \
+"fun f(x: int): int { return 1 }
+fun f(s: string): int { return 2 }
+fun main() { var g = f }"
+
 try "tmp.sl:2 | type mismatch 'void' and 'i32'" \
 "fun test() {
   return 1
@@ -121,8 +471,8 @@ try "tmp.sl:1 | unknown type 'hoge'" \
 "fun test(): hoge {
 }"
 
-try "tmp.sl:1 | illegal charactor '@'" \
-"@"
+try "tmp.sl:1 | illegal charactor '$'" \
+"$"
 
 try "tmp.sl:2 | type mismatch 'i32' and 'float'" \
 "fun main() {
@@ -135,7 +485,8 @@ fun main() {
   test(1)
 }"
 
-try "tmp.sl:4 | a ref value must be an assignable variable" \
+try "tmp.sl:4 | cannot pass constant 'i' by ref
+error: tmp.sl:3 | 'i' is declared as a constant here" \
 "fun test(ref i: int){}
 fun main() {
   val i = 1
@@ -148,12 +499,115 @@ try "tmp.sl:1 | main func cannot have a return type" \
 try "tmp.sl:1 | main func cannot have parameters" \
 "fun main(i: int) {}"
 
-try "tmp.sl:3 | constant 'i' cannot be reassigned" \
+try "tmp.sl:3 | constant 'i' cannot be reassigned
+error: tmp.sl:2 | 'i' is declared as a constant here" \
 "fun main() {
   val i = 10
   i = 1
 }"
 
+try "tmp.sl:6 | cannot modify 'p.x' of constant 'p'
+error: tmp.sl:5 | 'p' is declared as a constant here" \
+"struct Point {
+  x: int
+}
+fun main() {
+  val p = new Point
+  p.x = 1
+}"
+
+try "tmp.sl:5 | cannot modify 'ps[1].x' of constant 'ps'
+error: tmp.sl:4 | 'ps' is declared as a constant here" \
+"struct Point {
+  x: int
+}
+fun f(in ps: [2]*Point) {
+  ps[1].x = 1
+}
+fun main() {}"
+
+try "tmp.sl:6 | cannot pass constant 'p' by ref
+error: tmp.sl:5 | 'p' is declared as a constant here" \
+"struct Point { x: int }
+fun Point.move(ref self) { self.x += 1 }
+fun inc(ref i: int) { i += 1 }
+fun main() {
+  val p = new Point
+  p.move()
+}"
+
+try "tmp.sl:2 | constant 'x' cannot be reassigned
+error: tmp.sl:1 | 'x' is declared as a constant here" \
+"fun f(in x: int) {
+  x = 2
+}
+fun main() {}"
+
+try "tmp.sl:4 | cannot take the address of constant 'p'
+error: tmp.sl:3 | 'p' is declared as a constant here" \
+"struct P { x: int }
+fun main() {
+  val p: P
+  var q = &p
+  q.x = 5
+}"
+
+try "tmp.sl:3 | cannot take the address of constant 'p'
+error: tmp.sl:2 | 'p' is declared as a constant here" \
+"struct P { x: int }
+fun f(in p: P) {
+  var q = &p
+  q.x = 9
+}
+fun main() {}"
+
+try "tmp.sl:4 | cannot copy the pointer of constant 'p'
+error: tmp.sl:3 | 'p' is declared as a constant here" \
+"struct P { x: int }
+fun main() {
+  val p = new P
+  var q = p
+  q.x = 3
+}"
+
+try "tmp.sl:5 | cannot copy the pointer 'h.next' of constant 'h'
+error: tmp.sl:3 | 'h' is declared as a constant here" \
+"struct Node { x: int  next: *Node }
+fun set(n: *Node) { n.x = 1 }
+fun f(in h: Node) {
+  var n: *Node
+  n = h.next
+  set(n)
+}
+fun main() {}"
+
+try "tmp.sl:5 | cannot copy the pointer of constant 'p'
+error: tmp.sl:4 | 'p' is declared as a constant here" \
+"struct P { x: int }
+fun set(q: *P) { q.x = 1 }
+fun main() {
+  val p = new P
+  set(p)
+}"
+
+try "tmp.sl:5 | cannot copy the pointer of constant 'c' yielded by if expression
+error: tmp.sl:4 | 'c' is declared as a constant here" \
+"struct H { p: *int }
+fun main() {
+  var h: H
+  val c = h
+  var q = if 1 == 1 { c.p } else { c.p }
+  *q = 5
+}"
+
+try "tmp.sl:4 | cannot copy the pointer of constant 'p' yielded by block expression
+error: tmp.sl:3 | 'p' is declared as a constant here" \
+"struct P { x: int }
+fun main() {
+  val p = new P
+  var q = { p }
+}"
+
 try "tmp.sl:2 | closing ' expected" \
 "fun main() {
   var c = 'hoge'
@@ -170,5 +624,345 @@ fun main() {
   var foo: Foo
   var a = foo.A
 }"
+try "tmp.sl:3 | cannot dereference 'i32'" \
+"fun main() {
+  var i = 1
+  *i = 2
+}"
+
+try "tmp.sl:2 | cannot take the address of '1'" \
+"fun main() {
+  var p = &1
+}"
+
+try "tmp.sl:3 | cannot delete 'i32'" \
+"fun main() {
+  var i = 1
+  delete i
+}"
+
+try "tmp.sl:3 | unexpected operator: i32* + i32*" \
+"fun main() {
+  var i = 1
+  var p = &i + &i
+}"
+
+try "tmp.sl:2 | expression in defer must be function call" \
+"fun main() {
+  defer 1
+}"
+
+try "tmp.sl:9 | cannot move out of '(p.a)'" \
+"struct Res {
+  fun drop(ref self: Res) {}
+}
+struct Pair {
+  a: Res
+}
+fun main() {
+  var p: Pair
+  var r = p.a
+}"
+
+try "tmp.sl:7 | 'r' may be used after it is moved
+error: tmp.sl:6 | 'r' is moved here" \
+"struct Res {
+  fun drop(ref self: Res) {}
+}
+fun main() {
+  var r: Res
+  var s = r
+  var t = r
+}"
+
+try "tmp.sl:10 | 'p' may be used after it is moved
+error: tmp.sl:8 | 'p' is moved here" \
+"struct Res {
+  fun drop(ref self: Res) {}
+}
+struct Pair { a: Res  b: Res }
+fun take(p: Pair) {}
+fun main(){
+  var p: Pair
+  if 1 == 1 { take(p) }
+  var r: Res
+  p.a = r
+}"
+
+try "tmp.sl:8 | 'r' is moved out in the loop without being assigned again" \
+"struct Res {
+  fun drop(ref self: Res) {}
+}
+fun take(r: Res) {}
+fun main() {
+  var r: Res
+  for i in 0..2 {
+    take(r)
+  }
+}"
+
+try "tmp.sl:2 | drop must be declared as 'fun drop(ref self: Res)'" \
+"struct Res {
+  fun drop(self: Res) {}
+}"
+
+try "tmp.sl:2 | unexpected function 'close' in struct 'Res'" \
+"struct Res {
+  fun close(ref self: Res) {}
+}"
+
+try "tmp.sl:2 | type mismatch for 'T': 'i32' and 'float'" \
+"fun f<T>(a, b: T) {}
+fun main() { f(1, 2.5) }"
+
+try "tmp.sl:3 | cannot infer type parameter 'T' in call to 'zero'" \
+"fun zero<T>(): T { var z: T
+  return z }
+fun main() { zero() }"
+
+try "tmp.sl:7 | in instantiation of 'add<Foo>'
+error: tmp.sl:3 | no operator '+' for 'Foo' ('Foo' defines no operators)" \
+"struct Foo { X: int }
+fun add<T>(a, b: T): T {
+  return a + b
+}
+fun main() {
+  var f: Foo
+  add(f, f)
+}"
+
+try "tmp.sl:2 | missing type arguments for 'Pair'" \
+"struct Pair<A, B> { a: A b: B }
+fun main() { var p: Pair }"
+
+try "tmp.sl:2 | wrong number of type arguments for 'Pair'" \
+"struct Pair<A, B> { a: A b: B }
+fun main() { var p: Pair<int> }"
+
+try "tmp.sl:6 | 'Circle' does not implement 'Shape' (missing method 'area')" \
+"interface Shape { fun area(): int }
+struct Circle { r: int }
+fun main() {
+  var c: Circle
+  c.r = 1
+  var s: Shape = c
+}"
+
+try "tmp.sl:6 | 'Rect' does not implement 'Shape' (wrong type for method 'area')" \
+"interface Shape { fun area(): int }
+struct Rect { w: int }
+fun area(r: Rect): float { return 1.0 }
+fun show(s: Shape) {}
+fun main() { var r: Rect
+  show(r) }"
+
+try "tmp.sl:5 | 'Person::new' cannot be called as a method" \
+"struct Person { age: int }
+fun Person.new(): Person { var p: Person
+  return p }
+fun main() { var p = Person::new()
+  p.new() }"
+
+try "tmp.sl:1 | unknown struct 'Person'" \
+"fun Person.age(self): int { return 0 }
+fun main() {}"
+
+try "tmp.sl:6 | no matching operator for 'V + i32' (candidates: 'V.operator+(ref float): V', 'V.operator+(V): V')" \
+"struct V { x: int }
+fun V.operator+(ref self, ref f: float): V { return self }
+fun V.operator+(self, o: V): V { return self }
+fun main() {
+  var v: V
+  v = v + 1
+}"
+
+try "tmp.sl:7 | no operator '-' for 'V' (defined: 'V.operator*(V): V', 'V.operator+(V): V')" \
+"struct V { x: int }
+fun V.operator*(self, o: V): V { return self }
+fun V.operator+(self, o: V): V { return self }
+fun main() {
+  var v: V
+  var w: V
+  v = v - w
+}"
+
+try "tmp.sl:2 | 'operator<' must return bool" \
+"struct V { x: int }
+fun V.operator<(self, o: V): int { return 0 }
+fun main() {}"
+
+try "tmp.sl:2 | 'operator+' must take self and one parameter" \
+"struct V { x: int }
+fun V.operator+(self): V { return self }
+fun main() {}"
+
+try "tmp.sl:2 | cannot overload operator '>'" \
+"struct V { x: int }
+fun V.operator>(self, o: V): bool { return true }
+fun main() {}"
+
+try "tmp.sl:3 | cannot call non-function '(a[0])'" \
+"fun main() {
+  var a: [2]int
+  a[0](1)
+}"
+
+try "tmp.sl:2 | cannot use generic function 'id' as a value" \
+"fun id<T>(x: T): T { return x }
+fun main() { var f: fun(int): int = id }"
+
+try "tmp.sl:2 | type mismatch 'fun(int, int): int' and 'fun(int): int'" \
+"fun inc(x: int): int { return x + 1 }
+fun main() { var f: fun(int, int): int = inc }"
+
+try "tmp.sl:4 | type mismatch 'fun(): *int' and 'fun(float64)'" \
+"fun main() {
+  var n = 1
+  var f = fun (): *int { return &n }
+  f = fun (x: float64) {}
+}"
+
+try "tmp.sl:3 | closure cannot outlive 'k' it captures by reference" \
+"fun counter(): fun(): int {
+  var k = 0
+  return fun (): int { return k }
+}
+fun main() {}"
+
+try "tmp.sl:5 | closure cannot outlive 'k' it captures by reference" \
+"fun main() {
+  var f: fun(): int = nil
+  if 1 == 1 {
+    var k = 1
+    f = fun (): int { return k }
+  }
+}"
+
+try "tmp.sl:5 | closure copying 'n' cannot outlive the scope it is created in, unless it is returned as is" \
+"fun main() {
+  var f: fun(): int = nil
+  if 1 == 1 {
+    var n = 1
+    f = fun [n](): int { return n }
+  }
+}"
+
+try "tmp.sl:3 | function parameter 'f' cannot outlive the call" \
+"var g: fun(): int = nil
+fun store(f: fun(): int) {
+  g = f
+}
+fun setup() {
+  var k = 42
+  store(fun (): int { return k })
+}
+fun main() {}"
+
+try "tmp.sl:3 | function parameter 'f' cannot outlive the call" \
+"fun id(f: fun(): int): fun(): int {
+  var h = f
+  return h
+}
+fun main() {}"
+
+try "tmp.sl:3 | constant 'k' cannot be reassigned" \
+"fun main() {
+  var k = 1
+  val f = fun [k]() { k = 2 }
+}"
+
+try_arc "tmp.sl:3 | cannot delete with automatic reference counting" \
+"fun main() {
+  var p = new int
+  delete p
+}"
+
+try_arc "tmp.sl:3 | cannot take the address with automatic reference counting" \
+"fun main() {
+  var i = 1
+  var p = &i
+}"
+
+try_arc "tmp.sl:5 | 'Rect' does not implement 'Shape' (method 'area' cannot take a pointer with automatic reference counting)" \
+"interface Shape { fun area(): int }
+struct Rect { w: int }
+fun area(r: *Rect): int { return r.w }
+fun main() { var r = new Rect
+  var s: Shape = r }"
+
+try_strict "tmp.sl:6 | 'x' may be used before it is assigned
+error: tmp.sl:2 | 'x' is declared here without a value" \
+"fun main() {
+  var x: int
+  if 1 == 1 {
+    x = 1
+  }
+  x += 1
+}"
+
+try_strict "tmp.sl:8 | 'n' may be used before it is assigned
+error: tmp.sl:2 | 'n' is declared here without a value" \
+"fun main() {
+  var n: int
+  var i = 0
+  while i < 3 {
+    n = i
+    i += 1
+  }
+  test(n)
+}
+fun test(a: int) {}"
+
+try_strict "tmp.sl:5 | 'p' may be used before it is assigned
+error: tmp.sl:2 | 'p' is declared here without a value" \
+"fun main() {
+  var p: *int
+  var i = 0
+  if i == 0 || { p = &i; 1 == 1 } {
+    *p = 1
+  }
+}"
+
+try_strict "tmp.sl:5 | 'p' may be used before it is assigned
+error: tmp.sl:3 | 'p' is declared here without a value" \
+"struct P { x: int }
+fun main() {
+  var p: P
+  var a: [2]int
+  val n = p.x + a[1]
+}"
+
+try_strict "tmp.sl:4 | 'a' may be used before it is assigned
+error: tmp.sl:3 | 'a' is declared here without a value" \
+"fun use(a: [2]int) {}
+fun main() {
+  var a: [2]int
+  use(a)
+}"
+
+try_strict "tmp.sl:6 | 'p' may be used before it is assigned
+error: tmp.sl:3 | 'p' is declared here without a value" \
+"struct P { x: int  y: int }
+fun main() {
+  var p: P
+  var n = 0
+  while n < 1 { p.x = 1; n += 1 }
+  p.y = p.x
+}"
+
+try_strict "tmp.sl:3 | 'p' may be used before it is assigned
+error: tmp.sl:2 | 'p' is declared here without a value" \
+"fun main() {
+  var p: int
+  var f = fun [p]() {}
+}"
+
+try_strict "tmp.sl:3 | 'p' may be used before it is assigned
+error: tmp.sl:2 | 'p' is declared here without a value" \
+"fun main() {
+  var p: int
+  var f = fun (): int { return p }
+  p = 1
+}"
 
 echo "all tests passed"
\ No newline at end of file